@@ -2,11 +2,17 @@ package appconfig
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
 	"github.com/fsnotify/fsnotify"
 	"gopkg.in/gcfg.v1"
 
@@ -21,6 +27,15 @@ type AppConfig struct {
 	GeoIP struct {
 		Directory string
 	}
+	Zones struct {
+		Directory []string // more directories with zone files
+		URL       []string // tar archives with zone files, polled over HTTP
+		Interval  string   // how often the URLs are checked, 30s by default
+		Atomic    bool     // apply the zones from each source together, or none if any fail
+
+		SerialPolicy string // default serial policy for the zones
+		SerialState  string // file keeping the serials from the serial policies
+	}
 	HTTP struct {
 		User     string
 		Password string
@@ -38,6 +53,29 @@ type AppConfig struct {
 	Health struct {
 		Directory string
 	}
+	Transfer struct {
+		Allow []string // networks allowed to transfer zones
+		Key   []string // TSIG keys that can be used for transfers
+		View  string   // the targeting view that is transferred, "@" by default
+
+		AlsoNotify []string // secondaries sent a NOTIFY when a zone changes
+	}
+	TSIG map[string]*struct {
+		Algorithm string
+		Secret    string
+	}
+	Update map[string]*struct {
+		Key []string // TSIG keys that can update the zone
+	}
+	Secondary map[string]*struct {
+		Primary []string // primary servers, IP with an optional port
+		Key     string   // TSIG key for the transfers and NOTIFY
+		Overlay string   // JSON zone file with targeting added to the zone
+	}
+	Reverse map[string]*struct {
+		From   []string // forward zones with the A and AAAA records
+		Prefer string   // first, shortest or all
+	}
 	Nodeping struct {
 		Token string
 	}
@@ -65,6 +103,31 @@ func (conf *AppConfig) GeoIPDirectory() string {
 	return geoip2.FindDB()
 }
 
+var tsigAlgorithms = []string{
+	dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512,
+}
+
+// TSIGKey returns the algorithm (default hmac-sha256) and the decoded
+// secret of the named [tsig] key.
+func (conf *AppConfig) TSIGKey(name string) (string, []byte, error) {
+	k, ok := conf.TSIG[name]
+	if !ok || k == nil {
+		return "", nil, fmt.Errorf("unknown TSIG key '%s'", name)
+	}
+	secret, err := base64.StdEncoding.DecodeString(k.Secret)
+	if err != nil || len(secret) == 0 {
+		return "", nil, fmt.Errorf("invalid secret for TSIG key '%s'", name)
+	}
+	algorithm := dns.HmacSHA256
+	if len(k.Algorithm) > 0 {
+		algorithm = dnsutil.Fqdn(strings.ToLower(k.Algorithm))
+	}
+	if !slices.Contains(tsigAlgorithms, algorithm) {
+		return "", nil, fmt.Errorf("unsupported algorithm '%s' for TSIG key '%s'", k.Algorithm, name)
+	}
+	return algorithm, secret, nil
+}
+
 func ConfigWatcher(ctx context.Context, fileName string) error {
 
 	watcher, err := fsnotify.NewWatcher()
@@ -0,0 +1,123 @@
+// Package dirwatch calls a function when the files in a directory
+// change, using filesystem events with a periodic rescan in case
+// events are missed.
+package dirwatch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Dir watches the files in a directory.
+type Dir struct {
+	Path string
+
+	// Match filters the file names (without the directory) that
+	// trigger a reload; nil matches all files.
+	Match func(name string) bool
+
+	// Delay is how long to wait after the first event for more changes,
+	// so a batch of files is handled together. Default 200ms.
+	Delay time.Duration
+
+	// Rescan is how often the function is called without any events,
+	// default one minute.
+	Rescan time.Duration
+}
+
+// Run calls fn when the files change until the context is done. fn is
+// called once when the watch is set up too, so changes from before it
+// started aren't missed. The directory itself is watched, so files
+// replaced by renaming a new file into place are noticed. If the
+// directory can't be watched (or it's removed) the watch is retried on
+// each rescan.
+func (d *Dir) Run(ctx context.Context, fn func()) {
+	delay := d.Delay
+	if delay == 0 {
+		delay = 200 * time.Millisecond
+	}
+	rescan := d.Rescan
+	if rescan == 0 {
+		rescan = time.Minute
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("could not watch %s, checking every %s: %s", d.Path, rescan, err)
+	} else {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+	watching := d.add(watcher)
+	fn()
+
+	ticker := time.NewTicker(rescan)
+	defer ticker.Stop()
+
+	var pending <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if filepath.Clean(ev.Name) == filepath.Clean(d.Path) {
+				if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+					log.Printf("%s was removed, watching again on the next rescan", d.Path)
+					watcher.Remove(d.Path)
+					watching = false
+				}
+			} else if d.Match != nil && !d.Match(filepath.Base(ev.Name)) {
+				continue
+			}
+			if pending == nil {
+				pending = time.After(delay)
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Printf("watching %s: %s", d.Path, err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) && pending == nil {
+				// events were lost, check everything
+				pending = time.After(delay)
+			}
+
+		case <-pending:
+			pending = nil
+			fn()
+
+		case <-ticker.C:
+			if !watching {
+				watching = d.add(watcher)
+			}
+			fn()
+		}
+	}
+}
+
+func (d *Dir) add(watcher *fsnotify.Watcher) bool {
+	if watcher == nil {
+		return false
+	}
+	if err := watcher.Add(d.Path); err != nil {
+		log.Printf("could not watch %s: %s", d.Path, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package dirwatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls atomic.Int32
+	d := &Dir{
+		Path:   dir,
+		Match:  func(name string) bool { return strings.HasSuffix(name, ".json") },
+		Delay:  20 * time.Millisecond,
+		Rescan: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx, func() { calls.Add(1) })
+
+	// wait for the watch to be set up
+	require.Eventually(t, func() bool {
+		os.WriteFile(filepath.Join(dir, "a.json"), []byte("{}"), 0644)
+		return calls.Load() > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	wait := func(msg string) {
+		t.Helper()
+		n := calls.Load()
+		assert.Eventually(t, func() bool { return calls.Load() > n }, 5*time.Second, 10*time.Millisecond, msg)
+	}
+
+	// several changes together are handled once
+	time.Sleep(50 * time.Millisecond)
+	n := calls.Load()
+	for _, name := range []string{"b.json", "c.json", "d.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, n+1, calls.Load(), "debounced")
+
+	// files not matching are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".b.json.tmp"), []byte("{}"), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, n+1, calls.Load(), "unmatched file")
+
+	// renamed into place
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		os.Rename(filepath.Join(dir, ".b.json.tmp"), filepath.Join(dir, "b.json"))
+	}()
+	wait("rename")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		os.Remove(filepath.Join(dir, "c.json"))
+	}()
+	wait("remove")
+}
+
+func TestDirRescan(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "zones")
+
+	var calls atomic.Int32
+	d := &Dir{Path: dir, Delay: 10 * time.Millisecond, Rescan: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx, func() { calls.Add(1) })
+
+	// the directory doesn't exist, so it's only checked on the rescans
+	require.Eventually(t, func() bool { return calls.Load() >= 2 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -51,11 +51,15 @@ func SupportedOption(option uint16) bool {
 // returned Msg is valid to be returned to the client (and should). For some
 // reason this response should not contain a question RR in the question section.
 func Version(req *dns.Msg) (*dns.Msg, error) {
-	opt := FindOPT(req)
-	if opt == nil {
+	// when unpacking the OPT record is removed and the version is set in
+	// the message header; the buffer size is set if there was an OPT
+	version := req.Version
+	if opt := FindOPT(req); opt != nil {
+		version = opt.Version()
+	} else if req.UDPSize == 0 {
 		return nil, nil
 	}
-	if opt.Version() == 0 {
+	if version == 0 {
 		return nil, nil
 	}
 	m := new(dns.Msg)
@@ -63,12 +67,9 @@ func Version(req *dns.Msg) (*dns.Msg, error) {
 	// zero out question section, wtf.
 	m.Question = nil
 
-	o := new(dns.OPT)
-	o.Hdr.Name = "."
-	o.SetVersion(0)
+	// the extended rcode is sent in an OPT record with version 0
 	m.Rcode = dns.RcodeBadVers
-	o.SetRcode(dns.RcodeBadVers)
-	m.Pseudo = []dns.RR{o}
+	m.UDPSize = req.UDPSize
 
 	return m, errors.New("EDNS0 BADVERS")
 }
@@ -92,6 +93,13 @@ The below wasn't from the edns package
 
 // SetSizeAndDo adds an OPT record that the reflects the intent from request.
 func SetSizeAndDo(req, m *dns.Msg) *dns.OPT {
+	// when unpacking the OPT record is removed and the buffer size
+	// and DO bit are set in the message header instead
+	if req.UDPSize > 0 {
+		m.UDPSize = req.UDPSize
+		m.Security = req.Security
+	}
+
 	o := FindOPT(req)
 	if o == nil {
 		return nil
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abh/geodns/v3/zones"
+)
+
+// runExport is the "export" command; it writes a zone file for each
+// targeting view of the zones.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("output", ".", "directory for the exported zone files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: geodns export [-output dir] zonefile...\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no zone files to export")
+	}
+
+	fileNameReplacer := strings.NewReplacer("[", "", "]", "", ":", "_", "/", "_")
+
+	for _, fileName := range fs.Args() {
+		zoneName := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+
+		zone := zones.NewZone(zoneName)
+		if err := zone.ReadZoneFile(fileName); err != nil {
+			return fmt.Errorf("reading %s: %s", fileName, err)
+		}
+
+		for _, view := range zone.Views() {
+			viewName := view.Name
+			if viewName == "@" {
+				viewName = "global"
+			}
+			exportName := filepath.Join(*output,
+				zoneName+"."+fileNameReplacer.Replace(viewName)+".zone")
+
+			if err := exportView(zone, view, exportName); err != nil {
+				return err
+			}
+			fmt.Println(exportName)
+		}
+	}
+
+	return nil
+}
+
+func exportView(zone *zones.Zone, view zones.View, fileName string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	if err := zone.WriteView(w, view); err != nil {
+		fh.Close()
+		return fmt.Errorf("writing %s: %s", fileName, err)
+	}
+	if err := w.Flush(); err != nil {
+		fh.Close()
+		return fmt.Errorf("writing %s: %s", fileName, err)
+	}
+	return fh.Close()
+}
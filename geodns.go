@@ -33,6 +33,7 @@ import (
 	"time"
 
 	"github.com/pborman/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sync/errgroup"
 
 	"go.ntppool.org/common/version"
@@ -54,6 +55,7 @@ var (
 	flagconfig      = flag.String("config", "./dns/", "directory of zone files")
 	flagconfigfile  = flag.String("configfile", "geodns.conf", "filename of config file (in 'config' directory)")
 	flagcheckconfig = flag.Bool("checkconfig", false, "check configuration and exit")
+	flagstrict      = flag.Bool("strict", false, "with -checkconfig, fail on warnings too")
 	flagidentifier  = flag.String("identifier", "", "identifier (hostname, pop name or similar)")
 	flaginter       = flag.String("interface", "*", "set the listener address")
 	flagport        = flag.String("port", "53", "default port number")
@@ -94,6 +96,14 @@ func main() {
 		applog.Enabled = true
 	}
 
+	if flag.Arg(0) == "export" {
+		if err := runExport(flag.Args()[1:]); err != nil {
+			log.Println(err)
+			os.Exit(2)
+		}
+		return
+	}
+
 	if len(*flagLogFile) > 0 {
 		applog.FileOpen(*flagLogFile)
 	}
@@ -121,13 +131,33 @@ func main() {
 			os.Exit(2)
 		}
 
-		dirName := *flagconfig
+		sources, err := zoneSources(*flagconfig, appconfig.Config)
+		if err != nil {
+			log.Printf("could not setup zone sources: %s", err)
+			os.Exit(2)
+		}
+		options, err := muxOptions(appconfig.Config)
+		if err != nil {
+			log.Printf("%s", err)
+			os.Exit(2)
+		}
+		options.Sources = sources
 
-		_, err = zones.NewMuxManager(dirName, &zones.NilReg{})
+		problems, err := zones.CheckZones(options)
 		if err != nil {
 			log.Println("Errors reading zones", err)
 			os.Exit(2)
 		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		errs, warnings := problems.Count(zones.SeverityError), problems.Count(zones.SeverityWarning)
+		if len(problems) > 0 {
+			fmt.Printf("%d errors, %d warnings\n", errs, warnings)
+		}
+		if errs > 0 || (*flagstrict && warnings > 0) {
+			os.Exit(2)
+		}
 
 		// todo: setup health stuff when configured
 
@@ -179,7 +209,7 @@ func main() {
 	}
 
 	if len(appconfig.Config.Health.Directory) > 0 {
-		go health.DirectoryReader(appconfig.Config.Health.Directory)
+		go health.DirectoryReader(ctx, appconfig.Config.Health.Directory)
 	}
 
 	// load (and re-load) zone data
@@ -222,6 +252,14 @@ func main() {
 	}
 
 	srv := server.NewServer(appconfig.Config, serverInfo)
+	if err := srv.SetupTransfers(appconfig.Config); err != nil {
+		log.Printf("could not setup zone transfers: %s", err)
+		os.Exit(2)
+	}
+	if err := srv.SetupUpdates(appconfig.Config); err != nil {
+		log.Printf("could not setup dynamic updates: %s", err)
+		os.Exit(2)
+	}
 
 	if qlc := appconfig.Config.AvroLog; len(qlc.Path) > 0 {
 
@@ -251,10 +289,26 @@ func main() {
 		srv.SetQueryLogger(ql)
 	}
 
-	muxm, err := zones.NewMuxManager(*flagconfig, srv)
+	sources, err := zoneSources(*flagconfig, appconfig.Config)
+	if err != nil {
+		log.Printf("could not setup zone sources: %s", err)
+		os.Exit(2)
+	}
+	options, err := muxOptions(appconfig.Config)
+	if err != nil {
+		log.Printf("%s", err)
+		os.Exit(2)
+	}
+	options.Sources = sources
+	muxm, err := zones.NewMuxManagerOptions(srv, options)
+	if muxm == nil {
+		log.Printf("could not setup secondary zones: %s", err)
+		os.Exit(2)
+	}
 	if err != nil {
 		log.Printf("error loading zones: %s", err)
 	}
+	prometheus.MustRegister(muxm.HistoryMetrics(), muxm.ScheduleMetrics())
 
 	g.Go(func() error {
 		muxm.Run(ctx)
@@ -301,3 +355,87 @@ func main() {
 	}
 	applog.FileClose()
 }
+
+// zoneSources returns the zone directory and the other sources
+// configured in the [zones] section.
+func zoneSources(dir string, config *appconfig.AppConfig) ([]zones.ZoneSource, error) {
+	atomic := config.Zones.Atomic
+	sources := []zones.ZoneSource{&zones.DirSource{Dir: dir, Atomic: atomic}}
+	for _, d := range config.Zones.Directory {
+		sources = append(sources, &zones.DirSource{Dir: d, Atomic: atomic})
+	}
+
+	var interval time.Duration
+	if len(config.Zones.Interval) > 0 {
+		var err error
+		interval, err = time.ParseDuration(config.Zones.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zones interval '%s': %s", config.Zones.Interval, err)
+		}
+	}
+	for _, u := range config.Zones.URL {
+		sources = append(sources, &zones.HTTPSource{URL: u, Interval: interval, Atomic: atomic})
+	}
+	return sources, nil
+}
+
+// muxOptions returns the serial policy settings from the [zones] section,
+// the [reverse "name"] zones and the [secondary "name"] zones.
+func muxOptions(config *appconfig.AppConfig) (zones.MuxOptions, error) {
+	options := zones.MuxOptions{}
+	if len(config.Zones.SerialPolicy) > 0 {
+		policy, err := zones.ParseSerialPolicy(config.Zones.SerialPolicy)
+		if err != nil {
+			return options, err
+		}
+		options.SerialPolicy = policy
+	}
+	serials, err := zones.NewSerialState(config.Zones.SerialState)
+	if err != nil {
+		return options, err
+	}
+	options.Serials = serials
+
+	options.Reverses = map[string]zones.ReverseConfig{}
+	for name, rc := range config.Reverse {
+		if rc == nil {
+			continue
+		}
+		prefer, err := zones.ParseReversePreference(rc.Prefer)
+		if err != nil {
+			return options, fmt.Errorf("reverse zone '%s': %s", name, err)
+		}
+		cfg := zones.ReverseConfig{From: rc.From, Prefer: prefer}
+		if err := cfg.Check(name); err != nil {
+			return options, err
+		}
+		options.Reverses[strings.ToLower(strings.TrimSuffix(name, "."))] = cfg
+	}
+
+	options.Secondaries = map[string]zones.SecondaryConfig{}
+	for name, sc := range config.Secondary {
+		if sc == nil {
+			continue
+		}
+		cfg := zones.SecondaryConfig{Overlay: sc.Overlay}
+		for _, p := range sc.Primary {
+			primary, err := zones.ServerAddress(p)
+			if err != nil {
+				return options, fmt.Errorf("secondary zone '%s': %s", name, err)
+			}
+			cfg.Primaries = append(cfg.Primaries, primary)
+		}
+		if len(sc.Key) > 0 {
+			algorithm, secret, err := config.TSIGKey(sc.Key)
+			if err != nil {
+				return options, fmt.Errorf("secondary zone '%s': %s", name, err)
+			}
+			cfg.KeyName, cfg.Algorithm, cfg.Secret = sc.Key, algorithm, secret
+		}
+		if err := cfg.Check(name); err != nil {
+			return options, err
+		}
+		options.Secondaries[name] = cfg
+	}
+	return options, nil
+}
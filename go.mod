@@ -4,7 +4,6 @@ go 1.26.0
 
 require (
 	codeberg.org/miekg/dns v0.6.61
-	github.com/abh/errorutil v1.0.0
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/golang/geo v0.0.0-20260129164528-943061e2742c
 	github.com/hamba/avro/v2 v2.31.0
@@ -1,6 +1,7 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/abh/geodns/v3/dirwatch"
 )
 
 type StatusFile struct {
@@ -26,17 +29,29 @@ func NewStatusFile(filename string) *StatusFile {
 	}
 }
 
-// DirectoryReader loads (and regularly re-loads) health
+// DirectoryReader loads (and re-loads when they change) health
 // .json files from the specified files into the default
-// health registry.
-func DirectoryReader(dir string) {
-	for {
+// health registry. The files are first loaded when the directory
+// is being watched.
+func DirectoryReader(ctx context.Context, dir string) {
+	reload := func() {
 		err := reloadDirectory(dir)
 		if err != nil {
 			log.Printf("loading health data: %s", err)
 		}
-		time.Sleep(1 * time.Second)
 	}
+
+	d := &dirwatch.Dir{
+		Path:   dir,
+		Match:  isStatusFile,
+		Rescan: 30 * time.Second,
+	}
+	d.Run(ctx, reload)
+}
+
+func isStatusFile(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".json") &&
+		!strings.HasPrefix(path.Base(fileName), ".")
 }
 
 func reloadDirectory(dir string) error {
@@ -51,9 +66,7 @@ func reloadDirectory(dir string) error {
 
 	for _, file := range dirlist {
 		fileName := file.Name()
-		if !strings.HasSuffix(strings.ToLower(fileName), ".json") ||
-			strings.HasPrefix(path.Base(fileName), ".") ||
-			file.IsDir() {
+		if !isStatusFile(fileName) || file.IsDir() {
 			continue
 		}
 		statusName := fileName[0:strings.LastIndex(fileName, ".")]
@@ -1,6 +1,12 @@
 package health
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestStatusFile(t *testing.T) {
 	sf := NewStatusFile("test.json")
@@ -17,3 +23,39 @@ func TestStatusFile(t *testing.T) {
 	}
 	registry.Add("test", sf)
 }
+
+func TestDirectoryReader(t *testing.T) {
+	dir := t.TempDir()
+	write := func(data string) {
+		// written like a deploy would, renamed into place
+		tmp := filepath.Join(dir, ".dirtest.json.tmp")
+		if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "dirtest.json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"www":2}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go DirectoryReader(ctx, dir)
+
+	waitFor := func(st StatusType) {
+		t.Helper()
+		for i := 0; i < 500; i++ {
+			if GetStatus("dirtest/www") == st {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("dirtest/www should have been %s", st)
+	}
+
+	waitFor(StatusHealthy)
+	write(`{"www":1}`)
+	waitFor(StatusUnhealthy)
+	os.Remove(filepath.Join(dir, "dirtest.json"))
+	waitFor(StatusUnknown)
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/abh/geodns/v3/appconfig"
@@ -70,6 +73,11 @@ func NewHTTPServer(mm *zones.MuxManager, serverInfo *monitor.ServerInfo) *httpSe
 	}
 	hs.mux.HandleFunc("/", hs.mainServer)
 	hs.mux.Handle("/metrics", promhttp.Handler())
+	hs.mux.HandleFunc("GET /history", hs.zoneHistory)
+	hs.mux.HandleFunc("GET /zones/{zone}/history", hs.zoneHistory)
+	hs.mux.HandleFunc("POST /zones/{zone}/pin", hs.authenticated(hs.zonePin))
+	hs.mux.HandleFunc("POST /zones/{zone}/unpin", hs.authenticated(hs.zonePin))
+	hs.setupZoneAPI()
 
 	return hs
 }
@@ -120,6 +128,39 @@ func (hs *httpServer) mainServer(w http.ResponseWriter, req *http.Request) {
 	io.WriteString(w, `GeoDNS `+hs.serverInfo.Version+`\n`)
 }
 
+// zoneHistory returns the load history of all zones, or of one zone.
+func (hs *httpServer) zoneHistory(w http.ResponseWriter, req *http.Request) {
+	history := hs.zones.History()
+	if name := req.PathValue("zone"); len(name) > 0 {
+		history = slices.DeleteFunc(history, func(h zones.ZoneHistory) bool { return h.Zone != name })
+		if len(history) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(history)
+}
+
+// zonePin pins a zone to the version in the "version" form value (the
+// current one if it's not set), or unpins it.
+func (hs *httpServer) zonePin(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("zone")
+	var err error
+	if strings.HasSuffix(req.URL.Path, "/unpin") {
+		err = hs.zones.Unpin(name)
+	} else {
+		err = hs.zones.Pin(name, req.FormValue("version"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hs.zoneHistory(w, req)
+}
+
 type basicauth struct {
 	h http.Handler
 }
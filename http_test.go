@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/abh/geodns/v3/appconfig"
 	"github.com/abh/geodns/v3/targeting"
 	"github.com/abh/geodns/v3/targeting/geoip2"
 	"github.com/abh/geodns/v3/zones"
+
+	dns "codeberg.org/miekg/dns"
 )
 
 func TestHTTP(t *testing.T) {
@@ -42,4 +49,122 @@ func TestHTTP(t *testing.T) {
 		t.Log("/version didn't start with 'GeoDNS '")
 		t.Fail()
 	}
+
+	res, err = http.Get(baseurl + "/zones/test.example.com/history")
+	require.Nil(t, err)
+	history := []zones.ZoneHistory{}
+	require.Nil(t, json.NewDecoder(res.Body).Decode(&history))
+	require.Len(t, history, 1)
+	require.Equal(t, "loaded", history[0].Attempts[0].Result)
+
+	res, err = http.Get(baseurl + "/history")
+	require.Nil(t, err)
+	history = []zones.ZoneHistory{}
+	require.Nil(t, json.NewDecoder(res.Body).Decode(&history))
+	require.Greater(t, len(history), 1)
+
+	res, err = http.Get(baseurl + "/zones/nope.example/history")
+	require.Nil(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// not allowed without a password
+	res, err = http.Post(baseurl+"/zones/test.example.com/pin", "", nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestZoneAPI(t *testing.T) {
+	user, password := appconfig.Config.HTTP.User, appconfig.Config.HTTP.Password
+	appconfig.Config.HTTP.User, appconfig.Config.HTTP.Password = "api", "secret"
+	t.Cleanup(func() {
+		appconfig.Config.HTTP.User, appconfig.Config.HTTP.Password = user, password
+	})
+
+	dir := t.TempDir()
+	data := `{ "data": { "": { "ns": [ "ns1.example.net" ] }, "www": { "a": [ [ "192.0.2.1", 10 ] ] } } }`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com.json"), []byte(data), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "history.json"), []byte(data), 0644))
+	mm, err := zones.NewMuxManager(dir, &zones.NilReg{})
+	require.NoError(t, err)
+	srv := httptest.NewServer(&basicauth{h: NewHTTPServer(mm, serverInfo).Mux()})
+	defer srv.Close()
+
+	request := func(method, path, etag, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("api", "secret")
+		if len(etag) > 0 {
+			req.Header.Set("If-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+	www := func() string {
+		l := mm.Zones()["example.com"].Labels["www"]
+		if l == nil {
+			return ""
+		}
+		return l.Records[dns.TypeA][0].RR.String()
+	}
+
+	res, err := http.Get(srv.URL + "/zones")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = request("GET", "/zones", "", "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	list := []zones.ZoneInfo{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	require.Len(t, list, 2)
+	require.True(t, list[0].Editable)
+
+	// a zone can be called history
+	res = request("GET", "/zones/history", "", "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	contents := zones.ZoneContents{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&contents))
+	require.Equal(t, "history", contents.Zone)
+
+	res = request("GET", "/zones/example.com/labels/www/a", "", "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	require.Equal(t, `"`+list[0].Hash+`"`, etag)
+
+	res = request("PUT", "/zones/example.com/labels/www/a", "", `[ [ "192.0.2.2", 10 ] ]`)
+	require.Equal(t, http.StatusPreconditionRequired, res.StatusCode)
+
+	res = request("PUT", "/zones/example.com/labels/www/a", etag, `[ [ "192.0.2.2", 10 ] ]`)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Contains(t, www(), "192.0.2.2")
+	newEtag := res.Header.Get("ETag")
+	require.NotEqual(t, etag, newEtag)
+
+	// changes to an old version are rejected
+	res = request("PUT", "/zones/example.com/labels/www/a", etag, `[ [ "192.0.2.3", 10 ] ]`)
+	require.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+
+	// and so are changes the zone reader rejects
+	res = request("PUT", "/zones/example.com/labels/www/a", newEtag, `[ [ "not an ip" ] ]`)
+	require.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+	res = request("PUT", "/zones/example.com/labels/www/hinfo", newEtag, `[ "cpu os" ]`)
+	require.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+	require.Contains(t, www(), "192.0.2.2")
+
+	res = request("PUT", "/zones/example.com/labels/api.europe", newEtag, `{ "a": [ [ "192.0.2.4" ] ], "ttl": 30 }`)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = request("DELETE", "/zones/example.com/labels/www", res.Header.Get("ETag"), "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Empty(t, www())
+	res = request("GET", "/zones/example.com/labels/www", "", "")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// the changes are in the zone file
+	mm2, err := zones.NewMuxManager(dir, &zones.NilReg{})
+	require.NoError(t, err)
+	zone := mm2.Zones()["example.com"]
+	require.Nil(t, zone.Labels["www"])
+	require.Equal(t, 30, zone.Labels["api.europe"].Ttl)
 }
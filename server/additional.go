@@ -0,0 +1,106 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/targeting/geo"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// additional adds the addresses for NS, MX and SRV targets in the zone to
+// the additional section, as long as the response stays within size.
+// Anything that doesn't fit is left out, and it returns false. With sign
+// the records are added with their RRSIGs, except glue below a
+// delegation.
+func additional(m *dns.Msg, z *zones.Zone, targets []string, location *geo.Location, size int, sign bool) bool {
+	seen := []string{}
+
+	for _, rr := range slices.Concat(m.Answer, m.Ns) {
+		var name string
+		switch rr := rr.(type) {
+		case *dns.NS:
+			name = rr.Ns
+		case *dns.MX:
+			name = rr.Mx
+		case *dns.SRV:
+			name = rr.Target
+		default:
+			continue
+		}
+
+		name = strings.ToLower(name)
+		if slices.Contains(seen, name) {
+			continue
+		}
+		seen = append(seen, name)
+
+		rrs := addressRecords(z, name, targets, location)
+		if len(rrs) == 0 {
+			continue
+		}
+		if sign {
+			s, _ := zoneLabel(z, name)
+			if _, delegation := z.FindDelegation(s, targets); delegation == nil {
+				signed, err := signRRs(z, rrs)
+				if err != nil {
+					applog.Printf("[zone %s] %s", z.Origin, err)
+					return false
+				}
+				rrs = signed
+			}
+		}
+
+		extra := m.Extra
+		m.Extra = append(m.Extra, rrs...)
+		if m.Len() > size {
+			m.Extra = extra
+			return false
+		}
+	}
+	return true
+}
+
+// addressRecords returns the A and AAAA records for name if it's in the
+// zone, picked with the same targeting and health checks as the answer.
+func addressRecords(z *zones.Zone, name string, targets []string, location *geo.Location) []dns.RR {
+	s, ok := zoneLabel(z, name)
+	if !ok || len(s) == 0 {
+		return nil
+	}
+
+	rrs := []dns.RR{}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		for _, match := range z.FindLabels(s, targets, []uint16{qtype}) {
+			if match.Type != qtype {
+				continue
+			}
+			loc := location
+			if !match.Label.Closest {
+				loc = nil
+			}
+			records := z.Picker(match.Label, qtype, match.Label.MaxHosts, loc)
+			for _, record := range records {
+				rr := record.RR.Clone()
+				rr.Header().Name = name
+				rrs = append(rrs, rr)
+			}
+			if len(records) > 0 {
+				break
+			}
+		}
+	}
+	return rrs
+}
+
+// zoneLabel returns the label name for name, if it's in the zone.
+func zoneLabel(z *zones.Zone, name string) (string, bool) {
+	origin := z.Origin + "."
+	if !dnsutil.IsBelow(origin, name) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(name), origin), "."), true
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/targeting/geo"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// maxCNAMEChain is how many CNAMEs in the zone are followed for an answer
+const maxCNAMEChain = 8
+
+// chaseCNAME follows the CNAME at the end of the answer section to names
+// in the same zone, adding the records picked for the target (with the
+// same targeting as the query) to the answer.
+func chaseCNAME(m *dns.Msg, z *zones.Zone, qtype uint16, targets []string, location *geo.Location) {
+	if qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return
+	}
+
+	seen := []string{strings.ToLower(m.Question[0].Header().Name)}
+
+	for range maxCNAMEChain {
+		if len(m.Answer) == 0 {
+			return
+		}
+		cname, ok := m.Answer[len(m.Answer)-1].(*dns.CNAME)
+		if !ok {
+			return
+		}
+
+		target := cname.Target
+		if slices.Contains(seen, strings.ToLower(target)) {
+			applog.Printf("[zone %s] CNAME loop at %s", z.Origin, target)
+			return
+		}
+		seen = append(seen, strings.ToLower(target))
+
+		s, ok := zoneLabel(z, target)
+		if !ok {
+			return
+		}
+		if _, delegation := z.FindDelegation(s, targets); delegation != nil {
+			return
+		}
+
+		rrs := pickAnswer(z, z.FindLabels(s, targets, []uint16{dns.TypeMF, dns.TypeCNAME, qtype}), target, location)
+		if len(rrs) == 0 {
+			return
+		}
+		m.Answer = append(m.Answer, rrs...)
+	}
+}
+
+// pickAnswer returns the records from the first label match that has
+// any (healthy) records, named name.
+func pickAnswer(z *zones.Zone, matches []zones.LabelMatch, name string, location *geo.Location) []dns.RR {
+	for _, match := range matches {
+		label := match.Label
+
+		loc := location
+		if !label.Closest {
+			loc = nil
+		}
+
+		var rrs []dns.RR
+		for _, record := range z.Picker(label, match.Type, label.MaxHosts, loc) {
+			rr := record.RR.Clone()
+			rr.Header().Name = name
+			rrs = append(rrs, rr)
+		}
+		if len(rrs) > 0 {
+			return rrs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"slices"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// signMsg adds RRSIGs for the RRsets in the answer and authority sections
+// if the zone is signed and the client asked for DNSSEC records. The
+// additional section is signed as it's added, in additional.
+func signMsg(req, m *dns.Msg, z *zones.Zone) {
+	if z.Signer == nil || !req.Security {
+		return
+	}
+
+	var err error
+	if m.Answer, err = signRRs(z, m.Answer); err != nil {
+		applog.Printf("[zone %s] %s", z.Origin, err)
+	}
+	if m.Ns, err = signRRs(z, m.Ns); err != nil {
+		applog.Printf("[zone %s] %s", z.Origin, err)
+	}
+}
+
+// signRRs returns the records with the RRSIG for each RRset added
+// after the RRset.
+func signRRs(z *zones.Zone, rrs []dns.RR) ([]dns.RR, error) {
+	if len(rrs) == 0 {
+		return rrs, nil
+	}
+
+	signed := make([]dns.RR, 0, len(rrs)*2)
+
+	for _, rrset := range rrsets(rrs) {
+		signed = append(signed, rrset...)
+
+		// delegation NS records aren't authoritative in the parent,
+		// so they aren't signed (RFC 4035 section 2.2)
+		if t := dns.RRToType(rrset[0]); t == dns.TypeRRSIG ||
+			t == dns.TypeNS && !dns.EqualName(rrset[0].Header().Name, z.Origin+".") {
+			continue
+		}
+
+		sigs, err := z.Signer.Sign(rrset)
+		if err != nil {
+			return rrs, err
+		}
+		signed = append(signed, sigs...)
+	}
+
+	return signed, nil
+}
+
+// rrsets groups the records by name and type, keeping the order
+// they were first seen in.
+func rrsets(rrs []dns.RR) [][]dns.RR {
+	sets := [][]dns.RR{}
+
+	for _, rr := range rrs {
+		t := dns.RRToType(rr)
+		i := slices.IndexFunc(sets, func(set []dns.RR) bool {
+			return dns.RRToType(set[0]) == t && dns.EqualName(set[0].Header().Name, rr.Header().Name)
+		})
+		if i < 0 {
+			sets = append(sets, []dns.RR{rr})
+			continue
+		}
+		sets[i] = append(sets[i], rr)
+	}
+
+	return sets
+}
+
+// denialNSEC returns the "compact denial of existence" NSEC record for
+// name (RFC 9824). The next name is the immediate successor of name so
+// the record doesn't reveal anything about the rest of the zone and can
+// be generated on the fly. For names that don't exist the type bitmap
+// only has NXNAME.
+func denialNSEC(z *zones.Zone, name string, types []uint16) *dns.NSEC {
+	bitmap := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	for _, t := range types {
+		if t == dns.TypeMF || slices.Contains(bitmap, t) {
+			continue
+		}
+		bitmap = append(bitmap, t)
+	}
+	slices.Sort(bitmap)
+
+	return &dns.NSEC{
+		Hdr: dns.Header{
+			Name:  name,
+			Class: dns.ClassINET,
+			TTL:   z.NegativeTTL(),
+		},
+		NSEC: rdata.NSEC{
+			// a literal zero octet label, \000 in presentation format
+			NextDomain: "\x00." + name,
+			TypeBitMap: bitmap,
+		},
+	}
+}
+
+// labelTypes returns the record types available in the matched labels,
+// excluding qtype.
+func labelTypes(matches []zones.LabelMatch, qtype uint16) []uint16 {
+	types := []uint16{}
+	for _, match := range matches {
+		if match.Label == nil {
+			continue
+		}
+		for t, records := range match.Label.Records {
+			if t == qtype || len(records) == 0 || slices.Contains(types, t) {
+				continue
+			}
+			types = append(types, t)
+		}
+	}
+	return types
+}
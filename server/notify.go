@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/zones"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NOTIFY (RFC 1996) tells the secondaries a zone changed, so they
+// don't wait for the SOA refresh before transferring it.
+
+const (
+	notifyTries   = 5
+	notifyTimeout = 2 * time.Second
+)
+
+type notifier struct {
+	targets []netip.AddrPort // sent for all zones
+
+	// NOTIFY messages are signed if a key is set
+	keyName string
+	key     *tsigKey
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	results *prometheus.CounterVec
+	acked   *prometheus.GaugeVec
+
+	mu      sync.Mutex
+	serials map[string]uint32
+}
+
+func newNotifier(targets []netip.AddrPort, metrics *serverMetrics) *notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &notifier{
+		targets: targets,
+		ctx:     ctx,
+		cancel:  cancel,
+		results: metrics.Notifies,
+		acked:   metrics.NotifySerial,
+		serials: map[string]uint32{},
+	}
+}
+
+// zoneLoaded sends NOTIFY messages for the zone if the serial changed
+// since it was loaded last.
+func (n *notifier) zoneLoaded(z *zones.Zone) {
+	soa, ok := z.SoaRR().(*dns.SOA)
+	if !ok {
+		return
+	}
+
+	n.mu.Lock()
+	last, seen := n.serials[z.Origin]
+	n.serials[z.Origin] = soa.Serial
+	n.mu.Unlock()
+
+	if !seen || last == soa.Serial {
+		return
+	}
+
+	targets := append(slices.Clone(n.targets), z.Options.AlsoNotify...)
+	slices.SortFunc(targets, netip.AddrPort.Compare)
+	for _, target := range slices.Compact(targets) {
+		go n.notify(z.Origin, soa, target)
+	}
+}
+
+func (n *notifier) zoneRemoved(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.serials, name)
+}
+
+func (n *notifier) current(name string, serial uint32) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s, ok := n.serials[name]
+	return ok && s == serial
+}
+
+// notify sends the NOTIFY to target until it's acknowledged, giving up
+// after notifyTries or when a newer serial is loaded.
+func (n *notifier) notify(name string, soa *dns.SOA, target netip.AddrPort) {
+	wait := notifyTimeout
+	for try := 1; ; try++ {
+		if !n.current(name, soa.Serial) {
+			// the zone was changed again, or removed
+			return
+		}
+
+		err := n.send(name, soa, target)
+		if err == nil {
+			log.Printf("[zone %s] NOTIFY for serial %d acknowledged by %s", name, soa.Serial, target)
+			n.results.WithLabelValues(name, "ack").Inc()
+			n.acked.WithLabelValues(name, target.String()).Set(float64(soa.Serial))
+			return
+		}
+
+		if try >= notifyTries {
+			log.Printf("[zone %s] NOTIFY for serial %d to %s failed, giving up: %s", name, soa.Serial, target, err)
+			n.results.WithLabelValues(name, "failed").Inc()
+			return
+		}
+		log.Printf("[zone %s] NOTIFY for serial %d to %s failed (try %d): %s", name, soa.Serial, target, try, err)
+		n.results.WithLabelValues(name, "retry").Inc()
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *notifier) send(name string, soa *dns.SOA, target netip.AddrPort) error {
+	m := dns.NewMsg(dnsutil.Fqdn(name), dns.TypeSOA)
+	m.Opcode = dns.OpcodeNotify
+	m.Authoritative = true
+	m.RecursionDesired = false
+	m.Answer = []dns.RR{soa}
+
+	if n.key != nil {
+		m.Pseudo = []dns.RR{dns.NewTSIG(n.keyName, n.key.algorithm, 0)}
+		if err := dns.TSIGSign(m, n.key.signer, &dns.TSIGOption{}); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, notifyTimeout)
+	defer cancel()
+
+	c := dns.NewClient()
+	r, _, err := c.Exchange(ctx, m, "udp", target.String())
+	if err != nil {
+		return err
+	}
+	if r.Opcode != dns.OpcodeNotify {
+		return fmt.Errorf("unexpected opcode %d in the response", r.Opcode)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("got %s", dnsutil.RcodeToString(r.Rcode))
+	}
+	return nil
+}
+
+func (n *notifier) close() {
+	n.cancel()
+}
+
+// serveNotify answers a NOTIFY from the primary of a secondary zone.
+func (srv *Server) serveNotify(w dns.ResponseWriter, req *dns.Msg, z *zones.Zone, remote netip.Addr) {
+	rcode, signer := z.Notify(req, remote)
+	if rcode != dns.RcodeSuccess {
+		applog.Printf("[zone %s] NOTIFY from %s refused: %s", z.Origin, remote, dnsutil.RcodeToString(rcode))
+	}
+
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, req)
+	m.Authoritative = true
+	m.Rcode = rcode
+
+	if signer != nil {
+		t := req.Pseudo[len(req.Pseudo)-1].(*dns.TSIG)
+		m.Pseudo = []dns.RR{dns.NewTSIG(t.Hdr.Name, t.Algorithm, 0)}
+		if err := dns.TSIGSign(m, signer, &dns.TSIGOption{RequestMAC: t.MAC}); err != nil {
+			log.Printf("[zone %s] could not sign NOTIFY response: %s", z.Origin, err)
+			return
+		}
+	}
+	if _, err := m.WriteTo(w); err != nil {
+		applog.Printf("error writing response: %s", err)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/abh/geodns/v3/zones"
+)
+
+func TestNotify(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := netip.MustParseAddrPort(pc.LocalAddr().String())
+
+	secret := []byte("notify secret")
+
+	notifies := make(chan *dns.Msg, 10)
+	secondary := &dns.Server{
+		PacketConn: pc,
+		Net:        "udp",
+		Handler: dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			if err := r.Unpack(); err != nil {
+				return
+			}
+			if err := dns.TSIGVerify(r, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+				t.Logf("TSIG: %s", err)
+				return
+			}
+			notifies <- r
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.WriteTo(w)
+		}),
+	}
+	go secondary.ListenAndServe()
+	defer secondary.Shutdown(context.Background())
+
+	metrics := &serverMetrics{
+		Notifies:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "notify"}, []string{"zone", "result"}),
+		NotifySerial: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "notify_serial"}, []string{"zone", "target"}),
+	}
+	n := newNotifier([]netip.AddrPort{target}, metrics)
+	n.keyName = "notify-key."
+	n.key = &tsigKey{algorithm: dns.HmacSHA256, signer: dns.HmacTSIG{Secret: secret}}
+	defer n.close()
+
+	zone := func(serial int) *zones.Zone {
+		z := zones.NewZone("example.com")
+		z.Options.Serial = serial
+		// the same target for the zone shouldn't get two notifies
+		z.Options.AlsoNotify = []netip.AddrPort{target}
+		z.AddSOA()
+		return z
+	}
+
+	// the first load isn't a change
+	n.zoneLoaded(zone(1))
+	n.zoneLoaded(zone(1))
+
+	n.zoneLoaded(zone(2))
+
+	select {
+	case m := <-notifies:
+		assert.Equal(t, uint8(dns.OpcodeNotify), m.Opcode)
+		assert.True(t, m.Authoritative)
+		require.Len(t, m.Answer, 1)
+		assert.Equal(t, uint32(2), m.Answer[0].(*dns.SOA).Serial)
+	case <-time.After(3 * time.Second):
+		t.Fatal("didn't get a NOTIFY")
+	}
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.Notifies.WithLabelValues("example.com", "ack")) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.NotifySerial.WithLabelValues("example.com", target.String())))
+
+	select {
+	case <-notifies:
+		t.Error("got more than one NOTIFY")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	dns "codeberg.org/miekg/dns"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// referral fills in m as a (non-authoritative) referral to the name
+// servers for the delegated label. The glue for name servers that are in
+// the zone is added with the additional section.
+func referral(m *dns.Msg, z *zones.Zone, cut string, label *zones.Label) {
+	m.Authoritative = false
+
+	name := cut + "." + z.Origin + "."
+
+	for _, record := range z.Picker(label, dns.TypeNS, label.MaxHosts, nil) {
+		rr := record.RR.Clone()
+		rr.Header().Name = name
+		m.Ns = append(m.Ns, rr)
+	}
+}
@@ -35,6 +35,19 @@ func getQuestionName(z *zones.Zone, fqdn string) string {
 }
 
 func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg, z *zones.Zone) {
+	// the dns server only unpacks the question before dispatching; the
+	// rest (notably the OPT record with the DO bit) is needed for DNSSEC
+	if err := req.Unpack(); err != nil {
+		applog.Printf("[zone %s] could not unpack request: %s", z.Origin, err)
+		m := new(dns.Msg)
+		dnsutil.SetReply(m, req)
+		m.Rcode = dns.RcodeFormatError
+		if _, err := m.WriteTo(w); err != nil {
+			applog.Printf("could not write response: %s", err)
+		}
+		return
+	}
+
 	qrr := req.Question[0]
 	qnamefqdn := qrr.Header().Name
 	qtype := dns.RRToType(qrr)
@@ -83,29 +96,44 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 
 	z.Metrics.ClientStats.Add(realIP.String())
 
+	if req.Opcode == dns.OpcodeUpdate {
+		srv.serveUpdate(w, req, z, realIP)
+		return
+	}
+
+	if req.Opcode == dns.OpcodeNotify {
+		srv.serveNotify(w, req, z, realIP)
+		return
+	}
+
+	if qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+		srv.serveTransfer(w, req, z, qtype, realIP)
+		return
+	}
+
 	var ip netip.Addr // EDNS CLIENT SUBNET or real IP
 	var ecs *dns.SUBNET
 
-	if option := edns.FindOPT(req); option != nil {
-		for _, s := range option.Options {
-			switch e := s.(type) {
-			case *dns.SUBNET:
-				applog.Println("Got edns-client-subnet", e.Address, e.Family, e.Netmask, e.Scope)
-				if e.Address.IsValid() {
-					ecs = e
-
-					ecsip := e.Address
-					if ecsip.IsGlobalUnicast() &&
-						!(ecsip.IsPrivate() ||
-							ecsip.IsLinkLocalMulticast() ||
-							ecsip.IsInterfaceLocalMulticast()) {
-						ip = ecsip
-					}
-
-					if qle != nil {
-						qle.HasECS = true
-						qle.ClientAddr = fmt.Sprintf("%s/%d", ip, e.Netmask)
-					}
+	// the EDNS options are in the pseudo section when the OPT record
+	// is unpacked
+	for _, s := range req.Pseudo {
+		switch e := s.(type) {
+		case *dns.SUBNET:
+			applog.Println("Got edns-client-subnet", e.Address, e.Family, e.Netmask, e.Scope)
+			if e.Address.IsValid() {
+				ecs = e
+
+				ecsip := e.Address
+				if ecsip.IsGlobalUnicast() &&
+					!(ecsip.IsPrivate() ||
+						ecsip.IsLinkLocalMulticast() ||
+						ecsip.IsInterfaceLocalMulticast()) {
+					ip = ecsip
+				}
+
+				if qle != nil {
+					qle.HasECS = true
+					qle.ClientAddr = fmt.Sprintf("%s/%d", ip, e.Netmask)
 				}
 			}
 		}
@@ -125,6 +153,16 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 		targets, netmask, location = z.Options.Targeting.GetTargets(realIP, z.HasClosest)
 	}
 
+	// source views go before the geo targets
+	var ecsIP netip.Addr
+	if ecs != nil {
+		ecsIP = ecs.Address
+	}
+	if view, bits := z.MatchView(realIP, ecsIP); len(view) > 0 {
+		targets = append([]string{view}, targets...)
+		netmask = max(netmask, bits)
+	}
+
 	m := &dns.Msg{}
 
 	// setup logging of answers and rcode
@@ -170,29 +208,48 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 
 	dnsutil.SetReply(m, req)
 
-	if option := edns.SetSizeAndDo(req, m); option != nil {
-		for _, s := range option.Options {
-			switch e := s.(type) {
-			case *dns.NSID:
-				e.Nsid = hex.EncodeToString([]byte(srv.info.ID))
-			case *dns.SUBNET:
-				// access e.Family, e.Address, etc.
-				// TODO: set scope to 0 if there are no alternate responses
-				if ecs != nil && ecs.Family != 0 {
-					if netmask < 16 {
-						netmask = 16
-					}
-					e.Scope = uint8(netmask)
+	edns.SetSizeAndDo(req, m)
+	for _, s := range req.Pseudo {
+		switch s.(type) {
+		case *dns.NSID:
+			m.Pseudo = append(m.Pseudo, &dns.NSID{Nsid: hex.EncodeToString([]byte(srv.info.ID))})
+		case *dns.SUBNET:
+			// TODO: set scope to 0 if there are no alternate responses
+			if ecs != nil && ecs.Family != 0 {
+				if netmask < 16 {
+					netmask = 16
 				}
+				m.Pseudo = append(m.Pseudo, &dns.SUBNET{
+					Family:  ecs.Family,
+					Netmask: ecs.Netmask,
+					Scope:   uint8(netmask),
+					Address: ecs.Address,
+				})
 			}
 		}
 	}
 
 	m.Authoritative = true
 
-	labelMatches := z.FindLabels(qlabel, targets, []uint16{dns.TypeMF, dns.TypeCNAME, qtype})
+	var labelMatches []zones.LabelMatch
+
+	// the DS record for a delegation is answered from this side of the cut
+	cut, delegation := z.FindDelegation(qlabel, targets)
+	isReferral := delegation != nil && (qtype != dns.TypeDS || cut != qlabel)
+	if isReferral {
+		referral(m, z, cut, delegation)
+		if z.Signer != nil && req.Security {
+			// show there's no DS record, the delegation is insecure
+			m.Ns = append(m.Ns, denialNSEC(z, cut+"."+z.Origin+".", []uint16{dns.TypeNS}))
+		}
+		if qle != nil {
+			qle.LabelName = delegation.Label
+		}
+	} else {
+		labelMatches = z.FindLabels(qlabel, targets, []uint16{dns.TypeMF, dns.TypeCNAME, qtype})
+	}
 
-	if len(labelMatches) == 0 {
+	if len(labelMatches) == 0 && !isReferral {
 
 		permitDebug := srv.PublicDebugQueries || (realIP.IsValid() && realIP.IsLoopback())
 
@@ -206,7 +263,7 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 			if qtype == dns.TypeANY || qtype == dns.TypeTXT {
 				m.Answer = srv.statusRR(qlabel + "." + z.Origin + ".")
 			} else {
-				m.Ns = append(m.Ns, z.SoaRR())
+				m.Ns = append(m.Ns, z.NegativeSoaRR())
 			}
 			m.Authoritative = true
 			if _, err := m.WriteTo(w); err != nil {
@@ -225,7 +282,21 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 				}
 				return
 			}
-			m.Ns = append(m.Ns, z.SoaRR())
+			m.Ns = append(m.Ns, z.NegativeSoaRR())
+			m.Authoritative = true
+			if _, err := m.WriteTo(w); err != nil {
+				applog.Printf("error writing response: %s", err)
+			}
+			return
+		}
+
+		if permitDebug && firstLabel == "_weights" {
+			if qtype == dns.TypeANY || qtype == dns.TypeTXT {
+				baseLabel := strings.Join((strings.Split(qlabel, "."))[1:], ".")
+				m.Answer = z.WeightsRR(qlabel+"."+z.Origin+".", baseLabel)
+			} else {
+				m.Ns = append(m.Ns, z.NegativeSoaRR())
+			}
 			m.Authoritative = true
 			if _, err := m.WriteTo(w); err != nil {
 				applog.Printf("error writing response: %s", err)
@@ -257,7 +328,7 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 					TXT: rdata.TXT{Txt: txt},
 				}}
 			} else {
-				m.Ns = append(m.Ns, z.SoaRR())
+				m.Ns = append(m.Ns, z.NegativeSoaRR())
 			}
 
 			m.Authoritative = true
@@ -270,6 +341,21 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 
 		// return NXDOMAIN
 		m.Rcode = dns.RcodeNameError
+		m.Authoritative = true
+
+		m.Ns = []dns.RR{z.NegativeSoaRR()}
+
+		if z.Signer != nil && req.Security {
+			// compact denial of existence; the NXDOMAIN rcode is only
+			// restored for clients that understand it (RFC 9824)
+			if !req.CompactAnswers {
+				m.Rcode = dns.RcodeSuccess
+			}
+			m.CompactAnswers = req.CompactAnswers
+			m.Ns = append(m.Ns, denialNSEC(z, qnamefqdn, []uint16{dns.TypeNXNAME}))
+			signMsg(req, m, z)
+		}
+
 		srv.metrics.Queries.With(
 			prometheus.Labels{
 				"zone":  z.Origin,
@@ -277,9 +363,6 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 				"qname": "_error",
 				"rcode": dnsutil.RcodeToString(m.Rcode),
 			}).Inc()
-		m.Authoritative = true
-
-		m.Ns = []dns.RR{z.SoaRR()}
 
 		if _, err := m.WriteTo(w); err != nil {
 			applog.Printf("error writing response: %s", err)
@@ -309,6 +392,8 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 			// how it has been working, so we stop looking for answers as soon
 			// as we have some.
 
+			chaseCNAME(m, z, qtype, targets, location)
+
 			if qle != nil {
 				qle.LabelName = label.Label
 				qle.AnswerCount = len(m.Answer)
@@ -318,9 +403,21 @@ func (srv *Server) serve(ctx context.Context, w dns.ResponseWriter, req *dns.Msg
 		}
 	}
 
-	if len(m.Answer) == 0 {
+	if len(m.Answer) == 0 && !isReferral {
 		// Return a SOA so the NOERROR answer gets cached
-		m.Ns = append(m.Ns, z.SoaRR())
+		m.Ns = append(m.Ns, z.NegativeSoaRR())
+
+		if z.Signer != nil && req.Security {
+			m.Ns = append(m.Ns, denialNSEC(z, qnamefqdn, labelTypes(labelMatches, qtype)))
+		}
+	}
+
+	signMsg(req, m, z)
+
+	size := int(edns.Size(w.LocalAddr().Network(), m.UDPSize))
+	if !additional(m, z, targets, location, size, z.Signer != nil && req.Security) && isReferral {
+		// the glue is needed to follow the referral (RFC 9471)
+		m.Truncated = true
 	}
 
 	qlabelMetric := "_"
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -27,6 +28,7 @@ func TestServe(t *testing.T) {
 	serverInfo := &monitor.ServerInfo{}
 
 	srv := NewServer(appconfig.Config, serverInfo)
+	setupTestTransfers(t, srv)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	mm, err := zones.NewMuxManager("../dns", srv)
@@ -48,7 +50,14 @@ func TestServe(t *testing.T) {
 
 	t.Run("Cname", testCname)
 	t.Run("ServingAliases", testServingAliases)
+	t.Run("Referrals", testReferrals)
+	t.Run("Additional", testAdditional)
 	t.Run("ServingEDNS", testServingEDNS)
+	t.Run("DNSSEC", testDNSSEC(srv))
+	t.Run("Transfer", testTransfer)
+	t.Run("Update", testUpdate(srv))
+	t.Run("DynamicUpdate", testDynamicUpdate(srv))
+	t.Run("SourceViews", testSourceViews(srv))
 
 	cancel()
 
@@ -178,6 +187,24 @@ func testServing(t *testing.T) {
 	assert.Len(t, r.Answer, 0, "expect 0 answer records for two.one.test.example.com")
 	checkRcode(t, r.Rcode, dns.RcodeSuccess, "two.one.test.example.com")
 
+	// Wildcards get the query name in the answer
+	r = exchange(t, "foo.wild.test.example.com.", dns.TypeA)
+	require.Len(t, r.Answer, 1)
+	assert.Equal(t, "foo.wild.test.example.com.", r.Answer[0].Header().Name)
+	assert.Equal(t, "192.168.2.1", r.Answer[0].(*dns.A).Addr.String())
+
+	r = exchange(t, "foo.wild.test.example.com.", dns.TypeMX)
+	assert.Len(t, r.Answer, 0)
+	checkRcode(t, r.Rcode, dns.RcodeSuccess, "foo.wild.test.example.com MX")
+
+	r = exchange(t, "foo.ent.wild.test.example.com.", dns.TypeA)
+	checkRcode(t, r.Rcode, dns.RcodeNameError, "foo.ent.wild.test.example.com")
+
+	// zone read from an RFC 1035 master file
+	r = exchange(t, "www.zonefile.example.com.", dns.TypeAAAA)
+	require.Len(t, r.Answer, 1)
+	assert.Equal(t, "2001:db8::1", r.Answer[0].(*dns.AAAA).Addr.String())
+
 	// Verify the A record wasn't over written
 	r = exchange(t, "one.test.example.com.", dns.TypeA)
 	ip = r.Answer[0].(*dns.A).Addr
@@ -206,6 +233,27 @@ func testCname(t *testing.T) {
 
 	// Two possible results from this cname
 	assert.Len(t, results, 2)
+
+	// CNAMEs in the zone are followed
+	r := exchange(t, "www-cname.test.example.com.", dns.TypeA)
+	require.Len(t, r.Answer, 2)
+	assert.Equal(t, "bar.test.example.com.", r.Answer[0].(*dns.CNAME).CNAME.Target)
+	assert.Equal(t, "bar.test.example.com.", r.Answer[1].Header().Name)
+	assert.Equal(t, "192.168.1.2", r.Answer[1].(*dns.A).Addr.String())
+
+	r = exchange(t, "chain1.test.example.com.", dns.TypeA)
+	require.Len(t, r.Answer, 4)
+	assert.Equal(t, "chain2.test.example.com.", r.Answer[1].Header().Name)
+	assert.Equal(t, "www-cname.test.example.com.", r.Answer[2].Header().Name)
+	assert.IsType(t, &dns.A{}, r.Answer[3])
+
+	// ... but not when asking for the CNAME
+	r = exchange(t, "chain1.test.example.com.", dns.TypeCNAME)
+	assert.Len(t, r.Answer, 1)
+
+	r = exchange(t, "loop1.test.example.com.", dns.TypeA)
+	checkRcode(t, r.Rcode, dns.RcodeSuccess, "loop1.test.example.com")
+	assert.Len(t, r.Answer, 2, "loop is cut off")
 }
 
 func testServingAliases(t *testing.T) {
@@ -225,19 +273,142 @@ func testServingAliases(t *testing.T) {
 		assert.Equal(t, "geo-europe.bitnames.com.", r.Answer[0].(*dns.CNAME).CNAME.Target)
 	}
 
+	// Alias to a label in another zone
+	r = exchange(t, "cross-alias.test.example.com.", dns.TypeA)
+	require.Len(t, r.Answer, 1)
+	assert.Equal(t, "cross-alias.test.example.com.", r.Answer[0].Header().Name)
+	assert.Equal(t, "192.168.5.1", r.Answer[0].(*dns.A).Addr.String())
+
 	// Alias to NS records - aliases intentionally don't follow NS/SOA records (see zone.go)
 	// so we expect no answer records, just an authority section
 	r = exchange(t, "sub-alias.test.example.org.", dns.TypeNS)
 	assert.Len(t, r.Answer, 0, "aliases don't follow NS records")
 }
 
+func testReferrals(t *testing.T) {
+	r := exchange(t, "www.glue.test.example.org.", dns.TypeA)
+	checkRcode(t, r.Rcode, dns.RcodeSuccess, "www.glue.test.example.org")
+	assert.False(t, r.Authoritative, "referrals aren't authoritative")
+	assert.Len(t, r.Answer, 0)
+	require.Len(t, r.Ns, 2)
+	for _, rr := range r.Ns {
+		require.IsType(t, &dns.NS{}, rr)
+		assert.Equal(t, "glue.test.example.org.", rr.Header().Name)
+	}
+	// glue only for the name server in the zone
+	require.Len(t, r.Extra, 2)
+	for _, rr := range r.Extra {
+		assert.Equal(t, "ns1.glue.test.example.org.", rr.Header().Name)
+	}
+	assert.Equal(t, "192.168.3.1", r.Extra[0].(*dns.A).Addr.String())
+	assert.Equal(t, "2001:db8::3:1", r.Extra[1].(*dns.AAAA).Addr.String())
+
+	// the glue is limited to the UDP size of the query, with the
+	// response truncated if it doesn't fit
+	r = exchange(t, "www.bigglue.test.example.org.", dns.TypeA)
+	assert.False(t, r.Authoritative)
+	assert.Len(t, r.Ns, 4)
+	assert.True(t, r.Truncated)
+	assert.NotEmpty(t, r.Extra)
+	assert.Less(t, len(r.Extra), 8)
+	assert.LessOrEqual(t, r.Len(), dns.MinMsgSize)
+
+	msg := new(dns.Msg)
+	dnsutil.SetQuestion(msg, "www.bigglue.test.example.org.", dns.TypeA)
+	msg.UDPSize = 4096
+	r = dorequest(t, msg)
+	assert.False(t, r.Truncated)
+	assert.Len(t, r.Extra, 8)
+
+	r = exchange(t, "sub.test.example.org.", dns.TypeNS)
+	assert.False(t, r.Authoritative)
+	assert.Len(t, r.Answer, 0)
+	assert.Len(t, r.Ns, 2)
+	assert.Len(t, r.Extra, 0)
+
+	// DS is answered by the parent side of the delegation
+	r = exchange(t, "sub.test.example.org.", dns.TypeDS)
+	assert.True(t, r.Authoritative)
+	assert.Len(t, r.Answer, 0)
+	require.Len(t, r.Ns, 1)
+	assert.IsType(t, &dns.SOA{}, r.Ns[0])
+}
+
+func testAdditional(t *testing.T) {
+	r := exchange(t, "mail.test.example.com.", dns.TypeMX)
+	require.Len(t, r.Answer, 2)
+	require.Len(t, r.Extra, 2, "addresses for the MX in the zone")
+	assert.Equal(t, "mx1.mail.test.example.com.", r.Extra[0].Header().Name)
+	assert.Equal(t, "192.168.4.1", r.Extra[0].(*dns.A).Addr.String())
+	assert.Equal(t, "2001:db8::4:1", r.Extra[1].(*dns.AAAA).Addr.String())
+
+	// only what fits in 512 bytes without EDNS
+	r = exchange(t, "many.test.example.com.", dns.TypeMX)
+	require.Len(t, r.Answer, 8)
+	assert.Less(t, len(r.Extra), 16)
+	assert.LessOrEqual(t, len(r.Data), 512)
+
+	msg := new(dns.Msg)
+	dnsutil.SetQuestion(msg, "many.test.example.com.", dns.TypeMX)
+	msg.UDPSize = 4096
+	r = dorequest(t, msg)
+	require.Len(t, r.Answer, 8)
+	assert.Len(t, r.Extra, 16)
+}
+
 func testServingEDNS(t *testing.T) {
+	// the client subnet option is returned, with the scope
+	r := exchangeSubnet(t, "test.example.com.", dns.TypeMX, "194.239.134.1")
+	var subnet *dns.SUBNET
+	for _, rr := range r.Pseudo {
+		if e, ok := rr.(*dns.SUBNET); ok {
+			subnet = e
+		}
+	}
+	require.NotNil(t, subnet, "EDNS client subnet in the response")
+	assert.Equal(t, "194.239.134.1", subnet.Address.String())
+	assert.Equal(t, uint8(32), subnet.Netmask)
+
+	// the options can also be set without an OPT record
+	msg := new(dns.Msg)
+	dnsutil.SetQuestion(msg, "test.example.com.", dns.TypeMX)
+	msg.Pseudo = []dns.RR{
+		&dns.SUBNET{Address: netip.MustParseAddr("192.0.2.1"), Family: 1, Netmask: 24},
+		&dns.NSID{},
+	}
+	r = dorequest(t, msg)
+	subnet, nsid := (*dns.SUBNET)(nil), (*dns.NSID)(nil)
+	for _, rr := range r.Pseudo {
+		switch e := rr.(type) {
+		case *dns.SUBNET:
+			subnet = e
+		case *dns.NSID:
+			nsid = e
+		}
+	}
+	require.NotNil(t, subnet, "EDNS client subnet in the response")
+	assert.Equal(t, "192.0.2.0", subnet.Address.String())
+	assert.Equal(t, uint8(24), subnet.Netmask)
+	assert.NotNil(t, nsid, "NSID in the response")
+
+	// only EDNS version 0 is supported
+	msg = new(dns.Msg)
+	dnsutil.SetQuestion(msg, "test.example.com.", dns.TypeMX)
+	o := &dns.OPT{Hdr: dns.Header{Name: "."}}
+	o.SetUDPSize(1232)
+	o.SetVersion(1)
+	msg.Pseudo = []dns.RR{o}
+	r = dorequest(t, msg)
+	assert.Equal(t, uint16(dns.RcodeBadVers), r.Rcode)
+	assert.Empty(t, r.Answer)
+	assert.Equal(t, uint8(0), r.Version)
+
 	if targeting.Geo() == nil {
 		t.Skip("GeoIP not available")
 	}
 
 	// MX test with geo override
-	r := exchangeSubnet(t, "test.example.com.", dns.TypeMX, "194.239.134.1")
+	r = exchangeSubnet(t, "test.example.com.", dns.TypeMX, "194.239.134.1")
 	require.Len(t, r.Answer, 1)
 	assert.Equal(t, "mx-eu.example.net.", r.Answer[0].(*dns.MX).MX.Mx)
 
@@ -249,6 +420,136 @@ func testServingEDNS(t *testing.T) {
 	assert.Equal(t, "geo-europe.bitnames.com.", r.Answer[0].(*dns.CNAME).CNAME.Target)
 }
 
+func testDNSSEC(srv *Server) func(*testing.T) {
+	return func(t *testing.T) {
+		errorQueries := func(rcode string) float64 {
+			return testutil.ToFloat64(srv.metrics.Queries.WithLabelValues("dnssec.example.com", "A", "_error", rcode))
+		}
+
+		// no DO bit, no signatures
+		r := exchange(t, "dnssec.example.com.", dns.TypeA)
+		require.Len(t, r.Answer, 2)
+		for _, rr := range r.Answer {
+			assert.IsType(t, &dns.A{}, rr)
+		}
+
+		r = exchangeDO(t, "dnssec.example.com.", dns.TypeA, false)
+		require.Len(t, r.Answer, 3)
+		sig, ok := r.Answer[2].(*dns.RRSIG)
+		require.True(t, ok, "RRSIG after the A records")
+		assert.Equal(t, dns.TypeA, sig.TypeCovered)
+		assert.Equal(t, "dnssec.example.com.", sig.SignerName)
+
+		r = exchangeDO(t, "dnssec.example.com.", dns.TypeDNSKEY, false)
+		require.Len(t, r.Answer, 2)
+		key, ok := r.Answer[0].(*dns.DNSKEY)
+		require.True(t, ok)
+		sig = r.Answer[1].(*dns.RRSIG)
+		assert.Equal(t, key.KeyTag(), sig.KeyTag)
+		assert.NoError(t, sig.Verify(key, []dns.RR{key.Clone()}, &dns.SignOption{}))
+
+		// the addresses in the additional section are signed too
+		r = exchangeDO(t, "corp.dnssec.example.com.", dns.TypeMX, false)
+		require.Len(t, r.Answer, 2)
+		assert.Equal(t, dns.TypeMX, r.Answer[1].(*dns.RRSIG).TypeCovered)
+		require.Len(t, r.Extra, 2)
+		assert.Equal(t, "mail.dnssec.example.com.", r.Extra[0].(*dns.A).Hdr.Name)
+		sig, ok = r.Extra[1].(*dns.RRSIG)
+		require.True(t, ok, "RRSIG for the additional A record")
+		assert.Equal(t, dns.TypeA, sig.TypeCovered)
+		assert.NoError(t, sig.Verify(key, []dns.RR{r.Extra[0]}, &dns.SignOption{}))
+
+		// compact denial; names that don't exist get NOERROR and NXNAME
+		noerror, nxdomain := errorQueries("NOERROR"), errorQueries("NXDOMAIN")
+		r = exchangeDO(t, "nothere.dnssec.example.com.", dns.TypeA, false)
+		checkRcode(t, r.Rcode, dns.RcodeSuccess, "nothere.dnssec.example.com")
+		assert.Equal(t, noerror+1, errorQueries("NOERROR"), "counted with the rcode sent")
+		assert.Equal(t, nxdomain, errorQueries("NXDOMAIN"))
+		nsec := findNSEC(r.Ns)
+		require.NotNil(t, nsec, "NSEC in authority section")
+		assert.Equal(t, "nothere.dnssec.example.com.", nsec.Hdr.Name)
+		assert.Equal(t, []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}, nsec.TypeBitMap)
+
+		// ... unless the client signals it understands the CO bit
+		r = exchangeDO(t, "nothere.dnssec.example.com.", dns.TypeA, true)
+		checkRcode(t, r.Rcode, dns.RcodeNameError, "nothere.dnssec.example.com (CO)")
+		assert.Equal(t, nxdomain+1, errorQueries("NXDOMAIN"))
+		assert.True(t, r.CompactAnswers)
+
+		// NODATA lists the types the name does have
+		r = exchangeDO(t, "txt.dnssec.example.com.", dns.TypeA, false)
+		checkRcode(t, r.Rcode, dns.RcodeSuccess, "txt.dnssec.example.com")
+		assert.Len(t, r.Answer, 0)
+		nsec = findNSEC(r.Ns)
+		require.NotNil(t, nsec, "NSEC in authority section")
+		assert.Equal(t, []uint16{dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}, nsec.TypeBitMap)
+
+		// insecure delegation; the NS records aren't signed, the NSEC is
+		r = exchangeDO(t, "www.child.dnssec.example.com.", dns.TypeA, false)
+		assert.False(t, r.Authoritative)
+		require.Len(t, r.Ns, 3)
+		assert.IsType(t, &dns.NS{}, r.Ns[0])
+		nsec = r.Ns[1].(*dns.NSEC)
+		assert.Equal(t, "child.dnssec.example.com.", nsec.Hdr.Name)
+		assert.Equal(t, []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}, nsec.TypeBitMap)
+		assert.Equal(t, dns.TypeNSEC, r.Ns[2].(*dns.RRSIG).TypeCovered)
+
+		// unsigned zones don't get DNSSEC records
+		r = exchangeDO(t, "bar.test.example.com.", dns.TypeA, false)
+		for _, rr := range append(r.Answer, r.Ns...) {
+			assert.NotEqual(t, dns.TypeRRSIG, dns.RRToType(rr))
+		}
+	}
+}
+
+func testUpdate(srv *Server) func(*testing.T) {
+	return func(t *testing.T) {
+		zone := func(name, ip string) *zones.Zone {
+			z := zones.NewZone(name)
+			data := `{ "data": { "": { "ns": [ "ns1.example.net" ] }, "www": { "a": [ [ "` + ip + `" ] ] } } }`
+			require.NoError(t, z.ReadZoneData(zones.ZoneFile{Name: name, FileName: name + ".json"}, []byte(data)))
+			z.SetupMetrics(nil)
+			return z
+		}
+
+		srv.Update([]zones.ZoneChange{
+			{Name: "batch-a.example", Zone: zone("batch-a.example", "192.0.2.1")},
+			{Name: "batch-b.example", Zone: zone("batch-b.example", "192.0.2.2")},
+		})
+		r := exchange(t, "www.batch-a.example.", dns.TypeA)
+		require.Len(t, r.Answer, 1)
+		assert.Equal(t, "192.0.2.1", r.Answer[0].(*dns.A).Addr.String())
+		r = exchange(t, "www.batch-b.example.", dns.TypeA)
+		require.Len(t, r.Answer, 1)
+
+		// zones added one by one are kept in the next update
+		srv.Add("batch-c.example", zone("batch-c.example", "192.0.2.3"))
+		srv.Update([]zones.ZoneChange{
+			{Name: "batch-a.example", Zone: zone("batch-a.example", "192.0.2.10")},
+			{Name: "batch-b.example"},
+		})
+		r = exchange(t, "www.batch-a.example.", dns.TypeA)
+		require.Len(t, r.Answer, 1)
+		assert.Equal(t, "192.0.2.10", r.Answer[0].(*dns.A).Addr.String())
+		r = exchange(t, "www.batch-b.example.", dns.TypeA)
+		checkRcode(t, r.Rcode, dns.RcodeRefused, "removed zone")
+		r = exchange(t, "www.batch-c.example.", dns.TypeA)
+		assert.Len(t, r.Answer, 1)
+
+		srv.Remove("batch-a.example")
+		srv.Remove("batch-c.example")
+	}
+}
+
+func findNSEC(rrs []dns.RR) *dns.NSEC {
+	for _, rr := range rrs {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			return nsec
+		}
+	}
+	return nil
+}
+
 func checkRcode(t *testing.T, rcode uint16, expected uint16, name string) {
 	if rcode != expected {
 		t.Logf("'%s': rcode!=%s: %s", name, dnsutil.RcodeToString(expected), dnsutil.RcodeToString(rcode))
@@ -284,6 +585,16 @@ func exchange(t *testing.T, name string, dnstype uint16) *dns.Msg {
 	return dorequest(t, msg)
 }
 
+func exchangeDO(t *testing.T, name string, dnstype uint16, compact bool) *dns.Msg {
+	msg := new(dns.Msg)
+
+	dnsutil.SetQuestion(msg, name, dnstype)
+	msg.UDPSize = 4096
+	msg.Security = true
+	msg.CompactAnswers = compact
+	return dorequest(t, msg)
+}
+
 func dorequest(t *testing.T, msg *dns.Msg) *dns.Msg {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
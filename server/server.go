@@ -6,6 +6,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dns "codeberg.org/miekg/dns"
@@ -20,7 +21,9 @@ import (
 )
 
 type serverMetrics struct {
-	Queries *prometheus.CounterVec
+	Queries      *prometheus.CounterVec
+	Notifies     *prometheus.CounterVec
+	NotifySerial *prometheus.GaugeVec
 }
 
 // Server ...
@@ -29,18 +32,25 @@ type Server struct {
 	DetailedMetrics    bool
 
 	queryLogger querylog.QueryLogger
-	mux         *dns.ServeMux
 	info        *monitor.ServerInfo
 	metrics     *serverMetrics
+	transfer    *transferConfig
+	notify      *notifier
+	updates     map[string]map[string]*tsigKey // TSIG keys by zone
 
 	lock       sync.Mutex
 	dnsServers []*dns.Server
+
+	// mux is replaced as a whole when several zones are updated together;
+	// muxLock serializes the changes and guards handlers, the zones
+	// currently in the mux
+	mux      atomic.Pointer[dns.ServeMux]
+	muxLock  sync.Mutex
+	handlers map[string]dns.Handler
 }
 
 // NewServer ...
 func NewServer(config *appconfig.AppConfig, si *monitor.ServerInfo) *Server {
-	mux := dns.NewServeMux()
-
 	queries := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "dns_queries_total",
@@ -50,6 +60,24 @@ func NewServer(config *appconfig.AppConfig, si *monitor.ServerInfo) *Server {
 	)
 	prometheus.MustRegister(queries)
 
+	notifies := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_notify_total",
+			Help: "NOTIFY messages sent to secondaries, by result",
+		},
+		[]string{"zone", "result"},
+	)
+	prometheus.MustRegister(notifies)
+
+	notifySerial := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_notify_acked_serial",
+			Help: "Last zone serial acknowledged by each secondary",
+		},
+		[]string{"zone", "target"},
+	)
+	prometheus.MustRegister(notifySerial)
+
 	version.RegisterMetric("geodns", prometheus.DefaultRegisterer)
 
 	instanceInfo := prometheus.NewGaugeVec(
@@ -78,17 +106,21 @@ func NewServer(config *appconfig.AppConfig, si *monitor.ServerInfo) *Server {
 	startTime.Set(float64(nano) / 1e9)
 
 	metrics := &serverMetrics{
-		Queries: queries,
+		Queries:      queries,
+		Notifies:     notifies,
+		NotifySerial: notifySerial,
 	}
 
-	return &Server{
+	srv := &Server{
 		PublicDebugQueries: appconfig.Config.DNS.PublicDebugQueries,
 		DetailedMetrics:    appconfig.Config.DNS.DetailedMetrics,
 
-		mux:     mux,
-		info:    si,
-		metrics: metrics,
+		info:     si,
+		metrics:  metrics,
+		handlers: map[string]dns.Handler{},
 	}
+	srv.mux.Store(dns.NewServeMux())
+	return srv
 }
 
 // SetQueryLogger configures the query logger. For now it only supports writing to
@@ -99,20 +131,79 @@ func (srv *Server) SetQueryLogger(logger querylog.QueryLogger) {
 
 // Add adds the Zone to be handled under the specified name
 func (srv *Server) Add(name string, zone *zones.Zone) {
-	// v2 ServeMux requires patterns to be in canonical form (FQDN with trailing dot)
-	if !strings.HasSuffix(name, ".") {
-		name = name + "."
-	}
-	srv.mux.HandleFunc(name, srv.setupServerFunc(zone))
+	pattern := muxPattern(name)
+	handler := dns.HandlerFunc(srv.setupServerFunc(zone))
+	srv.muxLock.Lock()
+	srv.handlers[pattern] = handler
+	srv.mux.Load().Handle(pattern, handler)
+	srv.muxLock.Unlock()
+	srv.zoneAdded(zone)
 }
 
 // Remove removes the zone name from being handled by the server
 func (srv *Server) Remove(name string) {
-	// v2 ServeMux requires patterns to be in canonical form (FQDN with trailing dot)
+	pattern := muxPattern(name)
+	srv.muxLock.Lock()
+	delete(srv.handlers, pattern)
+	srv.mux.Load().HandleRemove(pattern)
+	srv.muxLock.Unlock()
+	srv.zoneRemoved(name)
+}
+
+// Update adds and removes several zones at once; queries are answered
+// either from the zones before or after the changes, never a mix.
+func (srv *Server) Update(changes []zones.ZoneChange) {
+	srv.muxLock.Lock()
+	for _, c := range changes {
+		pattern := muxPattern(c.Name)
+		if c.Zone == nil {
+			delete(srv.handlers, pattern)
+			continue
+		}
+		srv.handlers[pattern] = dns.HandlerFunc(srv.setupServerFunc(c.Zone))
+	}
+	mux := dns.NewServeMux()
+	for pattern, handler := range srv.handlers {
+		mux.Handle(pattern, handler)
+	}
+	srv.mux.Store(mux)
+	srv.muxLock.Unlock()
+
+	for _, c := range changes {
+		if c.Zone == nil {
+			srv.zoneRemoved(c.Name)
+		} else {
+			srv.zoneAdded(c.Zone)
+		}
+	}
+}
+
+// muxPattern returns the name in the canonical form (FQDN with a trailing
+// dot) required by the v2 ServeMux.
+func muxPattern(name string) string {
 	if !strings.HasSuffix(name, ".") {
 		name = name + "."
 	}
-	srv.mux.HandleRemove(name)
+	return name
+}
+
+func (srv *Server) zoneAdded(zone *zones.Zone) {
+	if srv.transfer != nil {
+		srv.transfer.addVersion(zone.Origin, zone)
+	}
+	if srv.notify != nil {
+		srv.notify.zoneLoaded(zone)
+	}
+}
+
+func (srv *Server) zoneRemoved(name string) {
+	name = strings.TrimSuffix(name, ".")
+	if srv.transfer != nil {
+		srv.transfer.removeVersions(name)
+	}
+	if srv.notify != nil {
+		srv.notify.zoneRemoved(name)
+	}
 }
 
 func (srv *Server) setupServerFunc(zone *zones.Zone) func(context.Context, dns.ResponseWriter, *dns.Msg) {
@@ -123,7 +214,7 @@ func (srv *Server) setupServerFunc(zone *zones.Zone) func(context.Context, dns.R
 
 // ServeDNS calls ServeDNS in the dns package
 func (srv *Server) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
-	srv.mux.ServeDNS(ctx, w, r)
+	srv.mux.Load().ServeDNS(ctx, w, r)
 }
 
 func (srv *Server) addDNSServer(dnsServer *dns.Server) {
@@ -176,6 +267,10 @@ func (srv *Server) Shutdown() error {
 		cancel()
 	}
 
+	if srv.notify != nil {
+		srv.notify.close()
+	}
+
 	if srv.queryLogger != nil {
 		err := srv.queryLogger.Close()
 		if err != nil {
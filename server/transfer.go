@@ -0,0 +1,369 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/abh/geodns/v3/appconfig"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// Zone transfers (AXFR, RFC 5936 and IXFR, RFC 1995) send one view of
+// the zone with all the records, so plain secondaries can serve it.
+
+const (
+	// versions of each zone kept for IXFR
+	maxTransferVersions = 10
+
+	// approximate size of each message in a transfer
+	transferMsgSize = 16000
+)
+
+type transferConfig struct {
+	allow []netip.Prefix
+	keys  map[string]*tsigKey
+	view  string
+
+	mu       sync.Mutex
+	versions map[string][]*zoneVersion
+}
+
+type tsigKey struct {
+	algorithm string
+	signer    dns.HmacTSIG
+}
+
+// zoneVersion is a snapshot of the transferred records; the SOA record
+// isn't included in rrs.
+type zoneVersion struct {
+	soa *dns.SOA
+	rrs []dns.RR
+}
+
+// SetupTransfers enables zone transfers as configured in the [transfer]
+// section and sends NOTIFY messages when zones change. It needs to be
+// called before the zones are added.
+func (srv *Server) SetupTransfers(config *appconfig.AppConfig) error {
+	tc := config.Transfer
+
+	keys, err := tsigKeys(config, tc.Key)
+	if err != nil {
+		return err
+	}
+	var keyName string
+	if len(tc.Key) > 0 {
+		keyName = dnsutil.Fqdn(strings.ToLower(tc.Key[0]))
+	}
+
+	var targets []netip.AddrPort
+	for _, t := range tc.AlsoNotify {
+		target, err := zones.ServerAddress(t)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	if srv.notify != nil {
+		srv.notify.close()
+	}
+	// zones can have also_notify targets without the global ones
+	srv.notify = newNotifier(targets, srv.metrics)
+	if len(keyName) > 0 {
+		// the NOTIFY messages are signed with the first key
+		srv.notify.keyName = keyName
+		srv.notify.key = keys[keyName]
+	}
+
+	if len(tc.Allow) == 0 && len(tc.Key) == 0 {
+		srv.transfer = nil
+		return nil
+	}
+
+	xfr := &transferConfig{
+		keys:     keys,
+		view:     "@",
+		versions: map[string][]*zoneVersion{},
+	}
+	if len(tc.View) > 0 {
+		xfr.view = tc.View
+	}
+
+	for _, a := range tc.Allow {
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(a)
+			if aerr != nil {
+				return fmt.Errorf("invalid transfer allow '%s': %s", a, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		xfr.allow = append(xfr.allow, prefix.Masked())
+	}
+
+	srv.transfer = xfr
+	return nil
+}
+
+func (xfr *transferConfig) allowed(ip netip.Addr) bool {
+	if len(xfr.allow) == 0 {
+		// only the TSIG keys are checked
+		return true
+	}
+	ip = ip.Unmap()
+	for _, prefix := range xfr.allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tsigKeys returns the named [tsig] keys by their FQDN.
+func tsigKeys(config *appconfig.AppConfig, names []string) (map[string]*tsigKey, error) {
+	keys := map[string]*tsigKey{}
+	for _, name := range names {
+		algorithm, secret, err := config.TSIGKey(name)
+		if err != nil {
+			return nil, err
+		}
+		keys[dnsutil.Fqdn(strings.ToLower(name))] = &tsigKey{
+			algorithm: algorithm,
+			signer:    dns.HmacTSIG{Secret: secret},
+		}
+	}
+	return keys, nil
+}
+
+// verifyTSIG checks the TSIG signature on the request is from one of the
+// keys; it returns the signer for the responses if the request was
+// signed. Unsigned requests are only accepted if there are no keys.
+func verifyTSIG(req *dns.Msg, keys map[string]*tsigKey) (dns.TSIGSigner, error) {
+	var t *dns.TSIG
+	if len(req.Pseudo) > 0 {
+		t, _ = req.Pseudo[len(req.Pseudo)-1].(*dns.TSIG)
+	}
+	if t == nil {
+		if len(keys) > 0 {
+			return nil, errors.New("request isn't signed")
+		}
+		return nil, nil
+	}
+
+	key, ok := keys[strings.ToLower(t.Hdr.Name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown TSIG key '%s'", t.Hdr.Name)
+	}
+	if !strings.EqualFold(t.Algorithm, key.algorithm) {
+		return nil, fmt.Errorf("wrong algorithm '%s' for TSIG key '%s'", t.Algorithm, t.Hdr.Name)
+	}
+	if err := dns.TSIGVerify(req, key.signer, &dns.TSIGOption{}); err != nil {
+		return nil, err
+	}
+	return key.signer, nil
+}
+
+// addVersion keeps a snapshot of the transferred view of the zone.
+func (xfr *transferConfig) addVersion(name string, z *zones.Zone) {
+	view, ok := z.View(xfr.view)
+	if !ok {
+		log.Printf("zone %s: transfer view '%s' isn't enabled by the zone targeting", name, xfr.view)
+		return
+	}
+
+	version := &zoneVersion{}
+	for _, rr := range z.ViewRRs(view) {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			version.soa = rr
+		case *dns.DNSKEY:
+			// the signatures are made when answering, so secondaries
+			// can't serve the zone signed
+		default:
+			version.rrs = append(version.rrs, rr)
+		}
+	}
+	if version.soa == nil {
+		return
+	}
+
+	xfr.mu.Lock()
+	defer xfr.mu.Unlock()
+
+	versions := xfr.versions[name]
+	if n := len(versions); n > 0 {
+		last := versions[n-1].soa.Serial
+		switch {
+		case last == version.soa.Serial:
+			versions = versions[:n-1]
+		case !zones.SerialNewer(version.soa.Serial, last):
+			// the serial went backwards, the old versions are useless
+			versions = nil
+		}
+	}
+	versions = append(versions, version)
+	if len(versions) > maxTransferVersions {
+		versions = versions[len(versions)-maxTransferVersions:]
+	}
+	xfr.versions[name] = versions
+}
+
+func (xfr *transferConfig) removeVersions(name string) {
+	xfr.mu.Lock()
+	defer xfr.mu.Unlock()
+	delete(xfr.versions, name)
+}
+
+// transferRRs returns the records for the transfer; for IXFR with a
+// serial we have the old version of it's the changes since then.
+func (xfr *transferConfig) transferRRs(name string, qtype uint16, serial uint32) []dns.RR {
+	xfr.mu.Lock()
+	defer xfr.mu.Unlock()
+
+	versions := xfr.versions[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	current := versions[len(versions)-1]
+
+	if qtype == dns.TypeIXFR {
+		if !zones.SerialNewer(current.soa.Serial, serial) {
+			// up to date
+			return []dns.RR{current.soa}
+		}
+		for _, old := range versions[:len(versions)-1] {
+			if old.soa.Serial != serial {
+				continue
+			}
+			deleted, added := diffRRs(old.rrs, current.rrs)
+			rrs := []dns.RR{current.soa, old.soa}
+			rrs = append(rrs, deleted...)
+			rrs = append(rrs, current.soa)
+			rrs = append(rrs, added...)
+			return append(rrs, current.soa)
+		}
+		// we don't have the old version, send the whole zone
+	}
+
+	rrs := []dns.RR{current.soa}
+	rrs = append(rrs, current.rrs...)
+	return append(rrs, current.soa)
+}
+
+func diffRRs(old, current []dns.RR) (deleted, added []dns.RR) {
+	seen := map[string]bool{}
+	for _, rr := range old {
+		seen[rr.String()] = true
+	}
+	now := map[string]bool{}
+	for _, rr := range current {
+		s := rr.String()
+		now[s] = true
+		if !seen[s] {
+			added = append(added, rr)
+		}
+	}
+	for _, rr := range old {
+		if !now[rr.String()] {
+			deleted = append(deleted, rr)
+		}
+	}
+	return deleted, added
+}
+
+func (srv *Server) serveTransfer(w dns.ResponseWriter, req *dns.Msg, z *zones.Zone, qtype uint16, remote netip.Addr) {
+	fail := func(rcode uint16, format string, args ...interface{}) {
+		applog.Printf("[zone %s] %s from %s refused: %s", z.Origin, dnsutil.TypeToString(qtype), remote, fmt.Sprintf(format, args...))
+		m := new(dns.Msg)
+		dnsutil.SetReply(m, req)
+		m.Rcode = rcode
+		if _, err := m.WriteTo(w); err != nil {
+			applog.Printf("error writing response: %s", err)
+		}
+	}
+
+	xfr := srv.transfer
+	if xfr == nil {
+		fail(dns.RcodeRefused, "transfers aren't enabled")
+		return
+	}
+	if !xfr.allowed(remote) {
+		fail(dns.RcodeRefused, "not allowed")
+		return
+	}
+	signer, err := verifyTSIG(req, xfr.keys)
+	if err != nil {
+		fail(dns.RcodeNotAuth, "%s", err)
+		return
+	}
+
+	isTCP := w.LocalAddr().Network() == "tcp"
+
+	var serial uint32
+	switch qtype {
+	case dns.TypeAXFR:
+		if !isTCP {
+			fail(dns.RcodeRefused, "AXFR over UDP")
+			return
+		}
+	case dns.TypeIXFR:
+		if len(req.Ns) == 0 {
+			fail(dns.RcodeFormatError, "no SOA record in the request")
+			return
+		}
+		soa, ok := req.Ns[0].(*dns.SOA)
+		if !ok {
+			fail(dns.RcodeFormatError, "no SOA record in the request")
+			return
+		}
+		serial = soa.Serial
+	}
+
+	rrs := xfr.transferRRs(z.Origin, qtype, serial)
+	if rrs == nil {
+		fail(dns.RcodeServerFailure, "view '%s' not available", xfr.view)
+		return
+	}
+	if !isTCP && len(rrs) > 1 {
+		// just the SOA record, the client will retry with TCP
+		rrs = rrs[:1]
+	}
+
+	applog.Printf("[zone %s] %s to %s, %d records", z.Origin, dnsutil.TypeToString(qtype), remote, len(rrs))
+
+	if isTCP {
+		w.Hijack()
+		defer w.Close()
+	}
+
+	env := make(chan *dns.Envelope)
+	go func() {
+		defer close(env)
+		var answer []dns.RR
+		size := 0
+		for _, rr := range rrs {
+			if size+rr.Len() > transferMsgSize && len(answer) > 0 {
+				env <- &dns.Envelope{Answer: answer}
+				answer, size = nil, 0
+			}
+			answer = append(answer, rr)
+			size += rr.Len()
+		}
+		env <- &dns.Envelope{Answer: answer}
+	}()
+
+	c := dns.NewClient()
+	if signer != nil {
+		c.Transfer = &dns.Transfer{TSIGSigner: signer}
+	}
+	if err := c.TransferOut(w, req, env); err != nil {
+		log.Printf("[zone %s] %s to %s failed: %s", z.Origin, dnsutil.TypeToString(qtype), remote, err)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/abh/geodns/v3/appconfig"
+)
+
+var testTSIGSecret = []byte("geodns transfer test secret")
+
+func setupTestTransfers(t *testing.T, srv *Server) {
+	config := &appconfig.AppConfig{}
+	config.Transfer.Allow = []string{"127.0.0.1", "::1/128"}
+	config.Transfer.Key = []string{"xfr-key"}
+	config.TSIG = map[string]*struct {
+		Algorithm string
+		Secret    string
+	}{
+		"xfr-key": {Secret: base64.StdEncoding.EncodeToString(testTSIGSecret)},
+	}
+	require.NoError(t, srv.SetupTransfers(config))
+}
+
+func transfer(t *testing.T, m *dns.Msg, secret []byte) ([]dns.RR, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dns.NewClient()
+	if secret != nil {
+		m.Pseudo = append(m.Pseudo, dns.NewTSIG("xfr-key.", dns.HmacSHA256, 0))
+		c.Transfer = &dns.Transfer{TSIGSigner: dns.HmacTSIG{Secret: secret}}
+	}
+	env, err := c.TransferIn(ctx, m, "tcp", "127.0.0.1"+PORT)
+	require.NoError(t, err)
+
+	rrs := []dns.RR{}
+	for e := range env {
+		if e.Error != nil {
+			return rrs, e.Error
+		}
+		rrs = append(rrs, e.Answer...)
+	}
+	return rrs, nil
+}
+
+func testTransfer(t *testing.T) {
+	rrs, err := transfer(t, dns.NewMsg("test.example.com.", dns.TypeAXFR), nil)
+	assert.Error(t, err, "unsigned AXFR")
+	assert.Empty(t, rrs)
+
+	rrs, err = transfer(t, dns.NewMsg("test.example.com.", dns.TypeAXFR), []byte("wrong secret"))
+	assert.Error(t, err, "AXFR with the wrong secret")
+	assert.Empty(t, rrs)
+
+	rrs, err = transfer(t, dns.NewMsg("test.example.com.", dns.TypeAXFR), testTSIGSecret)
+	require.NoError(t, err)
+	require.Greater(t, len(rrs), 2)
+
+	soa, ok := rrs[0].(*dns.SOA)
+	require.True(t, ok, "first record is the SOA")
+	assert.Equal(t, uint32(3), soa.Serial)
+	assert.Equal(t, soa.String(), rrs[len(rrs)-1].String(), "last record is the SOA")
+
+	types := map[uint16]int{}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		types[dns.RRToType(rr)]++
+	}
+	t.Logf("transferred %d records: %v", len(rrs), types)
+	assert.Zero(t, types[dns.TypeSOA], "only the first and last SOA")
+	assert.Equal(t, 2, types[dns.TypeNS])
+	assert.Positive(t, types[dns.TypeA])
+
+	// the serial is current, so only the SOA is returned
+	m := dns.NewMsg("test.example.com.", dns.TypeIXFR)
+	soa.Serial = 3
+	m.Ns = []dns.RR{soa}
+	rrs, err = transfer(t, m, testTSIGSecret)
+	require.NoError(t, err)
+	if assert.Len(t, rrs, 1) {
+		assert.Equal(t, uint32(3), rrs[0].(*dns.SOA).Serial)
+	}
+}
+
+func TestTransferVersions(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.New(s)
+		require.NoError(t, err)
+		return r
+	}
+	soa := func(serial string) *dns.SOA {
+		return rr("example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. " + serial + " 5400 5400 1209600 3600").(*dns.SOA)
+	}
+
+	xfr := &transferConfig{versions: map[string][]*zoneVersion{}}
+	xfr.versions["example.com"] = []*zoneVersion{
+		{soa: soa("1"), rrs: []dns.RR{rr("www.example.com. 600 IN A 192.0.2.1"), rr("www.example.com. 600 IN A 192.0.2.2")}},
+		{soa: soa("2"), rrs: []dns.RR{rr("www.example.com. 600 IN A 192.0.2.2"), rr("www.example.com. 600 IN A 192.0.2.3")}},
+	}
+
+	rrs := xfr.transferRRs("example.com", dns.TypeAXFR, 0)
+	assert.Len(t, rrs, 4)
+
+	rrs = xfr.transferRRs("example.com", dns.TypeIXFR, 1)
+	if assert.Len(t, rrs, 6) {
+		assert.Equal(t, uint32(2), rrs[0].(*dns.SOA).Serial)
+		assert.Equal(t, uint32(1), rrs[1].(*dns.SOA).Serial)
+		assert.Equal(t, "192.0.2.1", rrs[2].(*dns.A).Addr.String(), "deleted")
+		assert.Equal(t, uint32(2), rrs[3].(*dns.SOA).Serial)
+		assert.Equal(t, "192.0.2.3", rrs[4].(*dns.A).Addr.String(), "added")
+	}
+
+	// unknown serial, send the whole zone
+	rrs = xfr.transferRRs("example.com", dns.TypeIXFR, 4000)
+	assert.Len(t, rrs, 1, "newer serial than we have")
+	rrs = xfr.transferRRs("example.com", dns.TypeIXFR, 0)
+	assert.Len(t, rrs, 4)
+
+	assert.Nil(t, xfr.transferRRs("example.org", dns.TypeAXFR, 0))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/abh/geodns/v3/appconfig"
+	"github.com/abh/geodns/v3/applog"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// Dynamic updates (RFC 2136) are accepted for the zones in [update]
+// sections, signed with one of the TSIG keys for the zone.
+
+// SetupUpdates enables dynamic updates for the zones configured in the
+// [update "zone"] sections.
+func (srv *Server) SetupUpdates(config *appconfig.AppConfig) error {
+	updates := map[string]map[string]*tsigKey{}
+	for name, uc := range config.Update {
+		if uc == nil || len(uc.Key) == 0 {
+			return fmt.Errorf("no TSIG keys for updates to '%s'", name)
+		}
+		keys, err := tsigKeys(config, uc.Key)
+		if err != nil {
+			return err
+		}
+		updates[strings.TrimSuffix(strings.ToLower(name), ".")] = keys
+	}
+	srv.updates = updates
+	return nil
+}
+
+// serveUpdate applies an UPDATE to the zone if the zone allows updates
+// and the request is signed with one of its keys.
+func (srv *Server) serveUpdate(w dns.ResponseWriter, req *dns.Msg, z *zones.Zone, remote netip.Addr) {
+	var signer dns.TSIGSigner
+
+	rcode := func() uint16 {
+		keys, ok := srv.updates[z.Origin]
+		if !ok {
+			applog.Printf("[zone %s] UPDATE from %s refused: updates aren't enabled", z.Origin, remote)
+			return dns.RcodeRefused
+		}
+		if !strings.EqualFold(req.Question[0].Header().Name, dnsutil.Fqdn(z.Origin)) {
+			applog.Printf("[zone %s] UPDATE from %s refused: '%s' isn't the zone", z.Origin, remote, req.Question[0].Header().Name)
+			return dns.RcodeNotAuth
+		}
+		var err error
+		signer, err = verifyTSIG(req, keys)
+		if err != nil {
+			applog.Printf("[zone %s] UPDATE from %s refused: %s", z.Origin, remote, err)
+			return dns.RcodeNotAuth
+		}
+		applog.Printf("[zone %s] UPDATE from %s, %d prerequisites and %d updates", z.Origin, remote, len(req.Answer), len(req.Ns))
+		return z.Update(req.Answer, req.Ns)
+	}()
+
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, req)
+	m.Rcode = rcode
+
+	if signer != nil {
+		t := req.Pseudo[len(req.Pseudo)-1].(*dns.TSIG)
+		m.Pseudo = []dns.RR{dns.NewTSIG(t.Hdr.Name, t.Algorithm, 0)}
+		if err := dns.TSIGSign(m, signer, &dns.TSIGOption{RequestMAC: t.MAC}); err != nil {
+			applog.Printf("[zone %s] could not sign UPDATE response: %s", z.Origin, err)
+			return
+		}
+	}
+	if _, err := m.WriteTo(w); err != nil {
+		applog.Printf("error writing response: %s", err)
+	}
+}
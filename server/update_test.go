@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+	"github.com/abh/geodns/v3/appconfig"
+	"github.com/abh/geodns/v3/zones"
+)
+
+func testDynamicUpdate(srv *Server) func(*testing.T) {
+	return func(t *testing.T) {
+		secret := []byte("geodns update test secret")
+		config := &appconfig.AppConfig{}
+		config.Update = map[string]*struct{ Key []string }{
+			"dyn.example": {Key: []string{"update-key"}},
+		}
+		config.TSIG = map[string]*struct {
+			Algorithm string
+			Secret    string
+		}{
+			"update-key": {Secret: base64.StdEncoding.EncodeToString(secret)},
+		}
+		require.NoError(t, srv.SetupUpdates(config))
+
+		dir := t.TempDir()
+		data := `{ "data": { "": { "ns": [ "ns1.example.net" ] }, "www.europe": { "a": [ [ "192.0.2.1", 10 ] ] } } }`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "dyn.example.json"), []byte(data), 0644))
+		_, err := zones.NewMuxManager(dir, srv)
+		require.NoError(t, err)
+		defer srv.Remove("dyn.example")
+
+		update := func(zone string, key []byte, updates ...dns.RR) *dns.Msg {
+			t.Helper()
+			m := dns.NewMsg(zone, dns.TypeSOA)
+			m.Opcode = dns.OpcodeUpdate
+			m.Ns = updates
+			if key != nil {
+				m.Pseudo = []dns.RR{dns.NewTSIG("update-key.", dns.HmacSHA256, 0)}
+				require.NoError(t, dns.TSIGSign(m, dns.HmacTSIG{Secret: key}, &dns.TSIGOption{}))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			r, _, err := dns.NewClient().Exchange(ctx, m, "udp", "127.0.0.1"+PORT)
+			require.NoError(t, err)
+			return r
+		}
+		add, err := dns.New("www.europe.dyn.example. 5 IN A 192.0.2.2")
+		require.NoError(t, err)
+
+		r := update("dyn.example.", nil, add)
+		checkRcode(t, r.Rcode, dns.RcodeNotAuth, "unsigned update")
+		r = update("dyn.example.", []byte("wrong secret"), add)
+		checkRcode(t, r.Rcode, dns.RcodeNotAuth, "wrong key")
+		r = update("test.example.com.", secret, add)
+		checkRcode(t, r.Rcode, dns.RcodeRefused, "zone without updates")
+
+		r = update("dyn.example.", secret, add)
+		checkRcode(t, r.Rcode, dns.RcodeSuccess, "signed update")
+		assert.Len(t, exchange(t, "www.europe.dyn.example.", dns.TypeA).Answer, 2)
+
+		// deleting the A records has them without data
+		r = update("dyn.example.", secret, &dns.RFC3597{
+			Hdr:     dns.Header{Name: "www.europe.dyn.example.", Class: dns.ClassANY},
+			RFC3597: rdata.RFC3597{RRType: dns.TypeA},
+		})
+		checkRcode(t, r.Rcode, dns.RcodeSuccess, "delete the records")
+		checkRcode(t, exchange(t, "www.europe.dyn.example.", dns.TypeA).Rcode, dns.RcodeNameError, "deleted name")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/abh/geodns/v3/zones"
+)
+
+func testSourceViews(srv *Server) func(*testing.T) {
+	return func(t *testing.T) {
+		dir := t.TempDir()
+		data := `{
+  "views": {
+    "local": [ "127.0.0.0/8" ],
+    "office": { "networks": [ "198.51.100.0/24" ], "ecs": true },
+    "partner": { "networks": [ "203.0.113.0/24" ], "ecs": true, "resolvers": [ "192.0.2.53" ] }
+  },
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": { "a": [ [ "192.0.2.1" ] ] },
+    "www.local": { "a": [ [ "10.0.0.1" ] ] },
+    "www.office": { "a": [ [ "10.1.0.1" ] ] },
+    "www.partner": { "a": [ [ "10.2.0.1" ] ] },
+    "mail": { "a": [ [ "192.0.2.2" ] ] }
+  }
+}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "views.example.json"), []byte(data), 0644))
+		_, err := zones.NewMuxManager(dir, srv)
+		require.NoError(t, err)
+		defer srv.Remove("views.example")
+
+		addr := func(r *dns.Msg) string {
+			t.Helper()
+			require.Len(t, r.Answer, 1)
+			return r.Answer[0].(*dns.A).Addr.String()
+		}
+
+		// the tests query from 127.0.0.1
+		assert.Equal(t, "10.0.0.1", addr(exchange(t, "www.views.example.", dns.TypeA)))
+		assert.Equal(t, "192.0.2.2", addr(exchange(t, "mail.views.example.", dns.TypeA)), "names without the view")
+
+		r := exchangeSubnet(t, "www.views.example.", dns.TypeA, "198.51.100.7")
+		assert.Equal(t, "10.1.0.1", addr(r))
+		if subnet := findSubnet(r); assert.NotNil(t, subnet) {
+			assert.GreaterOrEqual(t, subnet.Scope, uint8(24))
+		}
+
+		// the local view doesn't use the ECS address, and the partner
+		// view only uses it in queries from its resolver
+		assert.Equal(t, "10.0.0.1", addr(exchangeSubnet(t, "www.views.example.", dns.TypeA, "203.0.113.1")))
+	}
+}
+
+func findSubnet(r *dns.Msg) *dns.SUBNET {
+	for _, rr := range r.Pseudo {
+		if s, ok := rr.(*dns.SUBNET); ok {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/abh/geodns/v3/appconfig"
+	"github.com/abh/geodns/v3/zones"
+)
+
+// The zone API lists the zones and changes the labels and records of the
+// zones from JSON files in the zone directory. The data is in the zone
+// file format. Changes need the hash of the zone (the ETag of the GET
+// responses) in an If-Match header, so they are only applied to the
+// version that was read.
+
+func (hs *httpServer) setupZoneAPI() {
+	hs.mux.HandleFunc("GET /zones", hs.authenticated(hs.zoneList))
+	hs.mux.HandleFunc("GET /zones/{zone}", hs.authenticated(hs.zoneGet))
+	hs.mux.HandleFunc("GET /zones/{zone}/labels/{label}", hs.authenticated(hs.labelGet))
+	hs.mux.HandleFunc("PUT /zones/{zone}/labels/{label}", hs.authenticated(hs.labelSet))
+	hs.mux.HandleFunc("DELETE /zones/{zone}/labels/{label}", hs.authenticated(hs.labelSet))
+	hs.mux.HandleFunc("GET /zones/{zone}/labels/{label}/{type}", hs.authenticated(hs.recordsGet))
+	hs.mux.HandleFunc("PUT /zones/{zone}/labels/{label}/{type}", hs.authenticated(hs.recordsSet))
+	hs.mux.HandleFunc("DELETE /zones/{zone}/labels/{label}/{type}", hs.authenticated(hs.recordsSet))
+}
+
+// authenticated only allows the requests if the [http] user is set, so
+// the basic authentication is required.
+func (hs *httpServer) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(appconfig.Config.HTTP.User) == 0 {
+			http.Error(w, "this requires the [http] user and password", http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
+
+type labelResponse struct {
+	Zone  string      `json:"zone"`
+	Hash  string      `json:"hash"`
+	Label string      `json:"label"`
+	Type  string      `json:"type,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// apiError writes the error as JSON, with the problems if the zone
+// wasn't valid.
+func apiError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var problems []string
+	var zerr zones.ZoneErrors
+	switch {
+	case errors.As(err, &zerr):
+		status = http.StatusUnprocessableEntity
+		for _, p := range zerr {
+			problems = append(problems, p.String())
+		}
+		err = errors.New("the zone isn't valid")
+	case errors.Is(err, zones.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, zones.ErrNotEditable):
+		status = http.StatusConflict
+	case errors.Is(err, zones.ErrZoneChanged):
+		status = http.StatusPreconditionFailed
+	}
+	writeJSON(w, status, struct {
+		Error    string   `json:"error"`
+		Problems []string `json:"problems,omitempty"`
+	}{err.Error(), problems})
+}
+
+func (hs *httpServer) zoneList(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, hs.zones.ZoneInfo())
+}
+
+func (hs *httpServer) zoneGet(w http.ResponseWriter, req *http.Request) {
+	zc, err := hs.zones.ZoneContents(req.PathValue("zone"))
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+zc.Hash+`"`)
+	writeJSON(w, http.StatusOK, zc)
+}
+
+// labelData returns the data for the label (and type, if set) in the
+// zone being served.
+func (hs *httpServer) labelData(name, label, rtype string) (labelResponse, error) {
+	zc, err := hs.zones.ZoneContents(name)
+	if err != nil {
+		return labelResponse{}, err
+	}
+	r := labelResponse{Zone: zc.Zone, Hash: zc.Hash, Label: label, Type: rtype}
+
+	key := strings.ToLower(label)
+	if key == "@" {
+		key = ""
+	}
+	zoneData, _ := zc.Contents["data"].(map[string]interface{})
+	labelData, ok := zoneData[key].(map[string]interface{})
+	if !ok {
+		return r, fmt.Errorf("label '%s' %w", label, zones.ErrNotFound)
+	}
+	r.Data = labelData
+	if len(rtype) > 0 {
+		if r.Data, ok = labelData[strings.ToLower(rtype)]; !ok {
+			return r, fmt.Errorf("%s records for label '%s' %w", rtype, label, zones.ErrNotFound)
+		}
+	}
+	return r, nil
+}
+
+func (hs *httpServer) labelGet(w http.ResponseWriter, req *http.Request) {
+	hs.getLabelData(w, req, "")
+}
+
+func (hs *httpServer) recordsGet(w http.ResponseWriter, req *http.Request) {
+	hs.getLabelData(w, req, req.PathValue("type"))
+}
+
+func (hs *httpServer) getLabelData(w http.ResponseWriter, req *http.Request, rtype string) {
+	r, err := hs.labelData(req.PathValue("zone"), req.PathValue("label"), rtype)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+r.Hash+`"`)
+	writeJSON(w, http.StatusOK, r)
+}
+
+// ifMatch returns the zone hash from the If-Match header.
+func ifMatch(w http.ResponseWriter, req *http.Request) (string, bool) {
+	hash := strings.Trim(strings.TrimPrefix(req.Header.Get("If-Match"), "W/"), `"`)
+	if len(hash) == 0 || hash == "*" {
+		http.Error(w, "the zone hash is required in If-Match", http.StatusPreconditionRequired)
+		return "", false
+	}
+	return hash, true
+}
+
+func (hs *httpServer) labelSet(w http.ResponseWriter, req *http.Request) {
+	hs.setLabelData(w, req, "")
+}
+
+func (hs *httpServer) recordsSet(w http.ResponseWriter, req *http.Request) {
+	hs.setLabelData(w, req, req.PathValue("type"))
+}
+
+// setLabelData replaces (PUT) or deletes the label, or the records of a
+// type if rtype is set.
+func (hs *httpServer) setLabelData(w http.ResponseWriter, req *http.Request, rtype string) {
+	hash, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+	name, label := req.PathValue("zone"), req.PathValue("label")
+
+	var data interface{}
+	if req.Method == http.MethodPut {
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&data); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %s", err), http.StatusBadRequest)
+			return
+		}
+		if data == nil {
+			http.Error(w, "use DELETE to remove data", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	if len(rtype) > 0 {
+		hash, err = hs.zones.SetRecords(name, hash, label, rtype, data)
+	} else {
+		labelData, isMap := data.(map[string]interface{})
+		if data != nil && !isMap {
+			http.Error(w, "the label data must be an object", http.StatusBadRequest)
+			return
+		}
+		hash, err = hs.zones.SetLabel(name, hash, label, labelData)
+	}
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+hash+`"`)
+	writeJSON(w, http.StatusOK, labelResponse{
+		Zone:  name,
+		Hash:  hash,
+		Label: label,
+		Type:  rtype,
+		Data:  data,
+	})
+}
@@ -0,0 +1,96 @@
+package zones
+
+import (
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// maxAliasDepth is how many aliases are followed before giving up
+const maxAliasDepth = 8
+
+// findAlias looks up the target of an alias. Targets that aren't FQDNs
+// are labels in the zone; FQDNs can point to any zone loaded by the same
+// MuxManager. The client targeting is applied in the other zone as well.
+func (z *Zone) findAlias(name string, targets []string, qts []uint16, depth int) []LabelMatch {
+	zone, s, ok := z.aliasTarget(name)
+	if !ok {
+		return nil
+	}
+	return zone.findLabels(s, targets, qts, depth)
+}
+
+// aliasTarget returns the zone and label name an alias points to.
+func (z *Zone) aliasTarget(name string) (*Zone, string, bool) {
+	if !dnsutil.IsFqdn(name) {
+		return z, strings.ToLower(name), true
+	}
+
+	zone := z
+	if !dnsutil.IsBelow(z.Origin+".", name) {
+		if z.lookupZone == nil {
+			return nil, "", false
+		}
+		if zone = z.lookupZone(name); zone == nil {
+			return nil, "", false
+		}
+	}
+
+	s := strings.TrimSuffix(strings.ToLower(name), strings.ToLower(zone.Origin)+".")
+	return zone, strings.TrimSuffix(s, "."), true
+}
+
+// checkAliases makes sure the aliases within the zone don't loop and that
+// the chains aren't longer than what will be followed when serving.
+func (z *Zone) checkAliases(c *zoneCheck) {
+	for k, label := range z.Labels {
+		if len(label.Records[dns.TypeMF]) == 0 {
+			continue
+		}
+
+		path := pathKey(pathKey("data", k), "alias")
+		chain := []string{k}
+	chain:
+		for current := label; len(current.Records[dns.TypeMF]) > 0; {
+			zone, name, ok := z.aliasTarget(current.FirstRR(dns.TypeMF).(*dns.MF).Mf)
+			if !ok || zone != z {
+				// other zones are checked when serving
+				break
+			}
+
+			for _, l := range chain {
+				if l == name {
+					c.errorf(path, "alias loop: %s -> %s", strings.Join(quoteLabels(chain), " -> "), quoteLabel(name))
+					break chain
+				}
+			}
+			chain = append(chain, name)
+			if len(chain) > maxAliasDepth+1 {
+				c.errorf(path, "alias chain from %s is longer than %d", quoteLabel(k), maxAliasDepth)
+				break
+			}
+
+			next, ok := z.Labels[name]
+			if !ok {
+				break
+			}
+			current = next
+		}
+	}
+}
+
+func quoteLabel(s string) string {
+	if len(s) == 0 {
+		return "'@'"
+	}
+	return "'" + s + "'"
+}
+
+func quoteLabels(labels []string) []string {
+	quoted := make([]string, len(labels))
+	for i, s := range labels {
+		quoted[i] = quoteLabel(s)
+	}
+	return quoted
+}
@@ -0,0 +1,65 @@
+package zones
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasLoops(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"loop", `{"a": {"alias": "b"}, "b": {"alias": "a"}}`, "alias loop: 'a' -> 'b' -> 'a'"},
+		{"self", `{"a": {"alias": "a.loop.example."}}`, "alias loop: 'a' -> 'a'"},
+		{"apex", `{"": {"alias": "www"}, "www": {"alias": ""}}`, "alias loop"},
+		{"long", `{"a1": {"alias": "a2"}, "a2": {"alias": "a3"}, "a3": {"alias": "a4"},
+			"a4": {"alias": "a5"}, "a5": {"alias": "a6"}, "a6": {"alias": "a7"},
+			"a7": {"alias": "a8"}, "a8": {"alias": "a9"}, "a9": {"alias": "a10"},
+			"a10": {"a": [["192.168.1.1"]]}}`, "alias chain from 'a1' is longer than 8"},
+		{"ok", `{"a": {"alias": "b"}, "b": {"alias": "c"}, "c": {"a": [["192.168.1.1"]]}}`, ""},
+	}
+
+	for _, tc := range tests {
+		fileName := filepath.Join(dir, tc.name+".json")
+		err := os.WriteFile(fileName, []byte(`{"data": `+tc.data+`}`), 0644)
+		require.NoError(t, err)
+
+		zone := NewZone("loop.example")
+		err = zone.ReadZoneFile(fileName)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+			continue
+		}
+		if assert.Error(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.err, tc.name)
+		}
+	}
+}
+
+func TestAliasOtherZone(t *testing.T) {
+	mm, err := NewMuxManager("../dns", &NilReg{})
+	require.NoError(t, err)
+
+	ex := mm.zonelist["test.example.com"]
+	org := mm.zonelist["test.example.org"]
+
+	m := ex.findFirstLabel("cross-alias", []string{"@"}, []uint16{dns.TypeMF, dns.TypeA})
+	require.NotNil(t, m)
+	assert.Equal(t, dns.TypeA, m.Type)
+	assert.Same(t, org.Labels["aliased"], m.Label)
+
+	// aliases looping between zones stop at the depth limit
+	matches := ex.FindLabels("xloop", []string{"@"}, []uint16{dns.TypeMF, dns.TypeA})
+	for _, m := range matches {
+		assert.NotEqual(t, dns.TypeA, m.Type)
+	}
+}
@@ -0,0 +1,280 @@
+package zones
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+const (
+	// signatures are valid for this long ...
+	sigValidity = 7 * 24 * time.Hour
+	// ... and get replaced when they have less than this left
+	sigRefresh = 2 * 24 * time.Hour
+	// allow for some clock skew on the validators
+	sigInceptionSkew = time.Hour
+
+	sigCacheSize = 10000
+)
+
+type signingKey struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+// Signer does online DNSSEC signing for a zone. Answers are synthesized
+// per client so the picked RRsets are signed as they are served; the
+// signatures are cached so the signing cost is bounded by the number of
+// distinct RRsets rather than the number of queries.
+type Signer struct {
+	origin string
+	ksk    []*signingKey
+	zsk    []*signingKey
+
+	mu    sync.Mutex
+	cache map[string]*dns.RRSIG
+}
+
+// loadSigner looks for BIND style key files (K<zone>.+<alg>+<tag>.key and
+// .private) for the zone in dir. Keys with the SEP flag are used as KSKs;
+// if there are no other keys they sign the whole zone (CSK). Returns nil
+// if the zone doesn't have any keys.
+func loadSigner(origin, dir string) (*Signer, error) {
+	origin = dnsutil.Fqdn(origin)
+
+	files, err := filepath.Glob(filepath.Join(dir, "K"+origin+"+*.key"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	slices.Sort(files)
+
+	s := &Signer{
+		origin: origin,
+		cache:  map[string]*dns.RRSIG{},
+	}
+
+	for _, fileName := range files {
+		k, err := readSigningKey(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if !dns.EqualName(k.key.Hdr.Name, origin) {
+			return nil, fmt.Errorf("key %s is for '%s', not '%s'", fileName, k.key.Hdr.Name, origin)
+		}
+		if k.key.Flags&dns.FlagSEP != 0 {
+			s.ksk = append(s.ksk, k)
+		} else {
+			s.zsk = append(s.zsk, k)
+		}
+	}
+
+	if len(s.zsk) == 0 {
+		// combined signing key
+		s.zsk = s.ksk
+	}
+	if len(s.ksk) == 0 {
+		return nil, fmt.Errorf("no key signing key (flags 257) found for '%s'", origin)
+	}
+
+	return s, nil
+}
+
+func readSigningKey(fileName string) (*signingKey, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var key *dns.DNSKEY
+	zp := dns.NewZoneParser(fh, "", fileName)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if k, ok := rr.(*dns.DNSKEY); ok {
+			key = k
+			break
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no DNSKEY record in %s", fileName)
+	}
+
+	privateName := strings.TrimSuffix(fileName, ".key") + ".private"
+	privateData, err := os.ReadFile(privateName)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := key.NewPrivate(string(privateData))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", privateName, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key type %T", privateName, priv)
+	}
+
+	return &signingKey{key: key, signer: signer}, nil
+}
+
+// DNSKEYs returns the public keys for the zone apex.
+func (s *Signer) DNSKEYs() []*dns.DNSKEY {
+	keys := []*dns.DNSKEY{}
+	for _, k := range s.ksk {
+		keys = append(keys, k.key)
+	}
+	for _, k := range s.zsk {
+		if !slices.Contains(keys, k.key) {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys
+}
+
+// Sign returns the RRSIG records for the RRset. The TTLs in rrset are
+// set to the lowest TTL in the set, as required by RFC 2181.
+func (s *Signer) Sign(rrset []dns.RR) ([]dns.RR, error) {
+	if len(rrset) == 0 {
+		return nil, nil
+	}
+
+	keys := s.zsk
+	if dns.RRToType(rrset[0]) == dns.TypeDNSKEY {
+		keys = s.ksk
+	}
+
+	rrset = canonicalRRset(rrset)
+	cacheKey := rrsetKey(rrset)
+
+	now := time.Now()
+
+	sigs := make([]dns.RR, 0, len(keys))
+	for _, k := range keys {
+		sig, err := s.sign(k, rrset, cacheKey, now)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+
+	return sigs, nil
+}
+
+func (s *Signer) sign(k *signingKey, rrset []dns.RR, cacheKey string, now time.Time) (*dns.RRSIG, error) {
+	cacheKey = fmt.Sprintf("%d/%s", k.key.KeyTag(), cacheKey)
+
+	s.mu.Lock()
+	sig, ok := s.cache[cacheKey]
+	s.mu.Unlock()
+
+	if ok && time.Unix(int64(sig.Expiration), 0).Sub(now) > sigRefresh {
+		return sig.Clone().(*dns.RRSIG), nil
+	}
+
+	sig = dns.NewRRSIG(s.origin, k.key.Algorithm, k.key.KeyTag(),
+		uint32(now.Add(-sigInceptionSkew).Unix()),
+		uint32(now.Add(sigValidity).Unix()),
+	)
+
+	// Sign canonicalizes and sorts the records it's given
+	signSet := make([]dns.RR, len(rrset))
+	for i, rr := range rrset {
+		signSet[i] = rr.Clone()
+	}
+
+	if err := sig.Sign(k.signer, signSet, &dns.SignOption{}); err != nil {
+		return nil, fmt.Errorf("signing %s/%s: %w", rrset[0].Header().Name,
+			dnsutil.TypeToString(dns.RRToType(rrset[0])), err)
+	}
+	// use the name as it'll be in the response, not the lowercased one
+	sig.Hdr.Name = rrset[0].Header().Name
+
+	s.mu.Lock()
+	if len(s.cache) >= sigCacheSize {
+		// not LRU, but good enough to keep the memory use bounded
+		for ck := range s.cache {
+			delete(s.cache, ck)
+			if len(s.cache) < sigCacheSize/2 {
+				break
+			}
+		}
+	}
+	s.cache[cacheKey] = sig
+	s.mu.Unlock()
+
+	return sig.Clone().(*dns.RRSIG), nil
+}
+
+// canonicalRRset makes sure the RRset has the same TTL on all records as
+// required by RFC 2181, using the lowest TTL in the set.
+func canonicalRRset(rrset []dns.RR) []dns.RR {
+	ttl := rrset[0].Header().TTL
+	for _, rr := range rrset[1:] {
+		if rr.Header().TTL < ttl {
+			ttl = rr.Header().TTL
+		}
+	}
+	for _, rr := range rrset {
+		rr.Header().TTL = ttl
+	}
+	return rrset
+}
+
+// rrsetKey returns a string identifying the picked RRset, independent of
+// the order of the records.
+func rrsetKey(rrset []dns.RR) string {
+	rrs := make([]string, len(rrset))
+	for i, rr := range rrset {
+		rrs[i] = strings.ToLower(rr.String())
+	}
+	slices.Sort(rrs)
+	return strings.Join(rrs, "\n")
+}
+
+func (z *Zone) setupDNSSEC(dir string) error {
+	signer, err := loadSigner(z.Origin, dir)
+	if err != nil {
+		return err
+	}
+	z.Signer = signer
+	if signer == nil {
+		return nil
+	}
+
+	label, ok := z.Labels[""]
+	if !ok {
+		label = z.AddLabel("")
+	}
+
+	keys := signer.DNSKEYs()
+	label.Records[dns.TypeDNSKEY] = make(Records, len(keys))
+	for i, key := range keys {
+		rr := key.Clone().(*dns.DNSKEY)
+		rr.Hdr.Name = z.Origin + "."
+		if rr.Hdr.TTL == 0 {
+			rr.Hdr.TTL = uint32(z.Options.Ttl)
+		}
+		label.Records[dns.TypeDNSKEY][i] = &Record{RR: rr}
+	}
+
+	return nil
+}
+
+// NegativeTTL is the TTL for negative answers (RFC 2308), the lower of
+// the SOA TTL and the SOA minimum field.
+func (z *Zone) NegativeTTL() uint32 {
+	soa := z.SoaRR().(*dns.SOA)
+	return min(soa.Hdr.TTL, soa.Minttl)
+}
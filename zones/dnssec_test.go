@@ -0,0 +1,53 @@
+package zones
+
+import (
+	"testing"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSECSigner(t *testing.T) {
+	zone := NewZone("dnssec.example.com")
+	err := zone.ReadZoneFile("../dns/dnssec.example.com.json")
+	require.NoError(t, err)
+	require.NotNil(t, zone.Signer, "zone has keys")
+
+	m := zone.findFirstLabel("", []string{"@"}, []uint16{dns.TypeDNSKEY})
+	require.NotNil(t, m)
+	keys := m.Label.Records[dns.TypeDNSKEY]
+	require.Len(t, keys, 1)
+	key := keys[0].RR.(*dns.DNSKEY)
+	assert.Equal(t, "dnssec.example.com.", key.Hdr.Name)
+	assert.Equal(t, uint16(257), key.Flags)
+
+	rrset := []dns.RR{}
+	for _, r := range zone.Picker(zone.Labels[""], dns.TypeA, 2, nil) {
+		rrset = append(rrset, r.RR.Clone())
+	}
+	require.Len(t, rrset, 2)
+
+	sigs, err := zone.Signer.Sign(rrset)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	sig := sigs[0].(*dns.RRSIG)
+	assert.Equal(t, dns.TypeA, sig.TypeCovered)
+	assert.Equal(t, key.KeyTag(), sig.KeyTag)
+
+	verifySet := []dns.RR{rrset[0].Clone(), rrset[1].Clone()}
+	require.NoError(t, sig.Verify(key, verifySet, &dns.SignOption{}))
+
+	// same RRset in a different order comes from the cache
+	sigs2, err := zone.Signer.Sign([]dns.RR{rrset[1], rrset[0]})
+	require.NoError(t, err)
+	assert.Equal(t, sig.Signature, sigs2[0].(*dns.RRSIG).Signature)
+}
+
+func TestDNSSECNoKeys(t *testing.T) {
+	zone := NewZone("test.example.com")
+	err := zone.ReadZoneFile("../dns/test.example.com.json")
+	require.NoError(t, err)
+	assert.Nil(t, zone.Signer)
+	assert.Nil(t, zone.Labels[""].Records[dns.TypeDNSKEY])
+}
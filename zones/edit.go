@@ -0,0 +1,363 @@
+package zones
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+// Zones read from JSON files in a zone directory (that isn't atomic) can
+// be changed with dynamic updates and the HTTP API. The changes are
+// written to the zone file (see editJSON) and loaded from it like any
+// other change, so they are validated the same way and kept when the zone
+// is reloaded.
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrNotEditable = errors.New("can't be changed")
+	ErrZoneChanged = errors.New("zone was changed")
+)
+
+// ZoneInfo describes a zone being served.
+type ZoneInfo struct {
+	Zone     string `json:"zone"`
+	Serial   uint32 `json:"serial"`
+	Hash     string `json:"hash,omitempty"` // of the zone file being served
+	FileName string `json:"file,omitempty"`
+	Editable bool   `json:"editable"`
+}
+
+// ZoneContents is the zone file being served, in the JSON zone format.
+type ZoneContents struct {
+	ZoneInfo
+	Contents map[string]interface{} `json:"contents"`
+}
+
+// ZoneInfo returns the zones being served, sorted by name.
+func (mm *MuxManager) ZoneInfo() []ZoneInfo {
+	mm.loadMu.Lock()
+	defer mm.loadMu.Unlock()
+
+	mm.zonesMu.RLock()
+	names := make([]string, 0, len(mm.zonelist))
+	for name := range mm.zonelist {
+		if name != "pgeodns" {
+			names = append(names, name)
+		}
+	}
+	mm.zonesMu.RUnlock()
+	slices.Sort(names)
+
+	r := make([]ZoneInfo, 0, len(names))
+	for _, name := range names {
+		if info, ok := mm.zoneInfo(name); ok {
+			r = append(r, info)
+		}
+	}
+	return r
+}
+
+// zoneInfo returns the information for a zone; the caller must hold
+// loadMu.
+func (mm *MuxManager) zoneInfo(name string) (ZoneInfo, bool) {
+	mm.zonesMu.RLock()
+	zone := mm.zonelist[name]
+	mm.zonesMu.RUnlock()
+	if zone == nil || name == "pgeodns" {
+		return ZoneInfo{}, false
+	}
+
+	info := ZoneInfo{Zone: name}
+	if soa, ok := zone.SoaRR().(*dns.SOA); ok {
+		info.Serial = soa.Serial
+	}
+	mm.historyMu.Lock()
+	if h, ok := mm.histories[name]; ok {
+		info.Hash = h.live
+		if v, err := h.version(h.live); err == nil {
+			info.FileName = v.FileName
+		}
+	}
+	mm.historyMu.Unlock()
+	_, err := mm.editable(name)
+	info.Editable = err == nil
+	return info, true
+}
+
+// ZoneContents returns the zone file being served for the zone.
+func (mm *MuxManager) ZoneContents(name string) (ZoneContents, error) {
+	mm.loadMu.Lock()
+	defer mm.loadMu.Unlock()
+
+	info, ok := mm.zoneInfo(name)
+	if !ok {
+		return ZoneContents{}, fmt.Errorf("zone '%s' %w", name, ErrNotFound)
+	}
+
+	mm.historyMu.Lock()
+	var v *zoneVersion
+	if h, ok := mm.histories[name]; ok {
+		v, _ = h.version(h.live)
+	}
+	mm.historyMu.Unlock()
+	if v == nil {
+		return ZoneContents{}, fmt.Errorf("zone '%s' isn't from a zone file", name)
+	}
+
+	objmap, err := zoneFileMap(name, v.zf.FileName, v.data)
+	if err != nil {
+		return ZoneContents{}, err
+	}
+	return ZoneContents{ZoneInfo: info, Contents: objmap}, nil
+}
+
+// zoneFileMap parses the zone file contents into the JSON zone format.
+func zoneFileMap(name, fileName string, data []byte) (map[string]interface{}, error) {
+	var objmap map[string]interface{}
+	if strings.HasSuffix(strings.ToLower(fileName), ".zone") {
+		var err error
+		objmap, _, err = readMasterFile(bytes.NewReader(data), name, fileName)
+		return objmap, err
+	}
+	err := json.Unmarshal(data, &objmap)
+	return objmap, err
+}
+
+// SetLabel replaces the data for the label ("@" for the zone apex), or
+// removes the label if data is nil. If hash isn't empty it must be the
+// hash of the zone file being served. It returns the hash of the changed
+// zone file.
+func (mm *MuxManager) SetLabel(name, hash, label string, data map[string]interface{}) (string, error) {
+	label = dataLabel(label)
+	return mm.editZone(name, hash, func(zone *Zone, objmap map[string]interface{}) error {
+		zoneData := zoneDataMap(objmap)
+		if data == nil {
+			if _, ok := zoneData[label]; !ok {
+				return fmt.Errorf("label '%s' %w", label, ErrNotFound)
+			}
+			delete(zoneData, label)
+			return nil
+		}
+		zoneData[label] = data
+		return nil
+	})
+}
+
+// SetRecords replaces the records of a type (as in the zone data, for
+// example "a") for the label, or removes them if records is nil. It's
+// otherwise like SetLabel.
+func (mm *MuxManager) SetRecords(name, hash, label, rtype string, records interface{}) (string, error) {
+	label = dataLabel(label)
+	rtype = strings.ToLower(rtype)
+	if _, ok := zoneRecordTypes[rtype]; !ok {
+		return "", ZoneErrors{{
+			Path:     pathKey(pathKey("data", label), rtype),
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("unsupported record type '%s'", rtype),
+		}}
+	}
+
+	return mm.editZone(name, hash, func(zone *Zone, objmap map[string]interface{}) error {
+		zoneData := zoneDataMap(objmap)
+		labelData, ok := zoneData[label].(map[string]interface{})
+		if records == nil {
+			if _, found := labelData[rtype]; !ok || !found {
+				return fmt.Errorf("%s records for label '%s' %w", rtype, label, ErrNotFound)
+			}
+			delete(labelData, rtype)
+			if len(label) > 0 && len(labelData) == 0 {
+				delete(zoneData, label)
+			}
+			return nil
+		}
+		if !ok {
+			labelData = map[string]interface{}{}
+			zoneData[label] = labelData
+		}
+		labelData[rtype] = records
+		return nil
+	})
+}
+
+// dataLabel returns the key in the zone data for a label.
+func dataLabel(label string) string {
+	label = strings.ToLower(label)
+	if label == "@" {
+		return ""
+	}
+	return label
+}
+
+func zoneDataMap(objmap map[string]interface{}) map[string]interface{} {
+	zoneData, ok := objmap["data"].(map[string]interface{})
+	if !ok {
+		zoneData = map[string]interface{}{}
+		objmap["data"] = zoneData
+	}
+	return zoneData
+}
+
+// editable returns the read record for a zone that can be changed, or
+// why it can't be. The caller must hold loadMu.
+func (mm *MuxManager) editable(name string) (*zoneReadRecord, error) {
+	mm.zonesMu.RLock()
+	zone := mm.zonelist[name]
+	mm.zonesMu.RUnlock()
+	if zone == nil {
+		return nil, fmt.Errorf("zone '%s' %w", name, ErrNotFound)
+	}
+
+	r, ok := mm.lastRead[name]
+	if !ok {
+		return nil, fmt.Errorf("zone '%s' %w, it isn't from a zone file", name, ErrNotEditable)
+	}
+	if _, ok := mm.secondaries[name]; ok {
+		return nil, fmt.Errorf("zone '%s' %w, it's a secondary zone", name, ErrNotEditable)
+	}
+	if s, ok := r.source.(*DirSource); !ok || s.Atomic || !strings.HasSuffix(strings.ToLower(r.zf.FileName), ".json") {
+		return nil, fmt.Errorf("zone '%s' %w, only zones from JSON files in a zone directory can be", name, ErrNotEditable)
+	}
+
+	mm.historyMu.Lock()
+	h := mm.histories[name]
+	pinned := h != nil && h.pinned
+	mm.historyMu.Unlock()
+	if pinned {
+		return nil, fmt.Errorf("zone '%s' %w, it's pinned", name, ErrNotEditable)
+	}
+	return r, nil
+}
+
+// editZone changes the zone data with fn and, if anything changed, writes
+// the zone file and loads the zone from it. fn gets the zone being served
+// and the contents of the zone file. If hash isn't empty it must be the
+// hash of the zone file being served. It returns the hash of the new zone
+// file.
+func (mm *MuxManager) editZone(name, hash string, fn func(zone *Zone, objmap map[string]interface{}) error) (string, error) {
+	mm.loadMu.Lock()
+	defer mm.loadMu.Unlock()
+
+	r, err := mm.editable(name)
+	if err != nil {
+		return "", err
+	}
+	if len(hash) > 0 && hash != r.hash {
+		return "", fmt.Errorf("%w, the current hash is %s", ErrZoneChanged, r.hash)
+	}
+
+	mm.zonesMu.RLock()
+	zone := mm.zonelist[name]
+	mm.zonesMu.RUnlock()
+
+	data, err := os.ReadFile(r.zf.FileName)
+	if err != nil {
+		return "", err
+	}
+	if sha256Data(data) != r.hash {
+		return "", fmt.Errorf("%w, %s changed since it was loaded", ErrZoneChanged, r.zf.FileName)
+	}
+
+	var before, objmap map[string]interface{}
+	if err := json.Unmarshal(data, &before); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(data, &objmap); err != nil {
+		return "", err
+	}
+
+	if err := fn(zone, objmap); err != nil {
+		return "", err
+	}
+	if sameJSON(before, objmap) {
+		return r.hash, nil
+	}
+
+	// the modification time is the serial for zones without one, and
+	// the serial policies set the serial themselves
+	policy := zone.Options.SerialPolicy
+	if len(policy) == 0 {
+		policy = mm.serialPolicy
+	}
+	if serial, ok := objmap["serial"].(float64); ok && (len(policy) == 0 || policy == SerialFromFile) {
+		objmap["serial"] = serial + 1
+	}
+	mtime := time.Now().Truncate(time.Second)
+	if !mtime.After(r.zf.ModTime) {
+		mtime = r.zf.ModTime.Truncate(time.Second).Add(time.Second)
+	}
+
+	data, err = editJSON(data, bytes.IndexByte(data, '{'), before, objmap)
+	if err != nil {
+		return "", fmt.Errorf("could not change %s: %w", r.zf.FileName, err)
+	}
+	var changed map[string]interface{}
+	if err := json.Unmarshal(data, &changed); err != nil || !sameJSON(changed, objmap) {
+		return "", fmt.Errorf("could not change %s", r.zf.FileName)
+	}
+
+	// the same parsing and checks as when the file is read
+	zf := r.zf
+	zf.ModTime = mtime
+	newZone, err := mm.newZone(zf, data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeZoneFile(zf.FileName, data, mtime); err != nil {
+		return "", err
+	}
+	fileInfo, err := os.Stat(zf.FileName)
+	if err != nil {
+		return "", err
+	}
+	zf = dirZoneFile(name, zf.KeyDir, fileInfo)
+
+	hash = sha256Data(data)
+	r.zf = zf
+	r.version = zf.Version
+	r.hash = hash
+
+	log.Printf("Zone '%s' changed, writing %s", name, zf.FileName)
+	mm.loaded(zf, data, hash, newZone, nil)
+	if err := mm.serials.Save(); err != nil {
+		log.Println(err.Error())
+	}
+	return hash, nil
+}
+
+// writeZoneFile replaces the file, with the modification time set to
+// mtime.
+func writeZoneFile(fileName string, data []byte, mtime time.Time) error {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fileInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
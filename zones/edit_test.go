@@ -0,0 +1,122 @@
+package zones
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestZoneContents(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com.json"), []byte(testZoneJSON("192.0.2.1")), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.org.zone"), []byte(`
+$ORIGIN example.org.
+@   3600 IN NS ns1.example.net.
+www 600  IN A  192.0.2.2
+`), 0644))
+
+	mm, err := NewMuxManager(dir, &testReg{zones: map[string]*Zone{}})
+	require.NoError(t, err)
+
+	info := mm.ZoneInfo()
+	require.Len(t, info, 2)
+	assert.Equal(t, "example.com", info[0].Zone)
+	assert.True(t, info[0].Editable)
+	assert.False(t, info[1].Editable, "master files can't be changed")
+
+	zc, err := mm.ZoneContents("example.org")
+	require.NoError(t, err)
+	assert.Equal(t, info[1].Hash, zc.Hash)
+	assert.Contains(t, zc.Contents["data"], "www")
+
+	_, err = mm.SetLabel("example.org", "", "www", nil)
+	assert.ErrorIs(t, err, ErrNotEditable)
+	_, err = mm.SetLabel("example.net", "", "www", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = mm.SetRecords("example.com", "nope", "www", "a", nil)
+	assert.ErrorIs(t, err, ErrZoneChanged)
+
+	hash, err := mm.SetRecords("example.com", info[0].Hash, "@", "mx", []interface{}{
+		map[string]interface{}{"mx": "mx.example.net", "preference": 10},
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, info[0].Hash, hash)
+	assert.Len(t, mm.Zones()["example.com"].Labels[""].Records[dns.TypeMX], 1)
+}
+
+func TestEditZoneFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "example.com.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{
+    "serial": 5,
+    "ttl": 600,
+    "data": {
+        "": { "ns": [ "ns1.example.net" ] },
+        "www": { "a": [ [ "192.0.2.1", 10 ] ] },
+        "mail": {
+            "a": [ [ "192.0.2.25" ] ]
+        },
+        "ftp": {
+            "cname": "www"
+        }
+    }
+}
+`), 0644))
+
+	mm, err := NewMuxManager(dir, &testReg{zones: map[string]*Zone{}})
+	require.NoError(t, err)
+
+	_, err = mm.SetRecords("example.com", "", "www", "aaaa", []interface{}{[]interface{}{"2001:db8::1"}})
+	require.NoError(t, err)
+	_, err = mm.SetLabel("example.com", "", "mail", nil)
+	require.NoError(t, err)
+	_, err = mm.SetRecords("example.com", "", "ftp", "a", []interface{}{[]interface{}{"192.0.2.21"}})
+	require.NoError(t, err)
+	_, err = mm.SetRecords("example.com", "", "ftp", "cname", nil)
+	require.NoError(t, err)
+
+	// only the changes are written, the rest of the file is kept
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+    "serial": 9,
+    "ttl": 600,
+    "data": {
+        "": { "ns": [ "ns1.example.net" ] },
+        "www": { "a": [ [ "192.0.2.1", 10 ] ], "aaaa": [["2001:db8::1"]] },
+        "ftp": {
+            "a": [
+                [
+                    "192.0.2.21"
+                ]
+            ]
+        }
+    }
+}
+`, string(data))
+
+	// the serial in the file is only changed without a serial policy
+	require.NoError(t, os.WriteFile(fileName, []byte(`{
+  "serial": 5,
+  "serial_policy": "content-hash",
+  "data": { "": { "ns": [ "ns1.example.net" ] } }
+}
+`), 0644))
+	require.NoError(t, mm.reload(context.Background()))
+	_, err = mm.SetRecords("example.com", "", "www", "a", []interface{}{[]interface{}{"192.0.2.1"}})
+	require.NoError(t, err)
+	data, err = os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "serial": 5,
+  "serial_policy": "content-hash",
+  "data": { "": { "ns": [ "ns1.example.net" ] }, "www": {"a":[["192.0.2.1"]]} }
+}
+`, string(data))
+}
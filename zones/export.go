@@ -0,0 +1,306 @@
+package zones
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abh/geodns/v3/countries"
+	"github.com/abh/geodns/v3/targeting"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// View is a list of targets the zone can be resolved for, in the order
+// they'd be used for a query from that location.
+type View struct {
+	Name    string
+	Targets []string
+}
+
+var asnTarget = regexp.MustCompile(`^as\d+$`)
+
+// viewTargets returns the targets used for queries matching target t,
+// or nil if t isn't a target name (or isn't enabled for the zone).
+func (z *Zone) viewTargets(t string) []string {
+	opts := z.Options.Targeting
+
+	if z.sourceView(t) != nil {
+		if opts&targeting.TargetGlobal > 0 {
+			return []string{t, "@"}
+		}
+		return []string{t}
+	}
+
+	var targets []string
+	add := func(opt targeting.TargetOptions, t string) {
+		if opts&opt > 0 && len(t) > 0 {
+			targets = append(targets, t)
+		}
+	}
+
+	var kind targeting.TargetOptions
+
+	switch {
+	case t == "@":
+		kind = targeting.TargetGlobal
+	case strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]"):
+		kind = targeting.TargetIP
+		add(kind, t)
+	case asnTarget.MatchString(t):
+		kind = targeting.TargetASN
+		add(kind, t)
+	case len(countries.ContinentCountries[t]) > 0:
+		kind = targeting.TargetContinent
+		add(kind, t)
+	case len(countries.CountryContinent[t]) > 0:
+		kind = targeting.TargetCountry
+		add(kind, t)
+		add(targeting.TargetContinent, countries.CountryContinent[t])
+	case len(countries.RegionGroupRegions[t]) > 0:
+		kind = targeting.TargetRegionGroup
+		cc, _, _ := strings.Cut(t, "-")
+		add(kind, t)
+		add(targeting.TargetCountry, cc)
+		add(targeting.TargetContinent, countries.CountryContinent[cc])
+	default:
+		cc, _, ok := strings.Cut(t, "-")
+		if !ok || len(countries.CountryContinent[cc]) == 0 {
+			return nil
+		}
+		kind = targeting.TargetRegion
+		add(kind, t)
+		add(targeting.TargetRegionGroup, countries.RegionGroups[t])
+		add(targeting.TargetCountry, cc)
+		add(targeting.TargetContinent, countries.CountryContinent[cc])
+	}
+
+	if opts&kind == 0 {
+		return nil
+	}
+	add(targeting.TargetGlobal, "@")
+	return targets
+}
+
+// labelTarget splits a label name in the name and the target.
+func labelTarget(k string) (string, string) {
+	var i int
+	if strings.HasSuffix(k, "]") {
+		i = strings.LastIndex(k, "[")
+	} else {
+		i = strings.LastIndex(k, ".") + 1
+	}
+	if i <= 0 {
+		return "", k
+	}
+	return strings.TrimSuffix(k[:i], "."), k[i:]
+}
+
+// Views returns the global view and a view for each target used
+// in the zone.
+func (z *Zone) Views() []View {
+	views := []View{}
+	if z.Options.Targeting&targeting.TargetGlobal > 0 {
+		views = append(views, View{Name: "@", Targets: []string{"@"}})
+	}
+
+	seen := map[string]bool{}
+	for k := range z.Labels {
+		_, t := labelTarget(k)
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		if targets := z.viewTargets(t); targets != nil && t != "@" {
+			views = append(views, View{Name: t, Targets: targets})
+		}
+	}
+
+	// "@" sorts before the target names
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
+// viewNames returns the names in the zone with the targets removed.
+func (z *Zone) viewNames() []string {
+	names := map[string]bool{}
+	for k := range z.Labels {
+		name, t := labelTarget(k)
+		if z.viewTargets(t) == nil {
+			name = k
+		}
+		names[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	// the apex first, then each name followed by the names below it
+	reversed := func(s string) string {
+		l := strings.Split(s, ".")
+		slices.Reverse(l)
+		return strings.Join(l, ".")
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return reversed(sorted[i]) < reversed(sorted[j])
+	})
+	return sorted
+}
+
+// recordTypes returns the record types used in the zone, SOA and NS first.
+func (z *Zone) recordTypes() []uint16 {
+	types := []uint16{}
+	for _, label := range z.Labels {
+		for qtype := range label.Records {
+			if qtype != dns.TypeMF && !slices.Contains(types, qtype) {
+				types = append(types, qtype)
+			}
+		}
+	}
+	order := func(t uint16) int {
+		switch t {
+		case dns.TypeSOA:
+			return -2
+		case dns.TypeNS:
+			return -1
+		}
+		return int(t)
+	}
+	sort.Slice(types, func(i, j int) bool { return order(types[i]) < order(types[j]) })
+	return types
+}
+
+// View returns the view for a target name, "@" for the global view.
+func (z *Zone) View(name string) (View, bool) {
+	targets := z.viewTargets(name)
+	if targets == nil {
+		return View{}, false
+	}
+	return View{Name: name, Targets: targets}, true
+}
+
+// viewRRsets calls fn for each RRset in the zone as it resolves for
+// the view. owner is the fqdn of name; the records come from label.
+func (z *Zone) viewRRsets(view View, fn func(owner, name string, label *Label, qtype uint16) error) error {
+	origin := dnsutil.Fqdn(z.Origin)
+	types := z.recordTypes()
+
+	for _, name := range z.viewNames() {
+		owner := origin
+		if len(name) > 0 {
+			owner = name + "." + origin
+		}
+
+		type rrset struct {
+			label *Label
+			qtype uint16
+		}
+		seen := map[rrset]bool{}
+
+		for _, qtype := range types {
+			for _, match := range z.FindLabels(name, view.Targets, []uint16{dns.TypeMF, dns.TypeCNAME, qtype}) {
+				if match.Type == 0 || len(match.Label.Records[match.Type]) == 0 {
+					continue
+				}
+				set := rrset{match.Label, match.Type}
+				if !seen[set] {
+					seen[set] = true
+					if err := fn(owner, name, match.Label, match.Type); err != nil {
+						return err
+					}
+				}
+				// only the first match is used, like when answering queries
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ViewRRs returns all the records in the zone as it resolves for the
+// view, starting with the SOA record.
+func (z *Zone) ViewRRs(view View) []dns.RR {
+	rrs := []dns.RR{}
+	_ = z.viewRRsets(view, func(owner, name string, label *Label, qtype uint16) error {
+		for _, record := range label.Records[qtype] {
+			rr := record.RR.Clone()
+			rr.Header().Name = owner
+			rrs = append(rrs, rr)
+		}
+		return nil
+	})
+	return rrs
+}
+
+// WriteView writes the zone as it resolves for the view as a RFC 1035
+// zone file. All the records for each name are included; weights, health
+// checks and the other options are added as comments.
+func (z *Zone) WriteView(w io.Writer, view View) error {
+	if _, err := fmt.Fprintf(w, "; %s view %s (targets: %s)\n; max_hosts=%d\n$ORIGIN %s\n",
+		z.Origin, view.Name, strings.Join(view.Targets, " "), z.Options.MaxHosts, dnsutil.Fqdn(z.Origin)); err != nil {
+		return err
+	}
+
+	return z.viewRRsets(view, func(owner, name string, label *Label, qtype uint16) error {
+		return z.writeRecords(w, owner, name, label, qtype)
+	})
+}
+
+func (z *Zone) writeRecords(w io.Writer, owner, name string, label *Label, qtype uint16) error {
+	options := []string{}
+	if label.Label != name {
+		options = append(options, "from "+quoteLabel(label.Label))
+	}
+	if label.MaxHosts != z.Options.MaxHosts {
+		options = append(options, fmt.Sprintf("max_hosts=%d", label.MaxHosts))
+	}
+	if label.Closest {
+		options = append(options, "closest")
+	}
+	if !label.Schedule.IsActive() {
+		options = append(options, "inactive")
+	}
+	if len(options) > 0 {
+		if _, err := fmt.Fprintf(w, "; %s %s\n", dnsutil.TypeToString(qtype), strings.Join(options, " ")); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range label.Records[qtype] {
+		rr := record.RR.Clone()
+		rr.Header().Name = owner
+
+		comments := []string{}
+		if label.Weight[qtype] > 0 || record.Ramp != nil {
+			comments = append(comments, fmt.Sprintf("weight=%d", record.WeightAt(time.Now())))
+		}
+		if record.Ramp != nil {
+			comments = append(comments, fmt.Sprintf("ramp=%q", record.Ramp))
+		}
+		switch {
+		case len(record.Test) > 0:
+			comments = append(comments, "health="+record.Test)
+		case label.Test != nil:
+			comments = append(comments, "health="+label.Test.String())
+		}
+		if !record.Schedule.IsActive() {
+			comments = append(comments, "inactive")
+		}
+
+		line := rr.String()
+		if len(comments) > 0 {
+			line += "\t; " + strings.Join(comments, " ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
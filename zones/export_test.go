@@ -0,0 +1,40 @@
+package zones
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportViews(t *testing.T) {
+	zone := NewZone("test.example.com")
+	require.NoError(t, zone.ReadZoneFile("../dns/test.example.com.json"))
+
+	views := map[string][]string{}
+	for _, v := range zone.Views() {
+		views[v.Name] = v.Targets
+	}
+	assert.Equal(t, []string{"@"}, views["@"])
+	assert.Equal(t, []string{"europe", "@"}, views["europe"])
+	assert.Equal(t, []string{"se", "europe", "@"}, views["se"])
+	assert.Equal(t, []string{"as15169", "@"}, views["as15169"])
+	assert.NotContains(t, views, "www", "labels aren't targets")
+
+	var b strings.Builder
+	require.NoError(t, zone.WriteView(&b, View{Name: "europe", Targets: views["europe"]}))
+	europe := b.String()
+	t.Log(europe)
+
+	assert.Contains(t, europe, "$ORIGIN test.example.com.\n")
+	assert.Contains(t, europe, "; MX from 'europe'\ntest.example.com.\t600\tIN\tMX\t0 mx-eu.example.net.\n")
+	assert.Contains(t, europe, "0-alias.test.example.com.\t600\tIN\tA\t192.168.0.1\t; weight=10\n", "aliases are flattened")
+	assert.Contains(t, europe, "; A from '*.wild.europe'\n*.wild.test.example.com.")
+
+	b.Reset()
+	require.NoError(t, zone.WriteView(&b, View{Name: "@", Targets: views["@"]}))
+	global := b.String()
+	assert.NotContains(t, global, "mx-eu.example.net")
+	assert.Contains(t, global, "MX\t10 mx.example.net.\t; weight=1\n")
+}
@@ -0,0 +1,311 @@
+package zones
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	historyAttempts = 20 // load attempts kept per zone
+	historyVersions = 5  // good versions kept in memory for rolling back
+)
+
+// LoadAttempt is a record of a zone file being read.
+type LoadAttempt struct {
+	Time     time.Time `json:"time"`
+	FileName string    `json:"file"`
+	Hash     string    `json:"hash"`
+	Serial   uint32    `json:"serial,omitempty"`
+	Result   string    `json:"result"` // loaded, failed, pinned, skipped, rollback or unpinned
+	Error    string    `json:"error,omitempty"`
+}
+
+// ZoneHistory is what's known about loading a zone.
+type ZoneHistory struct {
+	Zone        string        `json:"zone"`
+	Live        string        `json:"live,omitempty"` // hash of the version being served
+	Pinned      bool          `json:"pinned"`
+	LastSuccess time.Time     `json:"last_success,omitzero"`
+	Failures    int           `json:"failures"`
+	Versions    []LoadAttempt `json:"versions"` // available for Pin, newest first
+	Attempts    []LoadAttempt `json:"attempts"` // newest first
+}
+
+type zoneHistory struct {
+	attempts    []LoadAttempt
+	versions    []*zoneVersion
+	live        string
+	pinned      bool
+	lastSuccess time.Time
+	failures    int
+}
+
+// zoneVersion is a zone file that loaded, kept so it can be used again.
+type zoneVersion struct {
+	LoadAttempt
+	zf   ZoneFile
+	data []byte
+}
+
+func (h *zoneHistory) add(a LoadAttempt) {
+	h.attempts = append(h.attempts, a)
+	if len(h.attempts) > historyAttempts {
+		h.attempts = slices.Delete(h.attempts, 0, len(h.attempts)-historyAttempts)
+	}
+}
+
+func (h *zoneHistory) addVersion(a LoadAttempt, zf ZoneFile, data []byte) {
+	h.versions = slices.DeleteFunc(h.versions, func(v *zoneVersion) bool { return v.Hash == a.Hash })
+	h.versions = append(h.versions, &zoneVersion{LoadAttempt: a, zf: zf, data: data})
+	if len(h.versions) > historyVersions {
+		h.versions = slices.Delete(h.versions, 0, len(h.versions)-historyVersions)
+	}
+}
+
+// version finds a kept version by its hash, or a unique prefix of it.
+func (h *zoneHistory) version(hash string) (*zoneVersion, error) {
+	var found *zoneVersion
+	for _, v := range h.versions {
+		if v.Hash == hash {
+			return v, nil
+		}
+		if len(hash) > 0 && strings.HasPrefix(v.Hash, hash) {
+			if found != nil {
+				return nil, fmt.Errorf("version '%s' is ambiguous", hash)
+			}
+			found = v
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("version '%s' isn't available", hash)
+	}
+	return found, nil
+}
+
+// history returns the history for the zone, creating it if needed. The
+// caller must hold historyMu.
+func (mm *MuxManager) history(name string) *zoneHistory {
+	h, ok := mm.histories[name]
+	if !ok {
+		h = &zoneHistory{}
+		mm.histories[name] = h
+	}
+	return h
+}
+
+// loaded records the result of reading a zone file, and starts serving
+// the zone if it loaded and isn't pinned to another version.
+func (mm *MuxManager) loaded(zf ZoneFile, data []byte, hash string, zone *Zone, err error) {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+
+	h := mm.history(zf.Name)
+	a := LoadAttempt{Time: time.Now(), FileName: zf.FileName, Hash: hash}
+	if err != nil {
+		a.Result = "failed"
+		a.Error = err.Error()
+		h.failures++
+		h.add(a)
+		return
+	}
+
+	mm.applySerialPolicy(zone, hash)
+	a.Serial = uint32(zone.Options.Serial)
+	if h.loadedVersion(a, zf, data) {
+		mm.addHandler(zf.Name, zone)
+	}
+}
+
+// loadedVersion records a zone file that loaded, and returns false if the
+// zone is pinned so the new version shouldn't be used.
+func (h *zoneHistory) loadedVersion(a LoadAttempt, zf ZoneFile, data []byte) bool {
+	a.Result = "loaded"
+	h.lastSuccess = a.Time
+	h.addVersion(a, zf, data)
+	if h.pinned {
+		log.Printf("Zone '%s' is pinned, not using %s", zf.Name, zf.FileName)
+		a.Result = "pinned"
+		h.add(a)
+		return false
+	}
+	h.add(a)
+	h.live = a.Hash
+	return true
+}
+
+// skipped records zones that loaded but weren't used because other
+// zones in the same atomic update failed.
+func (mm *MuxManager) skipped(zf ZoneFile, hash string, reason string) {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+	mm.history(zf.Name).add(LoadAttempt{
+		Time:     time.Now(),
+		FileName: zf.FileName,
+		Hash:     hash,
+		Result:   "skipped",
+		Error:    reason,
+	})
+}
+
+func (mm *MuxManager) removeHistory(name string) {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+	delete(mm.histories, name)
+}
+
+// Pin keeps serving a version of the zone, ignoring changes to the zone
+// file until Unpin is called. The version is the hash (or a prefix of it)
+// of one of the versions in the history; if it's empty the zone is pinned
+// to the version being served.
+func (mm *MuxManager) Pin(name, hash string) error {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+
+	h, ok := mm.histories[name]
+	if !ok || len(h.live) == 0 {
+		return fmt.Errorf("zone '%s' isn't loaded", name)
+	}
+	if len(hash) == 0 {
+		hash = h.live
+	}
+	v, err := h.version(hash)
+	if err != nil {
+		return fmt.Errorf("zone '%s': %s", name, err)
+	}
+
+	if v.Hash != h.live {
+		if err := mm.useVersion(name, h, v, "rollback"); err != nil {
+			return err
+		}
+	}
+	h.pinned = true
+	log.Printf("Zone '%s' pinned to %s", name, v.Hash)
+	return nil
+}
+
+// Unpin switches the zone back to the newest version that loaded.
+func (mm *MuxManager) Unpin(name string) error {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+
+	h, ok := mm.histories[name]
+	if !ok || !h.pinned {
+		return fmt.Errorf("zone '%s' isn't pinned", name)
+	}
+	h.pinned = false
+	log.Printf("Zone '%s' unpinned", name)
+
+	v := h.versions[len(h.versions)-1]
+	if v.Hash == h.live {
+		return nil
+	}
+	return mm.useVersion(name, h, v, "unpinned")
+}
+
+func (mm *MuxManager) useVersion(name string, h *zoneHistory, v *zoneVersion, result string) error {
+	zone, err := mm.newZone(v.zf, v.data)
+	if err != nil {
+		return fmt.Errorf("zone '%s': %s", name, err)
+	}
+	// a serial policy gives the old version a new serial, so secondaries
+	// transfer it again
+	mm.applySerialPolicy(zone, v.Hash)
+	log.Printf("Zone '%s' using version %s from %s", name, v.Hash, v.Time.Format(time.RFC3339))
+	h.live = v.Hash
+	h.add(LoadAttempt{
+		Time:     time.Now(),
+		FileName: v.FileName,
+		Hash:     v.Hash,
+		Serial:   uint32(zone.Options.Serial),
+		Result:   result,
+	})
+	mm.addHandler(name, zone)
+	if err := mm.serials.Save(); err != nil {
+		log.Println(err.Error())
+	}
+	return nil
+}
+
+// History returns the load history for the zones read from the sources,
+// sorted by name.
+func (mm *MuxManager) History() []ZoneHistory {
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+
+	r := make([]ZoneHistory, 0, len(mm.histories))
+	for name, h := range mm.histories {
+		zh := ZoneHistory{
+			Zone:        name,
+			Live:        h.live,
+			Pinned:      h.pinned,
+			LastSuccess: h.lastSuccess,
+			Failures:    h.failures,
+			Attempts:    slices.Clone(h.attempts),
+		}
+		slices.Reverse(zh.Attempts)
+		for i := len(h.versions) - 1; i >= 0; i-- {
+			zh.Versions = append(zh.Versions, h.versions[i].LoadAttempt)
+		}
+		r = append(r, zh)
+	}
+	slices.SortFunc(r, func(a, b ZoneHistory) int { return strings.Compare(a.Zone, b.Zone) })
+	return r
+}
+
+var (
+	loadSuccessDesc = prometheus.NewDesc(
+		"dns_zone_load_success_timestamp_seconds",
+		"When the zone file was last loaded without errors",
+		[]string{"zone"}, nil,
+	)
+	loadFailuresDesc = prometheus.NewDesc(
+		"dns_zone_load_failures_total",
+		"Number of times the zone file couldn't be loaded",
+		[]string{"zone"}, nil,
+	)
+	pinnedDesc = prometheus.NewDesc(
+		"dns_zone_pinned",
+		"1 if the zone is pinned to a version",
+		[]string{"zone"}, nil,
+	)
+)
+
+type historyCollector struct {
+	mm *MuxManager
+}
+
+// HistoryMetrics returns a Prometheus collector with the zone load
+// history metrics.
+func (mm *MuxManager) HistoryMetrics() prometheus.Collector {
+	return &historyCollector{mm: mm}
+}
+
+func (c *historyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- loadSuccessDesc
+	ch <- loadFailuresDesc
+	ch <- pinnedDesc
+}
+
+func (c *historyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mm.historyMu.Lock()
+	defer c.mm.historyMu.Unlock()
+
+	for name, h := range c.mm.histories {
+		if !h.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(loadSuccessDesc, prometheus.GaugeValue,
+				float64(h.lastSuccess.UnixNano())/1e9, name)
+		}
+		ch <- prometheus.MustNewConstMetric(loadFailuresDesc, prometheus.CounterValue,
+			float64(h.failures), name)
+		pinned := 0.0
+		if h.pinned {
+			pinned = 1
+		}
+		ch <- prometheus.MustNewConstMetric(pinnedDesc, prometheus.GaugeValue, pinned, name)
+	}
+}
@@ -0,0 +1,106 @@
+package zones
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "example.com.json")
+	version := 0
+	write := func(data string) {
+		// a new size so the change is noticed without waiting for the mtime
+		version++
+		data += strings.Repeat(" ", version)
+		require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+	}
+	write(testZoneJSON("192.0.2.1"))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManager(dir, reg)
+	require.NoError(t, err)
+
+	www := func() string {
+		return reg.get("example.com").Labels["www"].Records[dns.TypeA][0].RR.String()
+	}
+	history := func() ZoneHistory {
+		for _, h := range mm.History() {
+			if h.Zone == "example.com" {
+				return h
+			}
+		}
+		t.Fatal("no history for example.com")
+		return ZoneHistory{}
+	}
+	reload := func() {
+		t.Helper()
+		mm.reload(context.Background())
+	}
+
+	h := history()
+	require.Len(t, h.Attempts, 1)
+	assert.Equal(t, "loaded", h.Attempts[0].Result)
+	first := h.Live
+	assert.NotEmpty(t, first)
+
+	write(`{ "data": { "www": { "a": [ "broken" ] } }`)
+	reload()
+	h = history()
+	assert.Equal(t, first, h.Live, "the last good version is kept")
+	assert.Equal(t, 1, h.Failures)
+	assert.Equal(t, "failed", h.Attempts[0].Result)
+	assert.NotEmpty(t, h.Attempts[0].Error)
+	assert.Contains(t, www(), "192.0.2.1")
+
+	write(testZoneJSON("192.0.2.2"))
+	reload()
+	h = history()
+	second := h.Live
+	assert.NotEqual(t, first, second)
+	assert.Len(t, h.Versions, 2)
+	assert.Contains(t, www(), "192.0.2.2")
+
+	// roll back and pin to the first version
+	assert.Error(t, mm.Pin("example.com", "nope"))
+	assert.Error(t, mm.Pin("example.org", ""))
+	require.NoError(t, mm.Pin("example.com", first[:12]))
+	assert.Contains(t, www(), "192.0.2.1")
+	h = history()
+	assert.True(t, h.Pinned)
+	assert.Equal(t, "rollback", h.Attempts[0].Result)
+
+	write(testZoneJSON("192.0.2.3"))
+	reload()
+	h = history()
+	assert.Equal(t, "pinned", h.Attempts[0].Result)
+	assert.Equal(t, first, h.Live)
+	assert.Contains(t, www(), "192.0.2.1")
+
+	require.NoError(t, mm.Unpin("example.com"))
+	assert.Contains(t, www(), "192.0.2.3")
+	assert.False(t, history().Pinned)
+	assert.Error(t, mm.Unpin("example.com"))
+
+	assert.NoError(t, testutil.CollectAndCompare(mm.HistoryMetrics(), strings.NewReader(`
+# HELP dns_zone_load_failures_total Number of times the zone file couldn't be loaded
+# TYPE dns_zone_load_failures_total counter
+dns_zone_load_failures_total{zone="example.com"} 1
+# HELP dns_zone_pinned 1 if the zone is pinned to a version
+# TYPE dns_zone_pinned gauge
+dns_zone_pinned{zone="example.com"} 0
+`), "dns_zone_load_failures_total", "dns_zone_pinned"))
+
+	require.NoError(t, os.Remove(fileName))
+	reload()
+	assert.Empty(t, mm.History())
+}
@@ -0,0 +1,227 @@
+package zones
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Zone files changed with dynamic updates and the HTTP API are edited in
+// place: only the members of the JSON objects that changed are replaced,
+// so the order of the keys and the formatting of the rest of the file are
+// kept.
+
+// jsonMember is a member of a JSON object, with the offsets of the key
+// and the value in the file.
+type jsonMember struct {
+	key        string
+	start      int
+	valueStart int
+	valueEnd   int
+}
+
+// jsonObject reads the members of the object at data[start] and returns
+// them with the offset after the object.
+func jsonObject(data []byte, start int) ([]jsonMember, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data[start:]))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, 0, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, 0, fmt.Errorf("expected an object at offset %d", start)
+	}
+
+	var members []jsonMember
+	for dec.More() {
+		prev := start + int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("expected a key at offset %d", prev)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, 0, err
+		}
+		m := jsonMember{
+			key:      key,
+			start:    prev + bytes.IndexByte(data[prev:], '"'),
+			valueEnd: start + int(dec.InputOffset()),
+		}
+		m.valueStart = m.valueEnd - len(value)
+		members = append(members, m)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, 0, err
+	}
+	return members, start + int(dec.InputOffset()), nil
+}
+
+// editJSON changes the JSON object at data[start] from before to after.
+// Objects in both are changed member by member, other values that changed
+// are replaced. New members are added after the others.
+func editJSON(data []byte, start int, before, after map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(after))
+	for k := range after {
+		keys = append(keys, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		if inBefore && inAfter && sameJSON(a, b) {
+			continue
+		}
+
+		members, end, err := jsonObject(data, start)
+		if err != nil {
+			return nil, err
+		}
+		i := -1
+		for j, m := range members {
+			if m.key == k {
+				i = j
+			}
+		}
+
+		switch {
+		case !inAfter:
+			if i >= 0 {
+				data = deleteJSONMember(data, start, end, members, i)
+			}
+		case i < 0:
+			data, err = insertJSONMember(data, start, end, members, k, a)
+		default:
+			bm, bok := b.(map[string]interface{})
+			am, aok := a.(map[string]interface{})
+			if bok && aok && data[members[i].valueStart] == '{' {
+				data, err = editJSON(data, members[i].valueStart, bm, am)
+				break
+			}
+			m := members[i]
+			var value []byte
+			value, err = marshalJSONValue(a, indentAt(data, m.start), indentUnit(data, start, members),
+				bytes.IndexByte(data[m.valueStart:m.valueEnd], '\n') >= 0)
+			if err == nil {
+				data = spliceJSON(data, m.valueStart, m.valueEnd, value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// sameJSON checks if a and b are the same in JSON, so numbers of any
+// type are the same as the float64 values read from a file.
+func sameJSON(a, b interface{}) bool {
+	aj, aerr := json.Marshal(a)
+	bj, berr := json.Marshal(b)
+	return aerr == nil && berr == nil && bytes.Equal(aj, bj)
+}
+
+func deleteJSONMember(data []byte, start, end int, members []jsonMember, i int) []byte {
+	switch {
+	case len(members) == 1:
+		return spliceJSON(data, start+1, end-1, nil)
+	case i < len(members)-1:
+		return spliceJSON(data, members[i].start, members[i+1].start, nil)
+	default:
+		return spliceJSON(data, members[i-1].valueEnd, members[i].valueEnd, nil)
+	}
+}
+
+func insertJSONMember(data []byte, start, end int, members []jsonMember, key string, value interface{}) ([]byte, error) {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	unit := indentUnit(data, start, members)
+	if len(members) == 0 {
+		parent := indentAt(data, start)
+		indent := parent + unit
+		v, err := marshalJSONValue(value, indent, unit, true)
+		if err != nil {
+			return nil, err
+		}
+		text := fmt.Sprintf("{\n%s%s: %s\n%s}", indent, k, v, parent)
+		return spliceJSON(data, start, end, []byte(text)), nil
+	}
+
+	last := members[len(members)-1]
+	multiline := bytes.IndexByte(data[start:members[0].start], '\n') >= 0
+	var text string
+	if multiline {
+		indent := indentAt(data, last.start)
+		v, err := marshalJSONValue(value, indent, unit, true)
+		if err != nil {
+			return nil, err
+		}
+		text = fmt.Sprintf(",\n%s%s: %s", indent, k, v)
+	} else {
+		v, err := marshalJSONValue(value, "", "", false)
+		if err != nil {
+			return nil, err
+		}
+		text = fmt.Sprintf(", %s: %s", k, v)
+	}
+	return spliceJSON(data, last.valueEnd, last.valueEnd, []byte(text)), nil
+}
+
+// marshalJSONValue formats a value, indented for a member with the indent
+// prefix if multiline is set.
+func marshalJSONValue(v interface{}, prefix, unit string, multiline bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if multiline {
+		enc.SetIndent(prefix, unit)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func spliceJSON(data []byte, from, to int, text []byte) []byte {
+	r := make([]byte, 0, len(data)-(to-from)+len(text))
+	r = append(r, data[:from]...)
+	r = append(r, text...)
+	return append(r, data[to:]...)
+}
+
+// indentAt returns the indentation of the line with the offset i.
+func indentAt(data []byte, i int) string {
+	lineStart := bytes.LastIndexByte(data[:i], '\n') + 1
+	j := lineStart
+	for j < i && (data[j] == ' ' || data[j] == '\t') {
+		j++
+	}
+	return string(data[lineStart:j])
+}
+
+// indentUnit guesses the indentation used in the file from the members
+// of the object at data[start], two spaces if it can't tell.
+func indentUnit(data []byte, start int, members []jsonMember) string {
+	parent := indentAt(data, start)
+	for _, m := range members {
+		indent := indentAt(data, m.start)
+		if len(indent) > len(parent) && indent[:len(parent)] == parent &&
+			bytes.IndexByte(data[start:m.start], '\n') >= 0 {
+			return indent[len(parent):]
+		}
+	}
+	return "  "
+}
@@ -0,0 +1,738 @@
+package zones
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Zone files in RFC 1035 "master file" format are converted to the same
+// data structure as the JSON zone files, so they get the same treatment
+// in setupZoneData.
+//
+// The geodns specific settings are in $GEO directives:
+//
+//	$GEO OPTIONS max_hosts=2 targeting="country continent @"
+//	$GEO VIEW europe          ; following records are for the europe target
+//	$GEO VIEW @               ; back to the global records
+//	$GEO NETWORKS internal 10.0.0.0/8 ecs=true resolvers=192.0.2.53 ; source networks for a view
+//	$GEO LABEL www max_hosts=1 closest=true health.type=tcp
+//	$GEO ALIAS www-alias www
+//
+// and record options are in a comment on the record line:
+//
+//	www  IN A 192.0.2.1  ; geo: weight=10 health=www-tcp
+//	www  IN A 192.0.2.9  ; geo: active_during="0 2 * * sun 2h"
+//	www  IN A 192.0.2.10 ; geo: ramp="0 100 2026-11-01T00:00:00Z 6h"
+
+// ttlSentinel is used as the default TTL when parsing a record to tell if
+// the record had an explicit TTL.
+const ttlSentinel = 1<<31 - 1
+
+type masterFileReader struct {
+	fileName string
+	zone     string // the zone origin, fqdn
+	origin   string // current $ORIGIN
+	ttl      uint32 // current $TTL
+	owner    string // owner name of the previous record
+	view     string
+	line     int // line being parsed
+
+	// positions of the paths in the zone data
+	positions map[string]position
+
+	// TTL of the first record for each label
+	ttls map[string]uint32
+
+	objmap map[string]interface{}
+	data   map[string]interface{}
+}
+
+type masterFileLine struct {
+	line     int
+	text     string
+	comments []string
+}
+
+// readMasterFile reads a zone in master file format and returns it in the
+// structure used by the JSON zone files, with the line numbers for the
+// paths in it. Errors in the file are returned as ZoneErrors; the lines
+// with errors are skipped.
+func readMasterFile(r io.Reader, origin, fileName string) (map[string]interface{}, map[string]position, error) {
+	mr := newMasterFileReader(origin, fileName)
+
+	lines, err := mr.readLines(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	problems := ZoneErrors{}
+	for _, l := range lines {
+		mr.line = l.line
+		if err := mr.parseLine(l); err != nil {
+			problems = append(problems, &ZoneProblem{
+				File:     fileName,
+				Line:     l.line,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return mr.objmap, mr.positions, problems
+	}
+
+	return mr.objmap, mr.positions, nil
+}
+
+func newMasterFileReader(origin, fileName string) *masterFileReader {
+	origin = strings.ToLower(dnsutil.Fqdn(origin))
+
+	mr := &masterFileReader{
+		fileName: fileName,
+		zone:     origin,
+		origin:   origin,
+		ttls:     map[string]uint32{},
+		objmap:   map[string]interface{}{},
+		data:     map[string]interface{}{},
+
+		positions: map[string]position{},
+	}
+	mr.objmap["data"] = mr.data
+	return mr
+}
+
+// mark records the current line as the position of path.
+func (mr *masterFileReader) mark(path string) {
+	if _, ok := mr.positions[path]; !ok {
+		mr.positions[path] = position{line: mr.line}
+	}
+}
+
+func (mr *masterFileReader) lineError(line int, msg string) error {
+	return ZoneErrors{{File: mr.fileName, Line: line, Severity: SeverityError, Message: msg}}
+}
+
+// readLines splits the file into logical lines; joining lines in
+// parentheses and separating the comments from the rest of the line.
+func (mr *masterFileReader) readLines(r io.Reader) ([]masterFileLine, error) {
+	lines := []masterFileLine{}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	var current *masterFileLine
+	depth := 0
+
+	for scanner.Scan() {
+		lineNo++
+		if current == nil {
+			current = &masterFileLine{line: lineNo}
+		}
+
+		var text strings.Builder
+		inQuote, escaped := false, false
+
+		raw := scanner.Text()
+	chars:
+		for i, c := range raw {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inQuote = !inQuote
+			case inQuote:
+			case c == ';':
+				current.comments = append(current.comments, strings.TrimSpace(raw[i+1:]))
+				break chars
+			case c == '(':
+				depth++
+				text.WriteByte(' ')
+				continue
+			case c == ')':
+				depth--
+				if depth < 0 {
+					return nil, mr.lineError(lineNo, "unbalanced parentheses")
+				}
+				text.WriteByte(' ')
+				continue
+			}
+			text.WriteRune(c)
+		}
+		if inQuote {
+			return nil, mr.lineError(lineNo, "unterminated quote")
+		}
+
+		if len(current.text) > 0 {
+			current.text += " " + strings.TrimSpace(text.String())
+		} else {
+			current.text = strings.TrimRight(text.String(), " \t")
+		}
+
+		if depth > 0 {
+			continue
+		}
+		lines = append(lines, *current)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		return nil, mr.lineError(current.line, "unbalanced parentheses")
+	}
+
+	return lines, nil
+}
+
+func (mr *masterFileReader) parseLine(l masterFileLine) error {
+	if len(strings.TrimSpace(l.text)) == 0 {
+		return nil
+	}
+
+	if strings.HasPrefix(l.text, "$") {
+		fields, err := splitFields(l.text)
+		if err != nil {
+			return err
+		}
+		return mr.directive(fields)
+	}
+
+	return mr.record(l)
+}
+
+func (mr *masterFileReader) directive(fields []string) error {
+	args := fields[1:]
+
+	switch strings.ToUpper(fields[0]) {
+	case "$ORIGIN":
+		if len(args) != 1 {
+			return fmt.Errorf("$ORIGIN requires a domain name")
+		}
+		mr.origin = mr.absName(args[0])
+
+	case "$TTL":
+		if len(args) != 1 {
+			return fmt.Errorf("$TTL requires a TTL value")
+		}
+		ttl, err := parseTTL(args[0])
+		if err != nil {
+			return err
+		}
+		mr.ttl = ttl
+		if _, ok := mr.objmap["ttl"]; !ok {
+			mr.objmap["ttl"] = float64(ttl)
+			mr.mark("ttl")
+		}
+
+	case "$GEO":
+		if len(args) == 0 {
+			return fmt.Errorf("$GEO requires OPTIONS, VIEW, NETWORKS, LABEL or ALIAS")
+		}
+		return mr.geoDirective(strings.ToUpper(args[0]), args[1:])
+
+	default:
+		return fmt.Errorf("unsupported directive %s", fields[0])
+	}
+
+	return nil
+}
+
+func (mr *masterFileReader) geoDirective(cmd string, args []string) error {
+	switch cmd {
+	case "OPTIONS":
+		options, err := parseGeoOptions(args)
+		if err != nil {
+			return err
+		}
+		for k, v := range options {
+			mr.mark(pathKey("", k))
+			switch k {
+			case "serial", "ttl", "max_hosts", "soa_ttl", "refresh", "retry", "expire", "negative_ttl":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s'", k, v)
+				}
+				mr.objmap[k] = float64(n)
+			case "closest":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s'", k, v)
+				}
+				mr.objmap[k] = b
+			case "contact", "primary_ns", "targeting", "also_notify", "serial_policy":
+				mr.objmap[k] = v
+			default:
+				return fmt.Errorf("unknown zone option '%s'", k)
+			}
+		}
+
+	case "VIEW":
+		if len(args) != 1 {
+			return fmt.Errorf("$GEO VIEW requires one targeting name")
+		}
+		mr.view = strings.ToLower(args[0])
+		if mr.view == "@" {
+			mr.view = ""
+		}
+
+	case "NETWORKS":
+		if len(args) < 2 {
+			return fmt.Errorf("$GEO NETWORKS requires a view name and networks")
+		}
+		views, ok := mr.objmap["views"].(map[string]interface{})
+		if !ok {
+			views = map[string]interface{}{}
+			mr.objmap["views"] = views
+		}
+		name := strings.ToLower(args[0])
+		view, ok := views[name].(map[string]interface{})
+		if !ok {
+			view = map[string]interface{}{"networks": []interface{}{}}
+			views[name] = view
+		}
+		path := pathKey(pathKey("views", name), "networks")
+		mr.mark(path)
+		for _, f := range args[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				networks := view["networks"].([]interface{})
+				mr.mark(pathIndex(path, len(networks)))
+				view["networks"] = append(networks, f)
+				continue
+			}
+			switch strings.ToLower(k) {
+			case "ecs":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s'", k, v)
+				}
+				view["ecs"] = b
+			case "resolvers":
+				resolvers := []interface{}{}
+				for _, r := range strings.Split(v, ",") {
+					resolvers = append(resolvers, r)
+				}
+				view["resolvers"] = resolvers
+			default:
+				return fmt.Errorf("unknown view option '%s'", k)
+			}
+		}
+
+	case "LABEL":
+		if len(args) < 1 {
+			return fmt.Errorf("$GEO LABEL requires a name")
+		}
+		label, err := mr.labelName(mr.absName(args[0]))
+		if err != nil {
+			return err
+		}
+		options, err := parseGeoOptions(args[1:])
+		if err != nil {
+			return err
+		}
+		l := mr.label(label)
+		for k, v := range options {
+			mr.mark(pathKey(mr.labelPath(label), strings.SplitN(k, ".", 2)[0]))
+			switch {
+			case k == "max_hosts" || k == "ttl":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s'", k, v)
+				}
+				l[k] = float64(n)
+			case k == "closest":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s'", k, v)
+				}
+				l[k] = b
+			case strings.HasPrefix(k, "health."):
+				h, ok := l["health"].(map[string]interface{})
+				if !ok {
+					h = map[string]interface{}{}
+					l["health"] = h
+				}
+				h[strings.TrimPrefix(k, "health.")] = v
+			case slices.Contains(scheduleKeys, k):
+				l[k] = v
+			default:
+				return fmt.Errorf("unknown label option '%s'", k)
+			}
+		}
+
+	case "ALIAS":
+		if len(args) != 2 {
+			return fmt.Errorf("$GEO ALIAS requires a name and a target")
+		}
+		label, err := mr.labelName(mr.absName(args[0]))
+		if err != nil {
+			return err
+		}
+		target := args[1]
+		if target == "@" {
+			target = ""
+		}
+		mr.label(label)["alias"] = target
+		mr.mark(pathKey(mr.labelPath(label), "alias"))
+
+	default:
+		return fmt.Errorf("unknown $GEO directive '%s'", cmd)
+	}
+
+	return nil
+}
+
+func (mr *masterFileReader) record(l masterFileLine) error {
+	text := l.text
+	if text[0] == ' ' || text[0] == '\t' {
+		if len(mr.owner) == 0 {
+			return fmt.Errorf("no owner name for record")
+		}
+		text = mr.owner + " " + strings.TrimSpace(text)
+	}
+
+	input := fmt.Sprintf("$ORIGIN %s\n$TTL %d\n%s\n", mr.origin, ttlSentinel, text)
+	zp := dns.NewZoneParser(strings.NewReader(input), mr.origin, mr.fileName)
+	rr, ok := zp.Next()
+	if err := zp.Err(); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("could not parse record")
+	}
+
+	mr.owner = strings.ToLower(rr.Header().Name)
+
+	options := map[string]string{}
+	for _, comment := range l.comments {
+		if !strings.HasPrefix(comment, "geo:") {
+			continue
+		}
+		fields, err := splitFields(strings.TrimPrefix(comment, "geo:"))
+		if err != nil {
+			return err
+		}
+		opts, err := parseGeoOptions(fields)
+		if err != nil {
+			return err
+		}
+		for k, v := range opts {
+			options[k] = v
+		}
+	}
+
+	return mr.addRR(rr, options)
+}
+
+// addRR adds the record to the zone data, with the geodns options
+// (weight or ramp, health and schedule) for it.
+func (mr *masterFileReader) addRR(rr dns.RR, options map[string]string) error {
+	name := strings.ToLower(rr.Header().Name)
+	label, err := mr.labelName(name)
+	if err != nil {
+		return err
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range options {
+		switch k {
+		case "weight":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid weight '%s'", v)
+			}
+			record["weight"] = float64(n)
+		case "ramp":
+			ramp, err := parseRamp(v)
+			if err != nil {
+				return err
+			}
+			record["weight"] = ramp
+		case "health":
+			record["health"] = v
+		case "active_from", "active_until", "active_during", "inactive_during":
+			record[k] = v
+		default:
+			return fmt.Errorf("unknown record option '%s'", k)
+		}
+	}
+
+	if soa, ok := rr.(*dns.SOA); ok {
+		if len(label) > 0 {
+			return fmt.Errorf("SOA record not at the zone apex")
+		}
+		if _, ok := mr.objmap["serial"]; !ok {
+			mr.objmap["serial"] = float64(soa.Serial)
+			mr.mark("serial")
+		}
+		ttl := soa.Hdr.TTL
+		if ttl == ttlSentinel {
+			ttl = mr.ttl
+		}
+		for k, v := range map[string]interface{}{
+			"contact":      strings.TrimSuffix(soa.Mbox, "."),
+			"primary_ns":   soa.Ns,
+			"soa_ttl":      float64(ttl),
+			"refresh":      float64(soa.Refresh),
+			"retry":        float64(soa.Retry),
+			"expire":       float64(soa.Expire),
+			"negative_ttl": float64(soa.Minttl),
+		} {
+			if _, ok := mr.objmap[k]; !ok {
+				mr.objmap[k] = v
+				mr.mark(k)
+			}
+		}
+		return nil
+	}
+
+	key, value, err := recordData(rr, record)
+	if err != nil {
+		return err
+	}
+
+	// the first record for a label sets the label TTL, the records with
+	// other TTLs get their own (or the type TTL for NS records, which
+	// don't have options)
+	ttl := rr.Header().TTL
+	if ttl == ttlSentinel {
+		ttl = mr.ttl
+	}
+	labelTtl, seen := mr.ttls[mr.labelKey(label)]
+	ownTtl := seen && ttl > 0 && ttl != labelTtl
+
+	labelData, _ := mr.data[mr.labelKey(label)].(map[string]interface{})
+	records, _ := labelData[key].([]interface{})
+	typeTtls, _ := labelData["type_ttl"].(map[string]interface{})
+	record, hasOptions := value.(map[string]interface{})
+	if !hasOptions {
+		old, ok := typeTtls[key]
+		if (ok && old != float64(ttl)) || (!ok && ownTtl && len(records) > 0) {
+			return fmt.Errorf("all %s records for '%s' must have the same TTL", strings.ToUpper(key), name)
+		}
+	}
+
+	labelData = mr.label(label)
+	switch {
+	case !seen:
+		mr.ttls[mr.labelKey(label)] = ttl
+		if ttl > 0 && mr.objmap["ttl"] != float64(ttl) {
+			labelData["ttl"] = float64(ttl)
+			mr.mark(pathKey(mr.labelPath(label), "ttl"))
+		}
+	case ownTtl && hasOptions:
+		record["ttl"] = float64(ttl)
+	case ownTtl:
+		if typeTtls == nil {
+			typeTtls = map[string]interface{}{}
+			labelData["type_ttl"] = typeTtls
+		}
+		typeTtls[key] = float64(ttl)
+		mr.mark(pathKey(pathKey(mr.labelPath(label), "type_ttl"), key))
+	}
+
+	mr.mark(pathIndex(pathKey(mr.labelPath(label), key), len(records)))
+	labelData[key] = append(records, value)
+
+	return nil
+}
+
+// recordData returns the zone data key and value for the record; record
+// has the options for it, and is used for the value of most types.
+func recordData(rr dns.RR, record map[string]interface{}) (string, interface{}, error) {
+	var key string
+	var value interface{} = record
+
+	switch rr := rr.(type) {
+	case *dns.A:
+		key = "a"
+		record["ip"] = rr.Addr.String()
+	case *dns.AAAA:
+		key = "aaaa"
+		record["ip"] = rr.Addr.String()
+	case *dns.CNAME:
+		key = "cname"
+		record["cname"] = rr.Target
+	case *dns.MX:
+		key = "mx"
+		record["mx"] = rr.Mx
+		record["preference"] = float64(rr.Preference)
+	case *dns.NS:
+		key = "ns"
+		value = rr.Ns
+	case *dns.SPF:
+		key = "spf"
+		record["spf"] = txtData(rr.Txt)
+	case *dns.TXT:
+		key = "txt"
+		record["txt"] = txtData(rr.Txt)
+	case *dns.SRV:
+		key = "srv"
+		record["target"] = rr.Target
+		record["port"] = float64(rr.Port)
+		record["priority"] = float64(rr.Priority)
+		record["srv_weight"] = float64(rr.SRV.Weight)
+	case *dns.PTR:
+		key = "ptr"
+		record["ptr"] = rr.Ptr
+	default:
+		return "", nil, fmt.Errorf("unsupported record type %s", dnsutil.TypeToString(dns.RRToType(rr)))
+	}
+	return key, value, nil
+}
+
+// txtData keeps the strings of TXT and SPF records with more than one as
+// a list.
+func txtData(txt []string) interface{} {
+	if len(txt) == 1 {
+		return txt[0]
+	}
+	list := make([]interface{}, len(txt))
+	for i, t := range txt {
+		list[i] = t
+	}
+	return list
+}
+
+// label returns the label data for name in the current view, creating
+// it if needed.
+func (mr *masterFileReader) label(name string) map[string]interface{} {
+	name = mr.labelKey(name)
+	l, ok := mr.data[name].(map[string]interface{})
+	if !ok {
+		l = map[string]interface{}{}
+		mr.data[name] = l
+		mr.mark(pathKey("data", name))
+	}
+	return l
+}
+
+// labelPath returns the path to the label data in the current view.
+func (mr *masterFileReader) labelPath(name string) string {
+	return pathKey("data", mr.labelKey(name))
+}
+
+// labelKey returns the label name with the current view.
+func (mr *masterFileReader) labelKey(name string) string {
+	switch {
+	case len(mr.view) == 0:
+		return name
+	case len(name) == 0:
+		return mr.view
+	default:
+		return name + "." + mr.view
+	}
+}
+
+// labelName returns the name relative to the zone.
+func (mr *masterFileReader) labelName(name string) (string, error) {
+	if !dnsutil.IsBelow(mr.zone, name) {
+		return "", fmt.Errorf("'%s' is outside the zone '%s'", name, mr.zone)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, mr.zone), "."), nil
+}
+
+func (mr *masterFileReader) absName(name string) string {
+	switch {
+	case name == "@":
+		return mr.origin
+	case dnsutil.IsFqdn(name):
+		return strings.ToLower(name)
+	default:
+		return strings.ToLower(name + "." + mr.origin)
+	}
+}
+
+// parseTTL parses a TTL in seconds, or with BIND style units (1h30m).
+func parseTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+
+	var ttl, n uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid TTL '%s'", s)
+		}
+		switch c {
+		case 's':
+		case 'm':
+			n *= 60
+		case 'h':
+			n *= 3600
+		case 'd':
+			n *= 86400
+		case 'w':
+			n *= 604800
+		default:
+			return 0, fmt.Errorf("invalid TTL '%s'", s)
+		}
+		ttl += n
+		n, digits = 0, false
+	}
+	if digits || ttl > 1<<31-1 {
+		return 0, fmt.Errorf("invalid TTL '%s'", s)
+	}
+	return uint32(ttl), nil
+}
+
+// parseGeoOptions parses key=value fields.
+func parseGeoOptions(fields []string) (map[string]string, error) {
+	options := map[string]string{}
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || len(k) == 0 {
+			return nil, fmt.Errorf("expected key=value, got '%s'", f)
+		}
+		options[strings.ToLower(k)] = v
+	}
+	return options, nil
+}
+
+// splitFields splits s on whitespace, keeping double quoted strings
+// together (without the quotes).
+func splitFields(s string) ([]string, error) {
+	fields := []string{}
+
+	var field strings.Builder
+	inField, inQuote := false, false
+
+	for _, c := range s {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			inField = true
+		case !inQuote && (c == ' ' || c == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,139 @@
+package zones
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dns "codeberg.org/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMasterFile(t *testing.T) {
+	zone := NewZone("zonefile.example.com")
+	err := zone.ReadZoneFile("../dns/zonefile.example.com.zone")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2024010101, zone.Options.Serial)
+	assert.Equal(t, 600, zone.Options.Ttl)
+	assert.Equal(t, "dns.example.com", zone.Options.Contact)
+	soa := zone.SoaRR().(*dns.SOA)
+	assert.Equal(t, "ns1.example.net.", soa.Ns)
+	assert.Equal(t, uint32(600), soa.Hdr.TTL, "SOA TTL from $TTL")
+	assert.Equal(t, uint32(1209600), soa.Expire)
+
+	apex := zone.Labels[""]
+	require.NotNil(t, apex)
+	assert.Len(t, apex.Records[dns.TypeNS], 2)
+	require.Len(t, apex.Records[dns.TypeMX], 1)
+	assert.Equal(t, "mail.zonefile.example.com.", apex.Records[dns.TypeMX][0].RR.(*dns.MX).Mx)
+
+	www := zone.Labels["www"]
+	require.NotNil(t, www)
+	assert.Equal(t, 1, www.MaxHosts)
+	assert.True(t, www.Closest)
+	require.Len(t, www.Records[dns.TypeA], 2)
+	// sorted by weight
+	assert.Equal(t, 20, www.Records[dns.TypeA][0].Weight)
+	assert.Equal(t, "www-tcp", www.Records[dns.TypeA][0].Test)
+	assert.Equal(t, 30, www.Weight[dns.TypeA])
+	assert.Equal(t, uint32(600), www.Records[dns.TypeA][0].RR.Header().TTL)
+	assert.Len(t, www.Records[dns.TypeAAAA], 1)
+
+	assert.Equal(t, uint32(60), zone.Labels["ttl"].Records[dns.TypeA][0].RR.Header().TTL)
+	assert.Len(t, zone.Labels["_sip._udp"].Records[dns.TypeSRV], 1)
+	assert.NotNil(t, zone.Labels["hc"].Test)
+	assert.Equal(t, "www", zone.Labels["www-alias"].FirstRR(dns.TypeMF).(*dns.MF).Mf)
+
+	eu := zone.Labels["www.europe"]
+	require.NotNil(t, eu)
+	assert.Equal(t, "192.0.2.100", eu.FirstRR(dns.TypeA).(*dns.A).Addr.String())
+	assert.Len(t, zone.Labels["europe"].Records[dns.TypeMX], 1)
+
+	cname := zone.Labels["host.sub"]
+	require.NotNil(t, cname)
+	assert.Equal(t, "www.zonefile.example.com.", cname.FirstRR(dns.TypeCNAME).(*dns.CNAME).Target)
+}
+
+func TestMasterFileMatchesJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	zoneFile := `$TTL 300
+@    3600 IN NS  ns1.example.net.
+@    IN A   192.0.2.9
+foo  IN A   192.0.2.1 ; geo: weight=10
+     IN A   192.0.2.2 ; geo: weight=5
+     60 IN TXT "failover"
+sub  IN A   192.0.2.10
+     3600 IN NS ns2.example.net.
+long IN TXT "v=spf1 ip4:192.0.2.0/24 " "include:example.net ~all"
+     IN SPF "v=spf1 " "~all"
+$GEO LABEL foo max_hosts=1
+$GEO VIEW europe
+foo  IN A   192.0.2.3
+`
+	jsonFile := `{"ttl": 300, "data": {
+	"": {"ttl": 3600, "ns": ["ns1.example.net."], "a": [{"ip": "192.0.2.9", "ttl": 300}]},
+	"foo": {"max_hosts": 1, "a": [["192.0.2.1", 10], ["192.0.2.2", 5]], "txt": [{"txt": "failover", "ttl": 60}]},
+	"sub": {"a": [["192.0.2.10"]], "ns": ["ns2.example.net."], "type_ttl": {"ns": 3600}},
+	"long": {
+		"txt": [{"txt": ["v=spf1 ip4:192.0.2.0/24 ", "include:example.net ~all"]}],
+		"spf": [{"spf": ["v=spf1 ", "~all"]}]
+	},
+	"foo.europe": {"a": [["192.0.2.3"]]}
+}}`
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.zone"), []byte(zoneFile), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.json"), []byte(jsonFile), 0644))
+
+	fromZone := NewZone("match.example")
+	require.NoError(t, fromZone.ReadZoneFile(filepath.Join(dir, "a.zone")))
+	fromJSON := NewZone("match.example")
+	require.NoError(t, fromJSON.ReadZoneFile(filepath.Join(dir, "a.json")))
+
+	require.Equal(t, len(fromJSON.Labels), len(fromZone.Labels))
+	for name, jl := range fromJSON.Labels {
+		zl, ok := fromZone.Labels[name]
+		require.True(t, ok, "label '%s' in zone file", name)
+		assert.Equal(t, jl.MaxHosts, zl.MaxHosts, name)
+		assert.Equal(t, jl.Weight, zl.Weight, name)
+		for qtype, records := range jl.Records {
+			if qtype == dns.TypeSOA {
+				continue
+			}
+			require.Len(t, zl.Records[qtype], len(records), name)
+			for i := range records {
+				assert.Equal(t, records[i].RR.String(), zl.Records[qtype][i].RR.String())
+				assert.Equal(t, records[i].Weight, zl.Records[qtype][i].Weight)
+			}
+		}
+	}
+
+	// the strings of TXT records aren't joined
+	txt := fromZone.Labels["long"].FirstRR(dns.TypeTXT).(*dns.TXT)
+	assert.Equal(t, []string{"v=spf1 ip4:192.0.2.0/24 ", "include:example.net ~all"}, txt.Txt)
+}
+
+func TestMasterFileErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"www IN A 192.0.2.1 ; geo: bogus=1\n", "test.zone:1: error: unknown record option 'bogus'"},
+		{"www.example.org. IN A 192.0.2.1\n", "outside the zone"},
+		{"www IN HINFO \"a\" \"b\"\n", "unsupported record type HINFO"},
+		{"$GEO VIEW\n", "$GEO VIEW requires"},
+		{"$INCLUDE other.zone\n", "unsupported directive $INCLUDE"},
+		{"@ IN SOA ns1 dns (1 2 3 4\n", "unbalanced parentheses"},
+		{"a IN A 192.0.2.1\na IN NS ns1\na 60 IN NS ns2\n", "test.zone:3: error: all NS records for 'a.err.example.' must have the same TTL"},
+	}
+
+	for _, tc := range tests {
+		_, _, err := readMasterFile(strings.NewReader(tc.data), "err.example", "test.zone")
+		if assert.Error(t, err, tc.data) {
+			assert.Contains(t, err.Error(), tc.err)
+		}
+	}
+}
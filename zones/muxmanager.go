@@ -4,11 +4,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path"
+	"maps"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	dns "codeberg.org/miekg/dns"
@@ -20,11 +22,47 @@ type RegistrationAPI interface {
 	Remove(string)
 }
 
+// BatchRegistrationAPI is implemented by registries that can apply
+// several zone changes at once (for the zones from an AtomicSource).
+type BatchRegistrationAPI interface {
+	RegistrationAPI
+	Update([]ZoneChange)
+}
+
+// ZoneChange adds or replaces a zone, or removes it if Zone is nil.
+type ZoneChange struct {
+	Name string
+	Zone *Zone
+}
+
 type MuxManager struct {
 	reg      RegistrationAPI
 	zonelist ZoneList
-	path     string
+	sources  []ZoneSource
 	lastRead map[string]*zoneReadRecord
+
+	secondaries map[string]*secondary
+
+	// loadMu serializes reloads and dynamic updates, it guards lastRead
+	loadMu sync.Mutex
+
+	serials      *SerialState
+	serialPolicy SerialPolicy
+
+	// zonesMu guards zonelist for the alias lookups from other zones
+	// and the secondary zones being loaded
+	zonesMu sync.RWMutex
+
+	// historyMu guards histories, and is held while a zone from a file is
+	// switched so it doesn't race with Pin
+	historyMu sync.Mutex
+	histories map[string]*zoneHistory
+
+	// reverseMu serializes generating the reverse zones
+	reverseMu sync.Mutex
+	reverses  map[string]ReverseConfig
+
+	scheduleTransitions atomic.Uint64
 }
 
 type NilReg struct{}
@@ -34,37 +72,114 @@ func (r *NilReg) Remove(string)     {}
 
 // track when each zone was read last
 type zoneReadRecord struct {
-	time time.Time
-	hash string
+	version string
+	hash    string
+	source  ZoneSource
+	zf      ZoneFile
 }
 
+// NewMuxManager loads the zones in the path directory.
 func NewMuxManager(path string, reg RegistrationAPI) (*MuxManager, error) {
+	return NewMuxManagerSources(reg, &DirSource{Dir: path})
+}
+
+// NewMuxManagerSources loads the zones from the sources. If a zone is in
+// more than one source the first one is used.
+func NewMuxManagerSources(reg RegistrationAPI, sources ...ZoneSource) (*MuxManager, error) {
+	return NewMuxManagerOptions(reg, MuxOptions{Sources: sources})
+}
+
+// MuxOptions configures NewMuxManagerOptions.
+type MuxOptions struct {
+	Sources []ZoneSource
+
+	// SerialPolicy is used for the zones without a serial_policy option
+	SerialPolicy SerialPolicy
+
+	// Serials keeps the serials from the serial policies; if it's nil
+	// they are only kept in memory
+	Serials *SerialState
+
+	// Reverses has the reverse zones with PTR records generated from
+	// forward zones, by name
+	Reverses map[string]ReverseConfig
+
+	// Secondaries has the zones transferred from primary servers, by
+	// name. Zone files with the same name are skipped.
+	Secondaries map[string]SecondaryConfig
+}
+
+// NewMuxManagerOptions loads the zones from the sources in the options.
+// It only returns a nil MuxManager if the secondary zones aren't valid.
+func NewMuxManagerOptions(reg RegistrationAPI, options MuxOptions) (*MuxManager, error) {
 	mm := &MuxManager{
 		reg:      reg,
-		path:     path,
+		sources:  options.Sources,
 		zonelist: make(ZoneList),
 		lastRead: map[string]*zoneReadRecord{},
+
+		secondaries: map[string]*secondary{},
+		histories:   map[string]*zoneHistory{},
+
+		serials:      options.Serials,
+		serialPolicy: options.SerialPolicy,
+	}
+	if mm.serials == nil {
+		mm.serials, _ = NewSerialState("")
+	}
+	mm.setupReverses(options.Reverses)
+	for name, config := range options.Secondaries {
+		if err := mm.addSecondary(name, config); err != nil {
+			return nil, err
+		}
 	}
 
 	mm.setupRootZone()
 	mm.setupPgeodnsZone()
 
-	err := mm.reload()
+	err := mm.reload(context.Background())
 
 	return mm, err
 }
 
+// rescanInterval is how often all the zone sources are checked, in
+// case a change was missed.
+const rescanInterval = 5 * time.Minute
+
 func (mm *MuxManager) Run(ctx context.Context) {
-	for {
-		err := mm.reload()
-		if err != nil {
-			log.Printf("error reading zones: %s", err)
+	for _, s := range mm.secondaries {
+		go s.run(ctx)
+	}
+	go mm.runSchedules(ctx)
+
+	// the watchers call changed when they are started too, in case
+	// something changed since the zones were loaded
+	changed := make(chan struct{}, 1)
+	for _, source := range mm.sources {
+		if w, ok := source.(ZoneWatcher); ok {
+			go w.Watch(ctx, func() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
 		}
+	}
+
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+
+	for {
 		select {
-		case <-time.After(2 * time.Second):
+		case <-changed:
+		case <-ticker.C:
 		case <-ctx.Done():
 			return
 		}
+		err := mm.reload(ctx)
+		if err != nil {
+			log.Printf("error reading zones: %s", err)
+		}
 	}
 }
 
@@ -73,113 +188,340 @@ func (mm *MuxManager) Zones() ZoneList {
 	return mm.zonelist
 }
 
-func (mm *MuxManager) reload() error {
-	dir, err := os.ReadDir(mm.path)
-	if err != nil {
-		return fmt.Errorf("could not read '%s': %s", mm.path, err)
-	}
+func (mm *MuxManager) reload(ctx context.Context) error {
+	mm.loadMu.Lock()
+	defer mm.loadMu.Unlock()
 
 	seenZones := map[string]bool{}
 
-	var parseErr error
+	var errs []error
 
-	for _, file := range dir {
-		fileName := file.Name()
-		if !strings.HasSuffix(strings.ToLower(fileName), ".json") ||
-			strings.HasPrefix(path.Base(fileName), ".") ||
-			file.IsDir() {
+	for _, source := range mm.sources {
+		files, err := source.Zones(ctx)
+		if err == nil && isAtomic(source) {
+			err = mm.reloadAtomic(ctx, source, files, seenZones)
+			if err == nil {
+				continue
+			}
+			log.Println(err.Error())
+		}
+		if err != nil {
+			errs = append(errs, err)
+			// keep the zones we have from the source
+			for zoneName, r := range mm.lastRead {
+				if r.source == source {
+					seenZones[zoneName] = true
+				}
+			}
 			continue
 		}
 
-		fileInfo, err := file.Info()
-		if err != nil {
-			return err
+		for _, zf := range files {
+			if err := mm.readZone(ctx, source, zf, seenZones); err != nil {
+				errs = append(errs, err)
+			}
 		}
-		modTime := fileInfo.ModTime()
+	}
 
-		zoneName := fileName[0:strings.LastIndex(fileName, ".")]
+	mm.zonesMu.RLock()
+	zonelist := maps.Clone(mm.zonelist)
+	mm.zonesMu.RUnlock()
 
-		seenZones[zoneName] = true
+	for zoneName, zone := range zonelist {
+		if zoneName == "pgeodns" {
+			continue
+		}
+		if ok := seenZones[zoneName]; ok {
+			continue
+		}
+		if _, ok := mm.secondaries[zoneName]; ok {
+			continue
+		}
+		if _, ok := mm.reverses[zoneName]; ok && mm.lastRead[zoneName] == nil {
+			// generated without a zone file
+			continue
+		}
+		log.Println("Removing zone", zone.Origin)
+		zone.Close()
+		delete(mm.lastRead, zoneName)
+		mm.removeHandler(zoneName)
+	}
 
-		if _, ok := mm.lastRead[zoneName]; !ok || modTime.After(mm.lastRead[zoneName].time) {
-			if ok {
-				log.Printf("Reloading %s\n", fileName)
-				mm.lastRead[zoneName].time = modTime
-			} else {
-				log.Printf("Reading new file %s\n", fileName)
-				mm.lastRead[zoneName] = &zoneReadRecord{time: modTime}
-			}
+	if err := mm.serials.Save(); err != nil {
+		errs = append(errs, err)
+	}
 
-			filename := path.Join(mm.path, fileName)
-
-			// Check the sha256 of the file has not changed. It's worth an explanation of
-			// why there isn't a TOCTOU race here. Conceivably after checking whether the
-			// SHA has changed, the contents then change again before we actually load
-			// the JSON. This can occur in two situations:
-			//
-			// 1. The SHA has not changed when we read the file for the SHA, but then
-			//    changes before we process the JSON
-			//
-			// 2. The SHA has changed when we read the file for the SHA, but then changes
-			//    again before we process the JSON
-			//
-			// In circumstance (1) we won't reread the file the first time, but the subsequent
-			// change should alter the mtime again, causing us to reread it. This reflects
-			// the fact there were actually two changes.
-			//
-			// In circumstance (2) we have already reread the file once, and then when the
-			// contents are changed the mtime changes again
-			//
-			// Provided files are replaced atomically, this should be OK. If files are not
-			// replaced atomically we have other problems (e.g. partial reads).
-
-			sha256 := sha256File(filename)
-			if mm.lastRead[zoneName].hash == sha256 {
-				log.Printf("Skipping new file %s as hash is unchanged\n", filename)
-				continue
-			}
+	return errors.Join(errs...)
+}
 
-			zone := NewZone(zoneName)
-			err := zone.ReadZoneFile(filename)
-			if zone == nil || err != nil {
-				parseErr = fmt.Errorf("error reading zone '%s': %s", zoneName, err)
-				log.Println(parseErr.Error())
-				continue
+// readZone loads the zone file if it changed since it was read last.
+func (mm *MuxManager) readZone(ctx context.Context, source ZoneSource, zf ZoneFile, seenZones map[string]bool) error {
+	zoneName := zf.Name
+
+	if seenZones[zoneName] {
+		log.Printf("Skipping %s, zone '%s' is already read from another file", zf.FileName, zoneName)
+		return nil
+	}
+	seenZones[zoneName] = true
+
+	if _, ok := mm.secondaries[zoneName]; ok {
+		if _, ok := mm.lastRead[zoneName]; !ok {
+			log.Printf("Skipping %s, zone '%s' is a secondary zone", zf.FileName, zoneName)
+			mm.lastRead[zoneName] = &zoneReadRecord{version: zf.Version, source: source}
+		}
+		return nil
+	}
+
+	last, ok := mm.lastRead[zoneName]
+	if ok && last.source == source && zf.Version == last.version {
+		return nil
+	}
+	if ok {
+		log.Printf("Reloading %s\n", zf.FileName)
+		last.version = zf.Version
+		last.source = source
+		last.zf = zf
+	} else {
+		log.Printf("Reading new file %s\n", zf.FileName)
+		last = &zoneReadRecord{version: zf.Version, source: source, zf: zf}
+		mm.lastRead[zoneName] = last
+	}
+
+	// The version (the modification time for files) is only used to check
+	// if the zone might have changed; the contents are compared with the
+	// hash of the zone when it was read last. If the file is changed again
+	// after we read it the version changes again, so we'll get to it on
+	// the next reload. Provided files are replaced atomically, this should be OK.
+	// If files are not replaced atomically we have other problems (e.g.
+	// partial reads).
+
+	data, err := source.ReadZone(ctx, zf)
+	if err != nil {
+		return fmt.Errorf("error reading zone '%s': %s", zoneName, err)
+	}
+
+	hash := sha256Data(data)
+	if last.hash == hash {
+		log.Printf("Skipping new file %s as hash is unchanged\n", zf.FileName)
+		return nil
+	}
+
+	zone, err := mm.newZone(zf, data)
+	if err != nil {
+		mm.loaded(zf, data, hash, nil, err)
+		err = fmt.Errorf("error reading zone '%s': %s", zoneName, err)
+		log.Println(err.Error())
+		return err
+	}
+
+	last.hash = hash
+
+	mm.loaded(zf, data, hash, zone, nil)
+	return nil
+}
+
+// reloadAtomic loads the zones from a source that is used as a whole:
+// all the changed zones are read first and if any of them fail none of
+// the changes (including removed zones) are applied.
+func (mm *MuxManager) reloadAtomic(ctx context.Context, source ZoneSource, files []ZoneFile, seenZones map[string]bool) error {
+	type loadedZone struct {
+		zf   ZoneFile
+		data []byte
+		hash string
+		zone *Zone
+	}
+
+	seen := map[string]bool{}
+	records := map[string]*zoneReadRecord{}
+	loaded := []loadedZone{}
+	changes := []ZoneChange{}
+	var errs []error
+
+	for _, zf := range files {
+		zoneName := zf.Name
+		if seenZones[zoneName] || seen[zoneName] {
+			log.Printf("Skipping %s, zone '%s' is already read from another file", zf.FileName, zoneName)
+			continue
+		}
+		seen[zoneName] = true
+
+		last := mm.lastRead[zoneName]
+		if _, ok := mm.secondaries[zoneName]; ok {
+			if last == nil {
+				log.Printf("Skipping %s, zone '%s' is a secondary zone", zf.FileName, zoneName)
+				records[zoneName] = &zoneReadRecord{version: zf.Version, source: source}
 			}
+			continue
+		}
+		if last != nil && last.source == source && zf.Version == last.version {
+			continue
+		}
 
-			(mm.lastRead[zoneName]).hash = sha256
+		data, err := source.ReadZone(ctx, zf)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading zone '%s': %s", zoneName, err))
+			continue
+		}
+		hash := sha256Data(data)
+		records[zoneName] = &zoneReadRecord{version: zf.Version, hash: hash, source: source, zf: zf}
+		if last != nil && last.hash == hash {
+			continue
+		}
 
-			mm.addHandler(zoneName, zone)
+		log.Printf("Reading %s\n", zf.FileName)
+		zone, err := mm.newZone(zf, data)
+		if err != nil {
+			mm.loaded(zf, data, hash, nil, err)
+			errs = append(errs, fmt.Errorf("error reading zone '%s': %s", zoneName, err))
+			continue
 		}
+		loaded = append(loaded, loadedZone{zf, data, hash, zone})
 	}
 
-	for zoneName, zone := range mm.zonelist {
-		if zoneName == "pgeodns" {
+	if len(errs) > 0 {
+		for _, l := range loaded {
+			mm.skipped(l.zf, l.hash, fmt.Sprintf("other zones from %s failed", source))
+		}
+		return fmt.Errorf("not applying the changes from %s: %w", source, errors.Join(errs...))
+	}
+
+	for zoneName, r := range mm.lastRead {
+		if r.source != source || seen[zoneName] || seenZones[zoneName] {
 			continue
 		}
-		if ok := seenZones[zoneName]; ok {
+		if _, ok := mm.secondaries[zoneName]; ok {
+			delete(mm.lastRead, zoneName)
 			continue
 		}
-		log.Println("Removing zone", zone.Origin)
-		zone.Close()
-		mm.removeHandler(zoneName)
+		changes = append(changes, ZoneChange{Name: zoneName})
+	}
+
+	for zoneName := range seen {
+		seenZones[zoneName] = true
+	}
+	for zoneName, r := range records {
+		mm.lastRead[zoneName] = r
 	}
 
-	return parseErr
+	mm.historyMu.Lock()
+	defer mm.historyMu.Unlock()
+
+	now := time.Now()
+	for _, l := range loaded {
+		mm.applySerialPolicy(l.zone, l.hash)
+		a := LoadAttempt{
+			Time:     now,
+			FileName: l.zf.FileName,
+			Hash:     l.hash,
+			Serial:   uint32(l.zone.Options.Serial),
+		}
+		if mm.history(l.zf.Name).loadedVersion(a, l.zf, l.data) {
+			changes = append(changes, ZoneChange{Name: l.zf.Name, Zone: l.zone})
+		}
+	}
+
+	if len(changes) > 0 {
+		log.Printf("Applying %d zone changes from %s", len(changes), source)
+		mm.applyChanges(changes)
+	}
+	return nil
+}
+
+// applyChanges adds and removes the zones together, in one update if the
+// registry supports it. The caller must hold historyMu.
+func (mm *MuxManager) applyChanges(changes []ZoneChange) {
+	mm.zonesMu.RLock()
+	zonelist := maps.Clone(mm.zonelist)
+	mm.zonesMu.RUnlock()
+
+	for _, c := range changes {
+		if c.Zone != nil {
+			c.Zone.SetupMetrics(zonelist[c.Name])
+			c.Zone.setupHealthTests()
+			c.Zone.lookupZone = mm.findZone
+			c.Zone.updateZone = mm.updateZone
+		}
+	}
+
+	mm.zonesMu.Lock()
+	for _, c := range changes {
+		if c.Zone == nil {
+			delete(mm.lastRead, c.Name)
+			delete(mm.zonelist, c.Name)
+			delete(mm.histories, c.Name)
+		} else {
+			mm.zonelist[c.Name] = c.Zone
+		}
+	}
+	mm.zonesMu.Unlock()
+
+	if reg, ok := mm.reg.(BatchRegistrationAPI); ok {
+		reg.Update(changes)
+	} else {
+		for _, c := range changes {
+			if c.Zone == nil {
+				mm.reg.Remove(c.Name)
+			} else {
+				mm.reg.Add(c.Name, c.Zone)
+			}
+		}
+	}
+
+	names := []string{}
+	for _, c := range changes {
+		if old := zonelist[c.Name]; c.Zone == nil && old != nil {
+			log.Println("Removing zone", old.Origin)
+			old.Close()
+		}
+		names = append(names, c.Name)
+	}
+	mm.zonesChanged(names...)
 }
 
 func (mm *MuxManager) addHandler(name string, zone *Zone) {
+	mm.zonesMu.RLock()
 	oldZone := mm.zonelist[name]
+	mm.zonesMu.RUnlock()
 	zone.SetupMetrics(oldZone)
 	zone.setupHealthTests()
+	zone.lookupZone = mm.findZone
+	zone.updateZone = mm.updateZone
+	mm.zonesMu.Lock()
 	mm.zonelist[name] = zone
+	mm.zonesMu.Unlock()
 	mm.reg.Add(name, zone)
+	mm.zonesChanged(name)
 }
 
+// removeHandler removes the zone. It doesn't change lastRead, so it can
+// be used without holding loadMu (for the secondary zones).
 func (mm *MuxManager) removeHandler(name string) {
-	delete(mm.lastRead, name)
+	mm.zonesMu.Lock()
 	delete(mm.zonelist, name)
+	mm.zonesMu.Unlock()
+	mm.removeHistory(name)
 	mm.reg.Remove(name)
+	mm.zonesChanged(name)
+}
+
+// findZone returns the most specific zone name is in, or nil.
+func (mm *MuxManager) findZone(name string) *Zone {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	mm.zonesMu.RLock()
+	defer mm.zonesMu.RUnlock()
+
+	for len(name) > 0 {
+		if zone, ok := mm.zonelist[name]; ok {
+			return zone
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return nil
 }
 
 func (mm *MuxManager) setupPgeodnsZone() {
@@ -202,11 +544,14 @@ func (mm *MuxManager) setupRootZone() {
 	})
 }
 
-func sha256File(fn string) string {
-	data, err := os.ReadFile(fn)
-	if err != nil {
-		return ""
-	}
+// isZoneFile checks if the file name is a JSON or RFC 1035 master file
+// zone file.
+func isZoneFile(fileName string) bool {
+	fileName = strings.ToLower(fileName)
+	return strings.HasSuffix(fileName, ".json") || strings.HasSuffix(fileName, ".zone")
+}
+
+func sha256Data(data []byte) string {
 	hasher := sha256.New()
 	hasher.Write(data)
 	return hex.EncodeToString(hasher.Sum(nil))
@@ -2,6 +2,7 @@ package zones
 
 import (
 	"math/rand"
+	"time"
 
 	dns "codeberg.org/miekg/dns"
 	"github.com/abh/geodns/v3/health"
@@ -24,11 +25,14 @@ func init() {
 }
 
 func (zone *Zone) filterHealth(servers Records) (Records, int) {
-	// Remove any unhealthy servers
+	// Remove any unhealthy or inactive servers
 	tmpServers := servers[:0]
 
 	sum := 0
 	for i, s := range servers {
+		if !s.Schedule.IsActive() {
+			continue
+		}
 		if len(servers[i].Test) == 0 || health.GetStatus(servers[i].Test) == health.StatusHealthy {
 			tmpServers = append(tmpServers, s)
 			sum += s.Weight
@@ -58,6 +62,10 @@ func (zone *Zone) Picker(label *Label, qtype uint16, max int, location *geo.Loca
 		return result
 	}
 
+	if !label.Schedule.IsActive() {
+		return nil
+	}
+
 	labelRR := label.Records[qtype]
 	if labelRR == nil {
 		// we don't have anything of the correct type
@@ -69,7 +77,11 @@ func (zone *Zone) Picker(label *Label, qtype uint16, max int, location *geo.Loca
 	servers := make(Records, len(labelRR))
 	copy(servers, labelRR)
 
-	if label.Test != nil {
+	if label.ramped {
+		sum = rampWeights(servers, time.Now())
+	}
+
+	if label.Test != nil || label.scheduled {
 		servers, sum = zone.filterHealth(servers)
 		// sum re-check to mirror the label.Weight[] check below
 		if sum == 0 {
@@ -85,7 +97,7 @@ func (zone *Zone) Picker(label *Label, qtype uint16, max int, location *geo.Loca
 	// work as expected.
 	// A, AAAA and CNAME records ("AlwaysWeighted") are always given
 	// a weight so MaxHosts works for those even if weight isn't set.
-	if label.Weight[qtype] == 0 {
+	if sum == 0 {
 		return servers
 	}
 
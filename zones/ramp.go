@@ -0,0 +1,154 @@
+package zones
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ramp changes the weight of a record gradually, for example to move
+// traffic to a new server over a few hours. The weight goes linearly
+// from From to To over Duration after Start.
+type Ramp struct {
+	From, To int
+	Start    time.Time
+	Duration time.Duration
+}
+
+// WeightAt returns the weight at the time t.
+func (r *Ramp) WeightAt(t time.Time) int {
+	switch {
+	case t.Before(r.Start):
+		return r.From
+	case t.Sub(r.Start) >= r.Duration:
+		return r.To
+	}
+	elapsed := float64(t.Sub(r.Start)) / float64(r.Duration)
+	return r.From + int(float64(r.To-r.From)*elapsed)
+}
+
+func (r *Ramp) String() string {
+	return fmt.Sprintf("%d %d %s %s", r.From, r.To, r.Start.Format(time.RFC3339), r.Duration)
+}
+
+// WeightAt returns the weight of the record at the time t, from the ramp
+// if it has one.
+func (r *Record) WeightAt(t time.Time) int {
+	if r.Ramp == nil {
+		return r.Weight
+	}
+	return r.Ramp.WeightAt(t)
+}
+
+// rampWeights replaces the records with a ramp with copies with their
+// weight at t, and returns the sum of the weights.
+func rampWeights(servers Records, t time.Time) int {
+	sum := 0
+	for i, s := range servers {
+		if s.Ramp != nil {
+			r := *s
+			r.Weight = s.Ramp.WeightAt(t)
+			servers[i] = &r
+		}
+		sum += servers[i].Weight
+	}
+	return sum
+}
+
+// ramp reads a weight ramp:
+//
+//	{ "from": 0, "to": 100, "start": "2026-11-01T00:00:00Z", "duration": "6h" }
+//
+// The duration can be a number of seconds too.
+func (c *zoneCheck) ramp(path string, m map[string]interface{}) (*Ramp, bool) {
+	r := &Ramp{}
+	ok := true
+	for _, k := range []string{"from", "to", "start", "duration"} {
+		if _, found := m[k]; !found {
+			c.errorf(path, "missing '%s' in the weight ramp", k)
+			ok = false
+		}
+	}
+	for k, v := range m {
+		kpath := pathKey(path, k)
+		switch k {
+		case "from", "to":
+			n, valid := c.number(kpath, v)
+			if valid && n < 0 {
+				c.errorf(kpath, "negative weight %d", n)
+				valid = false
+			}
+			if k == "from" {
+				r.From = n
+			} else {
+				r.To = n
+			}
+			ok = ok && valid
+		case "start":
+			s, valid := c.str(kpath, v)
+			if valid {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					c.errorf(kpath, "invalid time '%s', expected RFC 3339 like 2006-01-02T15:04:05Z", s)
+					valid = false
+				}
+				r.Start = t
+			}
+			ok = ok && valid
+		case "duration":
+			d, valid := c.rampDuration(kpath, v)
+			r.Duration = d
+			ok = ok && valid
+		default:
+			c.warnf(kpath, "unknown weight ramp option '%s'", k)
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return r, true
+}
+
+func (c *zoneCheck) rampDuration(path string, v interface{}) (time.Duration, bool) {
+	var d time.Duration
+	switch v := v.(type) {
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			c.errorf(path, "invalid duration '%s'", v)
+			return 0, false
+		}
+	default:
+		n, ok := c.number(path, v)
+		if !ok {
+			return 0, false
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d <= 0 {
+		c.errorf(path, "the duration must be positive")
+		return 0, false
+	}
+	return d, true
+}
+
+// parseRamp parses the ramp option in master files, "from to start
+// duration" like "0 100 2026-11-01T00:00:00Z 6h", into the zone data.
+func parseRamp(s string) (map[string]interface{}, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid ramp '%s', expected the start and target weights, the start time and duration", s)
+	}
+	from, err1 := strconv.Atoi(fields[0])
+	to, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid weights in ramp '%s'", s)
+	}
+	return map[string]interface{}{
+		"from":     float64(from),
+		"to":       float64(to),
+		"start":    fields[2],
+		"duration": fields[3],
+	}, nil
+}
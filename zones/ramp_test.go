@@ -0,0 +1,153 @@
+package zones
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestRampWeight(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	r := &Ramp{From: 10, To: 110, Start: start, Duration: 4 * time.Hour}
+
+	assert.Equal(t, 10, r.WeightAt(start.Add(-time.Hour)))
+	assert.Equal(t, 10, r.WeightAt(start))
+	assert.Equal(t, 35, r.WeightAt(start.Add(time.Hour)))
+	assert.Equal(t, 60, r.WeightAt(start.Add(2*time.Hour)))
+	assert.Equal(t, 110, r.WeightAt(start.Add(4*time.Hour)))
+	assert.Equal(t, 110, r.WeightAt(start.Add(48*time.Hour)))
+
+	down := &Ramp{From: 100, To: 0, Start: start, Duration: time.Hour}
+	assert.Equal(t, 25, down.WeightAt(start.Add(45*time.Minute)))
+}
+
+func TestRampedRecords(t *testing.T) {
+	zone := NewZone("example.com")
+	require.NoError(t, zone.ReadZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": {
+      "a": [
+        [ "192.0.2.1", 10 ],
+        { "ip": "192.0.2.2", "weight": { "from": 0, "to": 100, "start": "2100-01-01T00:00:00Z", "duration": "6h" } }
+      ]
+    }
+  }
+}`)))
+
+	www := zone.Labels["www"]
+	require.True(t, www.ramped)
+	var ramp *Ramp
+	for _, r := range www.Records[dns.TypeA] {
+		if r.Ramp != nil {
+			ramp = r.Ramp
+		}
+	}
+	require.NotNil(t, ramp)
+	assert.Equal(t, 6*time.Hour, ramp.Duration)
+
+	picks := func() map[string]int {
+		n := map[string]int{}
+		for i := 0; i < 200; i++ {
+			for _, r := range zone.Picker(www, dns.TypeA, 1, nil) {
+				n[r.RR.(*dns.A).Addr.String()]++
+			}
+		}
+		return n
+	}
+
+	// the ramp hasn't started
+	assert.Equal(t, map[string]int{"192.0.2.1": 200}, picks())
+
+	// and now it's done
+	ramp.Start = time.Now().Add(-7 * time.Hour)
+	assert.Greater(t, picks()["192.0.2.2"], 100)
+	assert.Equal(t, 0, www.Records[dns.TypeA][1].Weight, "the record isn't changed")
+
+	weights := []string{}
+	for _, rr := range zone.WeightsRR("_weights.www.example.com.", "www") {
+		weights = append(weights, strings.Join(rr.(*dns.TXT).Txt, " "))
+	}
+	require.Len(t, weights, 2)
+	assert.Equal(t, "A 192.0.2.1 weight=10", weights[0])
+	assert.True(t, strings.HasPrefix(weights[1], "A 192.0.2.2 weight=100 ramp=0 100 "), weights[1])
+
+	problems := NewZone("example.com").readZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": {
+      "a": [
+        { "ip": "192.0.2.1", "weight": { "from": 0, "to": 100, "start": "2100-01-01T00:00:00Z" } },
+        { "ip": "192.0.2.2", "weight": { "from": -1, "to": 100, "start": "soon", "duration": "forever" } }
+      ]
+    }
+  }
+}`), nil)
+	messages := []string{}
+	for _, p := range problems {
+		messages = append(messages, p.Path+": "+p.Message)
+	}
+	assert.Len(t, messages, 4, strings.Join(messages, "\n"))
+}
+
+func TestRampUnweightedRecords(t *testing.T) {
+	// the other records get weight 1 whether the ramp has started or
+	// not when the zone is loaded
+	for _, start := range []time.Time{time.Now().Add(time.Hour), time.Now().Add(-time.Hour)} {
+		zone := NewZone("example.com")
+		require.NoError(t, zone.ReadZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": {
+      "a": [
+        [ "192.0.2.1" ],
+        { "ip": "192.0.2.2", "weight": { "from": 0, "to": 2, "start": "`+start.Format(time.RFC3339)+`", "duration": "2h" } }
+      ]
+    }
+  }
+}`)))
+
+		www := zone.Labels["www"]
+		for _, r := range www.Records[dns.TypeA] {
+			if r.Ramp == nil {
+				assert.Equal(t, 1, r.Weight, "start %s", start)
+			}
+		}
+
+		n := map[string]int{}
+		for i := 0; i < 200; i++ {
+			for _, r := range zone.Picker(www, dns.TypeA, 1, nil) {
+				n[r.RR.(*dns.A).Addr.String()]++
+			}
+		}
+		assert.Greater(t, n["192.0.2.1"], 50, "start %s", start)
+	}
+}
+
+func TestRampMasterFile(t *testing.T) {
+	objmap, _, err := readMasterFile(strings.NewReader(`$ORIGIN example.com.
+$TTL 300
+@    IN NS ns1.example.net.
+www  IN A 192.0.2.1 ; geo: weight=10
+www  IN A 192.0.2.2 ; geo: ramp="0 100 2026-11-01T00:00:00Z 6h"
+`), "example.com", "example.com.zone")
+	require.NoError(t, err)
+
+	zone := NewZone("example.com")
+	c := &zoneCheck{}
+	setupZoneData(zoneDataMap(objmap), zone, c)
+	require.Empty(t, c.problems)
+
+	ramp := zone.Labels["www"].Records[dns.TypeA][1].Ramp
+	require.NotNil(t, ramp)
+	assert.Equal(t, "0 100 2026-11-01T00:00:00Z 6h0m0s", ramp.String())
+
+	_, _, err = readMasterFile(strings.NewReader(`www IN A 192.0.2.2 ; geo: ramp="0 100"
+`), "example.com", "example.com.zone")
+	assert.Error(t, err)
+}
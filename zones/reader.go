@@ -1,132 +1,165 @@
 package zones
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/netip"
 	"os"
-	"runtime/debug"
+	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abh/geodns/v3/targeting"
-	"github.com/abh/geodns/v3/typeutil"
 
 	dns "codeberg.org/miekg/dns"
 	"codeberg.org/miekg/dns/dnsutil"
 	"codeberg.org/miekg/dns/rdata"
-	"github.com/abh/errorutil"
 )
 
 // ZoneList maps domain names to zone data
 type ZoneList map[string]*Zone
 
-func (zone *Zone) ReadZoneFile(fileName string) (zerr error) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("reading %s failed: %s", zone.Origin, r)
-			debug.PrintStack()
-			zerr = fmt.Errorf("reading %s failed: %s", zone.Origin, r)
-		}
-	}()
+// ReadZoneFile reads the zone from a JSON or master file. Problems with
+// the zone data are returned as a ZoneErrors list with all of them; if
+// there are only warnings the zone is loaded and the warnings are logged.
+func (zone *Zone) ReadZoneFile(fileName string) error {
+	problems, err := zone.readZoneFile(fileName)
+	if err != nil {
+		return err
+	}
+	if problems.HasErrors() {
+		return problems
+	}
+	for _, p := range problems {
+		log.Println(p)
+	}
+	return nil
+}
+
+// ReadZoneData reads the zone from the contents of the zone file zf, like
+// ReadZoneFile.
+func (zone *Zone) ReadZoneData(zf ZoneFile, data []byte) error {
+	problems := zone.readZoneData(zf, data, nil)
+	if problems.HasErrors() {
+		return problems
+	}
+	for _, p := range problems {
+		log.Println(p)
+	}
+	return nil
+}
 
-	fh, err := os.Open(fileName)
+func (zone *Zone) readZoneFile(fileName string) (ZoneErrors, error) {
+	data, err := os.ReadFile(fileName)
 	if err != nil {
 		log.Printf("Could not read '%s': %s", fileName, err)
-		panic(err)
+		return nil, err
 	}
 
-	fileInfo, err := fh.Stat()
+	zf := ZoneFile{Name: zone.Origin, FileName: fileName, KeyDir: filepath.Dir(fileName)}
+
+	fileInfo, err := os.Stat(fileName)
 	if err != nil {
 		log.Printf("Could not stat '%s': %s", fileName, err)
 	} else {
-		zone.Options.Serial = int(fileInfo.ModTime().Unix())
+		zf.ModTime = fileInfo.ModTime()
 	}
 
-	var objmap map[string]interface{}
-	decoder := json.NewDecoder(fh)
-	if err = decoder.Decode(&objmap); err != nil {
-		extra := ""
-		if serr, ok := err.(*json.SyntaxError); ok {
-			if _, serr := fh.Seek(0, io.SeekStart); serr != nil {
-				log.Fatalf("seek error: %v", serr)
-			}
-			line, col, highlight := errorutil.HighlightBytePosition(fh, serr.Offset)
-			extra = fmt.Sprintf(":\nError at line %d, column %d (file offset %d):\n%s",
-				line, col, serr.Offset, highlight)
-		}
-		return fmt.Errorf("error parsing JSON object in config file %s%s\n%v",
-			fh.Name(), extra, err)
-	}
-
-	// log.Println(objmap)
+	return zone.readZoneData(zf, data, nil), nil
+}
 
-	var data map[string]interface{}
+// readZoneData reads the zone file contents; if extend is set it can
+// change the zone data before it's used.
+func (zone *Zone) readZoneData(zf ZoneFile, data []byte, extend func(objmap map[string]interface{}, c *zoneCheck)) ZoneErrors {
+	fileName := zf.FileName
+	if !zf.ModTime.IsZero() {
+		zone.Options.Serial = int(zf.ModTime.Unix())
+	}
 
-	for k, v := range objmap {
-		// log.Printf("k: %s v: %#v, T: %T\n", k, v, v)
+	c := &zoneCheck{file: fileName}
 
-		switch k {
-		case "ttl":
-			zone.Options.Ttl = typeutil.ToInt(v)
-		case "serial":
-			zone.Options.Serial = typeutil.ToInt(v)
-		case "contact":
-			zone.Options.Contact = v.(string)
-		case "max_hosts":
-			zone.Options.MaxHosts = typeutil.ToInt(v)
-		case "closest":
-			zone.Options.Closest = v.(bool)
-			if zone.Options.Closest {
-				zone.HasClosest = true
+	var objmap map[string]interface{}
+	if strings.HasSuffix(strings.ToLower(fileName), ".zone") {
+		var err error
+		objmap, c.positions, err = readMasterFile(bytes.NewReader(data), zone.Origin, fileName)
+		if err != nil {
+			zerr, ok := err.(ZoneErrors)
+			if !ok {
+				zerr = ZoneErrors{{File: fileName, Severity: SeverityError, Message: err.Error()}}
 			}
-		case "targeting":
-			zone.Options.Targeting, err = targeting.ParseTargets(v.(string))
-			if err != nil {
-				return fmt.Errorf("parsing targeting '%s': %s", v, err)
+			c.problems = append(c.problems, zerr...)
+			if objmap == nil {
+				return c.problems
 			}
+		}
+	} else if err := json.Unmarshal(data, &objmap); err != nil {
+		p := &ZoneProblem{
+			File:     fileName,
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("error parsing JSON: %s", err),
+		}
+		var serr *json.SyntaxError
+		var terr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &serr):
+			pos := offsetPosition(data, serr.Offset-1) // the offset is after the bad character
+			p.Line, p.Column = pos.line, pos.column
+		case errors.As(err, &terr):
+			pos := offsetPosition(data, terr.Offset)
+			p.Line, p.Column = pos.line, pos.column
+		}
+		return ZoneErrors{p}
+	} else {
+		c.positions = jsonPositions(data)
+	}
 
-		case "logging":
-			{
-				logging := new(ZoneLogging)
-				for logger, v := range v.(map[string]interface{}) {
-					switch logger {
-					case "stathat":
-						logging.StatHat = typeutil.ToBool(v)
-					case "stathat_api":
-						logging.StatHatAPI = typeutil.ToString(v)
-						logging.StatHat = true
-					default:
-						log.Println("Unknown logger option", logger)
-					}
-				}
-				zone.Logging = logging
-				// log.Printf("logging options: %#v", logging)
-			}
-			continue
+	if extend != nil {
+		extend(objmap, c)
+	}
+	zone.setupZone(objmap, c)
 
-		case "data":
-			data = v.(map[string]interface{})
+	if len(zf.KeyDir) > 0 {
+		if err := zone.setupDNSSEC(zf.KeyDir); err != nil {
+			c.errorf("", "loading DNSSEC keys: %s", err)
 		}
 	}
 
-	setupZoneData(data, zone)
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	if c.problems.HasErrors() {
+		return c.problems
+	}
 
 	// log.Printf("ZO T: %T %s\n", Zones["0.us"], Zones["0.us"])
 
 	// log.Println("IP", string(Zone.Regions["0.us"].IPv4[0].ip))
 
+	zone.setupTargeting()
+
+	return c.problems
+}
+
+// setupTargeting checks the geo provider has the data needed for the
+// zone targeting options.
+func (zone *Zone) setupTargeting() {
 	if zone.Options.Targeting == 0 && !zone.HasClosest {
 		// no targeting requested
-		return nil
+		return
 	}
 
 	if targeting.Geo() == nil {
 		log.Printf("'%s': No geo provider configured", zone.Origin)
-		return nil
+		return
 	}
 
 	switch {
@@ -148,104 +181,319 @@ func (zone *Zone) ReadZoneFile(fileName string) (zerr error) {
 	if zone.HasClosest {
 		zone.SetLocations()
 	}
-
-	return nil
 }
 
-func setupZoneData(data map[string]interface{}, zone *Zone) {
-	recordTypes := map[string]uint16{
-		"a":     dns.TypeA,
-		"aaaa":  dns.TypeAAAA,
-		"alias": dns.TypeMF,
-		"cname": dns.TypeCNAME,
-		"mx":    dns.TypeMX,
-		"ns":    dns.TypeNS,
-		"txt":   dns.TypeTXT,
-		"spf":   dns.TypeSPF,
-		"srv":   dns.TypeSRV,
-		"ptr":   dns.TypePTR,
+// setupZone sets the zone options and data from the zone file.
+func (zone *Zone) setupZone(objmap map[string]interface{}, c *zoneCheck) {
+	var data map[string]interface{}
+
+	for k, v := range objmap {
+		path := pathKey("", k)
+
+		switch k {
+		case "ttl":
+			if n, ok := c.number(path, v); ok {
+				zone.Options.Ttl = n
+			}
+		case "serial":
+			if n, ok := c.number(path, v); ok {
+				zone.Options.Serial = n
+			}
+		case "serial_policy":
+			if s, ok := c.str(path, v); ok {
+				policy, err := ParseSerialPolicy(s)
+				if err != nil {
+					c.errorf(path, "%s", err)
+					continue
+				}
+				zone.Options.SerialPolicy = policy
+			}
+		case "contact":
+			if s, ok := c.str(path, v); ok {
+				zone.Options.Contact = s
+			}
+		case "primary_ns":
+			if s, ok := c.str(path, v); ok {
+				if len(s) == 0 || !dnsutil.IsName(dnsutil.Fqdn(s)) {
+					c.errorf(path, "invalid name '%s'", s)
+					continue
+				}
+				zone.Options.PrimaryNs = s
+			}
+		case "soa_ttl", "refresh", "retry", "expire":
+			if n, ok := c.seconds(path, v, 1); ok {
+				switch k {
+				case "soa_ttl":
+					zone.Options.SoaTtl = n
+				case "refresh":
+					zone.Options.Refresh = n
+				case "retry":
+					zone.Options.Retry = n
+				case "expire":
+					zone.Options.Expire = n
+				}
+			}
+		case "negative_ttl":
+			if n, ok := c.seconds(path, v, 0); ok {
+				zone.Options.NegativeTtl = n
+			}
+		case "max_hosts":
+			if n, ok := c.number(path, v); ok {
+				zone.Options.MaxHosts = n
+			}
+		case "closest":
+			if b, ok := c.boolean(path, v); ok {
+				zone.Options.Closest = b
+				if zone.Options.Closest {
+					zone.HasClosest = true
+				}
+			}
+		case "targeting":
+			s, ok := c.str(path, v)
+			if !ok {
+				continue
+			}
+			t, err := targeting.ParseTargets(s)
+			if err != nil {
+				c.errorf(path, "parsing targeting '%s': %s", s, err)
+				continue
+			}
+			zone.Options.Targeting = t
+
+		case "also_notify":
+			// a list or a space separated string of addresses
+			targets := map[string]string{}
+			switch v := v.(type) {
+			case []interface{}:
+				for i, t := range v {
+					if s, ok := c.str(pathIndex(path, i), t); ok {
+						targets[pathIndex(path, i)] = s
+					}
+				}
+			default:
+				if s, ok := c.str(path, v); ok {
+					for i, t := range strings.Fields(s) {
+						targets[pathIndex(path, i)] = t
+					}
+				}
+			}
+			for tpath, t := range targets {
+				ap, err := ServerAddress(t)
+				if err != nil {
+					c.errorf(tpath, "%s", err)
+					continue
+				}
+				zone.Options.AlsoNotify = append(zone.Options.AlsoNotify, ap)
+			}
+
+		case "views":
+			zone.setupViews(path, v, c)
+
+		case "logging":
+			options, ok := c.object(path, v)
+			if !ok {
+				continue
+			}
+			logging := new(ZoneLogging)
+			for logger, v := range options {
+				lpath := pathKey(path, logger)
+				switch logger {
+				case "stathat":
+					if b, ok := c.boolean(lpath, v); ok {
+						logging.StatHat = b
+					}
+				case "stathat_api":
+					if s, ok := c.str(lpath, v); ok {
+						logging.StatHatAPI = s
+						logging.StatHat = true
+					}
+				default:
+					c.warnf(lpath, "unknown logger option '%s'", logger)
+				}
+			}
+			zone.Logging = logging
+
+		case "data":
+			if m, ok := c.object(path, v); ok {
+				data = m
+			}
+
+		default:
+			c.warnf(path, "unknown zone option '%s'", k)
+		}
 	}
 
-	for dk, dv_inter := range data {
-		dv := dv_inter.(map[string]interface{})
+	setupZoneData(data, zone, c)
+	zone.checkAliases(c)
+}
 
-		// log.Printf("K %s V %s TYPE-V %T\n", dk, dv, dv)
+// zoneRecordTypes are the record types in the zone data.
+var zoneRecordTypes = map[string]uint16{
+	"a":     dns.TypeA,
+	"aaaa":  dns.TypeAAAA,
+	"alias": dns.TypeMF,
+	"cname": dns.TypeCNAME,
+	"mx":    dns.TypeMX,
+	"ns":    dns.TypeNS,
+	"txt":   dns.TypeTXT,
+	"spf":   dns.TypeSPF,
+	"srv":   dns.TypeSRV,
+	"ptr":   dns.TypePTR,
+}
+
+func setupZoneData(data map[string]interface{}, zone *Zone, c *zoneCheck) {
+	for dk, dv_inter := range data {
+		lpath := pathKey("data", dk)
+		dv, ok := c.object(lpath, dv_inter)
+		if !ok {
+			continue
+		}
 
 		label := zone.AddLabel(dk)
+		if label.Schedule = c.schedule(lpath, dv); label.Schedule != nil {
+			zone.addSchedule(labelName(label.Label), label.Schedule)
+		}
 
 		for rType, rdata_ := range dv {
+			path := pathKey(lpath, rType)
+
 			switch rType {
+			case "active_from", "active_until", "active_during", "inactive_during":
+				continue
 			case "max_hosts":
-				label.MaxHosts = typeutil.ToInt(rdata_)
+				if n, ok := c.number(path, rdata_); ok {
+					label.MaxHosts = n
+				}
 				continue
 			case "closest":
-				label.Closest = rdata_.(bool)
-				if label.Closest {
-					zone.HasClosest = true
+				if b, ok := c.boolean(path, rdata_); ok {
+					label.Closest = b
+					if label.Closest {
+						zone.HasClosest = true
+					}
 				}
 				continue
 			case "ttl":
-				label.Ttl = typeutil.ToInt(rdata_)
+				if n, ok := c.number(path, rdata_); ok {
+					label.Ttl = n
+				}
+				continue
+			case "type_ttl":
+				ttls, ok := c.object(path, rdata_)
+				if !ok {
+					continue
+				}
+				for t, v := range ttls {
+					tpath := pathKey(path, t)
+					dnsType, ok := zoneRecordTypes[t]
+					if !ok {
+						c.errorf(tpath, "unsupported record type '%s'", t)
+						continue
+					}
+					if n, ok := c.seconds(tpath, v, 1); ok {
+						label.TypeTtl[dnsType] = n
+					}
+				}
 				continue
 			case "health":
-				zone.addHealthReference(label, rdata_)
+				if rdata_ == nil {
+					continue
+				}
+				if h, ok := c.object(path, rdata_); ok {
+					valid := true
+					for _, k := range []string{"type", "name"} {
+						switch v := h[k].(type) {
+						case nil, string, float64:
+						default:
+							c.errorf(pathKey(path, k), "expected a string, got %s", jsonType(v))
+							valid = false
+						}
+					}
+					if !valid {
+						continue
+					}
+					if err := zone.addHealthReference(label, h); err != nil {
+						c.errorf(path, "%s", err)
+					}
+				}
 				continue
 			}
 
-			dnsType, ok := recordTypes[rType]
+			dnsType, ok := zoneRecordTypes[rType]
 			if !ok {
-				log.Printf("'%s' unsupported record type '%s'\n", zone.Origin, rType)
+				c.warnf(path, "unsupported record type '%s'", rType)
 				continue
 			}
 
 			if rdata_ == nil {
-				// log.Printf("No %s records for label %s\n", rType, dk)
 				continue
 			}
 
-			// log.Printf("rdata %s TYPE-R %T\n", rdata_, rdata_)
-
-			records := make(map[string][]interface{})
+			var records []interface{}
 
 			switch rd := rdata_.(type) {
 			case map[string]interface{}:
-				// Handle NS map syntax, map[ns2.example.net:<nil> ns1.example.net:<nil>]
-				tmp := make([]interface{}, 0)
-				for rdataK, rdataV := range rd {
-					if rdataV == nil {
-						rdataV = ""
+				// NS map syntax, {"ns1.example.net": null, "ns2.example.net": null}
+				if dnsType != dns.TypeNS {
+					c.errorf(path, "expected a list of records, got %s", jsonType(rd))
+					continue
+				}
+				names := make([]string, 0, len(rd))
+				for name, v := range rd {
+					if v != nil && v != "" {
+						c.warnf(pathKey(path, name), "NS records with names syntax not supported")
 					}
-					tmp = append(tmp, []string{rdataK, rdataV.(string)})
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					records = append(records, name)
 				}
-				records[rType] = tmp
 			case string:
 				// CNAME and alias
-				tmp := make([]interface{}, 1)
-				tmp[0] = rd
-				records[rType] = tmp
+				records = []interface{}{rd}
+			case []interface{}:
+				records = rd
 			default:
-				records[rType] = rdata_.([]interface{})
+				c.errorf(path, "expected a list of records, got %s", jsonType(rd))
+				continue
 			}
 
-			// log.Printf("RECORDS %s TYPE-REC %T\n", Records, Records)
-
-			label.Records[dnsType] = make(Records, len(records[rType]))
+			recs := make(Records, 0, len(records))
 
-			for i := 0; i < len(records[rType]); i++ {
-				// log.Printf("RT %T %#v\n", records[rType][i], records[rType][i])
+			for i, rec := range records {
+				rpath := pathIndex(path, i)
 
 				record := new(Record)
 
 				var h dns.Header
 				h.Class = dns.ClassINET
 
-				{
-					// allow for individual health test name overrides
-					if rec, ok := records[rType][i].(map[string]interface{}); ok {
-						if h, ok := rec["health"].(string); ok {
-							record.Test = h
+				recmap, isMap := rec.(map[string]interface{})
+
+				// allow for individual health test name overrides
+				if isMap {
+					if v, ok := recmap["health"]; ok {
+						if s, ok := c.str(pathKey(rpath, "health"), v); ok {
+							record.Test = s
+						}
+					}
+					if v, ok := recmap["weight"]; ok {
+						if m, isRamp := v.(map[string]interface{}); isRamp {
+							if ramp, ok := c.ramp(pathKey(rpath, "weight"), m); ok {
+								record.Ramp = ramp
+								record.Weight = ramp.WeightAt(time.Now())
+							}
+						} else if n, ok := c.number(pathKey(rpath, "weight"), v); ok {
+							record.Weight = n
 						}
 					}
+					if v, ok := recmap["ttl"]; ok {
+						if n, ok := c.seconds(pathKey(rpath, "ttl"), v, 1); ok {
+							h.TTL = uint32(n)
+						}
+					}
+					record.Schedule = c.schedule(rpath, recmap)
 				}
 
 				switch len(label.Label) {
@@ -258,80 +506,78 @@ func setupZoneData(data map[string]interface{}, zone *Zone) {
 				switch dnsType {
 				case dns.TypeA, dns.TypeAAAA, dns.TypePTR:
 
-					rec := records[rType][i]
-
 					var ip string
 
-					switch rec.(type) {
-
+					switch r := rec.(type) {
 					case []interface{}:
-						str, weight := getStringWeight(records[rType][i].([]interface{}))
-						ip = str
-						record.Weight = weight
-
-					case map[string]interface{}:
-						r := rec.(map[string]interface{})
-
-						if _, ok := r["ip"]; ok {
-							ip = r["ip"].(string)
+						if ip, record.Weight, ok = c.stringWeight(rpath, r); !ok {
+							continue
 						}
 
-						if len(ip) == 0 || dnsType == dns.TypePTR {
-							switch dnsType {
-							case dns.TypeA:
-								ip = r["a"].(string)
-							case dns.TypeAAAA:
-								ip = r["aaaa"].(string)
-							case dns.TypePTR:
-								ip = r["ptr"].(string)
-							}
+					case map[string]interface{}:
+						key := "ip"
+						if s, _ := r["ip"].(string); len(s) == 0 || dnsType == dns.TypePTR {
+							key = rType
 						}
-
-						if w, ok := r["weight"]; ok {
-							record.Weight = typeutil.ToInt(w)
+						if r[key] == nil {
+							c.errorf(rpath, "missing '%s'", rType)
+							continue
 						}
-
-						if h, ok := r["health"]; ok {
-							record.Test = typeutil.ToString(h)
+						if ip, ok = c.str(pathKey(rpath, key), r[key]); !ok {
+							continue
 						}
 
+					default:
+						c.errorf(rpath, "expected a list or an object, got %s", jsonType(rec))
+						continue
 					}
 
 					switch dnsType {
 					case dns.TypePTR:
+						if len(ip) == 0 {
+							c.errorf(rpath, "empty PTR record")
+							continue
+						}
 						record.RR = &dns.PTR{Hdr: h, PTR: rdata.PTR{Ptr: ip}}
 					case dns.TypeA:
 						addr, err := netip.ParseAddr(ip)
 						if err != nil {
-							panic(fmt.Errorf("bad A record %q for %q: %v", ip, dk, err))
+							c.errorf(rpath, "bad A record %q: %v", ip, err)
+							continue
 						}
 						if !addr.Is4() {
-							panic(fmt.Errorf("bad A record %q for %q (not IPv4)", ip, dk))
+							c.errorf(rpath, "bad A record %q (not IPv4)", ip)
+							continue
 						}
 						record.RR = &dns.A{Hdr: h, A: rdata.A{Addr: addr}}
 					case dns.TypeAAAA:
 						addr, err := netip.ParseAddr(ip)
 						if err != nil {
-							panic(fmt.Errorf("bad AAAA record %q for %q: %v", ip, dk, err))
+							c.errorf(rpath, "bad AAAA record %q: %v", ip, err)
+							continue
 						}
 						if !addr.Is6() {
-							panic(fmt.Errorf("bad AAAA record %q for %q (not IPv6)", ip, dk))
+							c.errorf(rpath, "bad AAAA record %q (not IPv6)", ip)
+							continue
 						}
 						record.RR = &dns.AAAA{Hdr: h, AAAA: rdata.AAAA{Addr: addr}}
 					}
 
 				case dns.TypeMX:
-					rec := records[rType][i].(map[string]interface{})
-					pref := uint16(0)
-					mx := rec["mx"].(string)
+					if !isMap {
+						c.errorf(rpath, "expected an object, got %s", jsonType(rec))
+						continue
+					}
+					mx, ok := c.requiredString(rpath, recmap, "mx")
+					if !ok {
+						continue
+					}
 					if !strings.HasSuffix(mx, ".") {
 						mx = mx + "."
 					}
-					if rec["weight"] != nil {
-						record.Weight = typeutil.ToInt(rec["weight"])
-					}
-					if rec["preference"] != nil {
-						pref = uint16(typeutil.ToInt(rec["preference"]))
+					pref, ok := c.uint16(rpath, recmap, "preference")
+					if !ok {
+						continue
 					}
 					record.RR = &dns.MX{
 						Hdr: h,
@@ -342,24 +588,23 @@ func setupZoneData(data map[string]interface{}, zone *Zone) {
 					}
 
 				case dns.TypeSRV:
-					rec := records[rType][i].(map[string]interface{})
-					priority := uint16(0)
-					srv_weight := uint16(0)
-					port := uint16(0)
-					target := rec["target"].(string)
-
+					if !isMap {
+						c.errorf(rpath, "expected an object, got %s", jsonType(rec))
+						continue
+					}
+					target, ok := c.requiredString(rpath, recmap, "target")
+					if !ok {
+						continue
+					}
 					if !dnsutil.IsFqdn(target) {
 						target = target + "." + zone.Origin
 					}
 
-					if rec["srv_weight"] != nil {
-						srv_weight = uint16(typeutil.ToInt(rec["srv_weight"]))
-					}
-					if rec["port"] != nil {
-						port = uint16(typeutil.ToInt(rec["port"]))
-					}
-					if rec["priority"] != nil {
-						priority = uint16(typeutil.ToInt(rec["priority"]))
+					srv_weight, ok1 := c.uint16(rpath, recmap, "srv_weight")
+					port, ok2 := c.uint16(rpath, recmap, "port")
+					priority, ok3 := c.uint16(rpath, recmap, "priority")
+					if !ok1 || !ok2 || !ok3 {
+						continue
 					}
 					record.RR = &dns.SRV{
 						Hdr: h,
@@ -372,130 +617,112 @@ func setupZoneData(data map[string]interface{}, zone *Zone) {
 					}
 
 				case dns.TypeCNAME:
-					rec := records[rType][i]
 					var target string
-					var weight int
-					switch rec.(type) {
+					switch r := rec.(type) {
 					case string:
-						target = rec.(string)
+						target = r
 					case []interface{}:
-						target, weight = getStringWeight(rec.([]interface{}))
-					case map[string]interface{}:
-						r := rec.(map[string]interface{})
-
-						if t, ok := r["cname"]; ok {
-							target = typeutil.ToString(t)
+						if target, record.Weight, ok = c.stringWeight(rpath, r); !ok {
+							continue
 						}
-
-						if w, ok := r["weight"]; ok {
-							weight = typeutil.ToInt(w)
-						}
-
-						if h, ok := r["health"]; ok {
-							record.Test = typeutil.ToString(h)
+					case map[string]interface{}:
+						if target, ok = c.requiredString(rpath, r, "cname"); !ok {
+							continue
 						}
+					default:
+						c.errorf(rpath, "expected a string, a list or an object, got %s", jsonType(rec))
+						continue
+					}
+					if len(target) == 0 {
+						c.errorf(rpath, "empty CNAME target")
+						continue
 					}
 					if !dnsutil.IsFqdn(target) {
 						target = target + "." + zone.Origin
 					}
-					record.Weight = weight
 					record.RR = &dns.CNAME{Hdr: h, CNAME: rdata.CNAME{Target: dnsutil.Fqdn(target)}}
 
 				case dns.TypeMF:
-					rec := records[rType][i]
+					target, ok := c.str(rpath, rec)
+					if !ok {
+						continue
+					}
 					// MF records (how we store aliases) are not FQDNs
-					record.RR = &dns.MF{Hdr: h, MF: rdata.MF{Mf: rec.(string)}}
+					record.RR = &dns.MF{Hdr: h, MF: rdata.MF{Mf: target}}
 
 				case dns.TypeNS:
-					rec := records[rType][i]
-
-					var ns string
-
-					switch rec.(type) {
-					case string:
-						ns = rec.(string)
-					case []string:
-						recl := rec.([]string)
-						ns = recl[0]
-						if len(recl[1]) > 0 {
-							log.Println("NS records with names syntax not supported")
-						}
-					default:
-						log.Printf("Data: %T %#v\n", rec, rec)
-						panic("Unrecognized NS format/syntax")
-					}
-
-					rr := &dns.NS{Hdr: h, NS: rdata.NS{Ns: dnsutil.Fqdn(ns)}}
-
-					record.RR = rr
-
-				case dns.TypeTXT:
-					rec := records[rType][i]
-
-					var txt string
-
-					switch rec.(type) {
-					case string:
-						txt = rec.(string)
-					case map[string]interface{}:
-
-						recmap := rec.(map[string]interface{})
-
-						if weight, ok := recmap["weight"]; ok {
-							record.Weight = typeutil.ToInt(weight)
-						}
-						if t, ok := recmap["txt"]; ok {
-							txt = t.(string)
-						}
+					ns, ok := c.str(rpath, rec)
+					if !ok {
+						continue
 					}
-					if len(txt) > 0 {
-						rr := &dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: []string{txt}}}
-						record.RR = rr
-					} else {
-						log.Printf("Zero length txt record for '%s' in '%s'\n", label.Label, zone.Origin)
+					if len(ns) == 0 {
+						c.errorf(rpath, "empty NS record")
 						continue
 					}
-					// Initial SPF support added here, cribbed from the TypeTXT case definition - SPF records should be handled identically
+					record.RR = &dns.NS{Hdr: h, NS: rdata.NS{Ns: dnsutil.Fqdn(ns)}}
 
-				case dns.TypeSPF:
-					rec := records[rType][i]
+				case dns.TypeTXT, dns.TypeSPF:
+					// SPF records are handled just like TXT records
 
-					var spf string
+					// the text can be a list of the strings in the record
+					var txt []string
 
-					switch rec.(type) {
+					switch r := rec.(type) {
 					case string:
-						spf = rec.(string)
+						txt = []string{r}
 					case map[string]interface{}:
-
-						recmap := rec.(map[string]interface{})
-
-						if weight, ok := recmap["weight"]; ok {
-							record.Weight = typeutil.ToInt(weight)
-						}
-						if t, ok := recmap["spf"]; ok {
-							spf = t.(string)
+						tpath := pathKey(rpath, rType)
+						switch t := r[rType].(type) {
+						case nil:
+						case []interface{}:
+							for i, v := range t {
+								if s, ok := c.str(pathIndex(tpath, i), v); ok {
+									txt = append(txt, s)
+								}
+							}
+							if len(txt) < len(t) {
+								continue
+							}
+						default:
+							s, ok := c.str(tpath, t)
+							if !ok {
+								continue
+							}
+							txt = []string{s}
 						}
+					default:
+						c.errorf(rpath, "expected a string or an object, got %s", jsonType(rec))
+						continue
 					}
-					if len(spf) > 0 {
-						rr := &dns.SPF{TXT: dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: []string{spf}}}}
-						record.RR = rr
-					} else {
-						log.Printf("Zero length SPF record for '%s' in '%s'\n", label.Label, zone.Origin)
+					if len(strings.Join(txt, "")) == 0 {
+						c.warnf(rpath, "zero length %s record", strings.ToUpper(rType))
 						continue
 					}
-
-				default:
-					log.Println("type:", rType)
-					panic("Don't know how to handle this type")
+					if dnsType == dns.TypeSPF {
+						record.RR = &dns.SPF{TXT: dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: txt}}}
+					} else {
+						record.RR = &dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: txt}}
+					}
 				}
 
-				if record.RR == nil {
-					panic("record.RR is nil")
+				// the weights of ramped records change, so they don't
+				// decide if the other records are weighted
+				if record.Ramp != nil {
+					label.ramped = true
+				} else {
+					label.Weight[dnsType] += record.Weight
+				}
+				recs = append(recs, record)
+				if record.Schedule != nil {
+					label.scheduled = true
+					fields := strings.Fields(record.RR.String())
+					zone.addSchedule(labelName(label.Label)+" "+strings.Join(fields[3:], " "), record.Schedule)
 				}
-
-				label.Weight[dnsType] += record.Weight
-				label.Records[dnsType][i] = record
 			}
+			if len(recs) == 0 {
+				continue
+			}
+			label.Records[dnsType] = recs
 			if label.Weight[dnsType] > 0 {
 				sort.Sort(RecordsByWeight{label.Records[dnsType]})
 			}
@@ -527,19 +754,24 @@ func setupZoneData(data map[string]interface{}, zone *Zone) {
 				// We add the TTL as a last pass because we might not have
 				// processed it yet when we process the record data.
 
-				if setWeight {
+				if setWeight && r.Ramp == nil {
 					r.Weight = 1
 					l.Weight[qtype] += r.Weight
 				}
 
+				// The TTL of the record itself takes precedence, then the
+				// TTL for the type, the label and the zone.
 				var defaultTtl uint32 = 86400
 				if dns.RRToType(r.RR) != dns.TypeNS {
 					// NS records have special treatment. If they are not specified, they default to 86400 rather than
 					// defaulting to the zone ttl option. The label TTL option always works though
 					defaultTtl = uint32(zone.Options.Ttl)
 				}
-				if zone.Labels[k].Ttl > 0 {
-					defaultTtl = uint32(zone.Labels[k].Ttl)
+				if l.Ttl > 0 {
+					defaultTtl = uint32(l.Ttl)
+				}
+				if ttl := l.TypeTtl[qtype]; ttl > 0 {
+					defaultTtl = uint32(ttl)
 				}
 				if r.RR.Header().TTL == 0 {
 					r.RR.Header().TTL = defaultTtl
@@ -548,25 +780,72 @@ func setupZoneData(data map[string]interface{}, zone *Zone) {
 		}
 	}
 
+	if _, ok := zone.Labels[""]; !ok {
+		c.warnf("data", "no records at the zone apex, you should probably add some NS records")
+	}
+
 	zone.addSOA()
 }
 
-func getStringWeight(rec []interface{}) (string, int) {
-	str := rec[0].(string)
-	var weight int
+// stringWeight reads the ["value", weight] record syntax.
+func (c *zoneCheck) stringWeight(path string, rec []interface{}) (string, int, bool) {
+	if len(rec) == 0 {
+		c.errorf(path, "empty record")
+		return "", 0, false
+	}
+	str, ok := c.str(pathIndex(path, 0), rec[0])
+	if !ok {
+		return "", 0, false
+	}
 
+	var weight int
 	if len(rec) > 1 {
-		switch rec[1].(type) {
-		case string:
-			var err error
-			weight, err = strconv.Atoi(rec[1].(string))
-			if err != nil {
-				panic("Error converting weight to integer")
-			}
-		case float64:
-			weight = int(rec[1].(float64))
+		if weight, ok = c.number(pathIndex(path, 1), rec[1]); !ok {
+			return "", 0, false
 		}
 	}
 
-	return str, weight
+	return str, weight, true
+}
+
+func (c *zoneCheck) requiredString(path string, rec map[string]interface{}, key string) (string, bool) {
+	v, ok := rec[key]
+	if !ok {
+		c.errorf(path, "missing '%s'", key)
+		return "", false
+	}
+	return c.str(pathKey(path, key), v)
+}
+
+// uint16 reads an optional 16 bit number from the record, it's 0 if unset.
+func (c *zoneCheck) uint16(path string, rec map[string]interface{}, key string) (uint16, bool) {
+	v, ok := rec[key]
+	if !ok || v == nil {
+		return 0, true
+	}
+	path = pathKey(path, key)
+	n, ok := c.number(path, v)
+	if !ok {
+		return 0, false
+	}
+	if n < 0 || n > 65535 {
+		c.errorf(path, "%d is out of range (0-65535)", n)
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+// seconds reads a TTL or another time in seconds, from min up to 2^31-1
+// (RFC 2181). Record TTLs can't be 0 since that's used for records
+// without their own TTL.
+func (c *zoneCheck) seconds(path string, v interface{}, min int) (int, bool) {
+	n, ok := c.number(path, v)
+	if !ok {
+		return 0, false
+	}
+	if n < min || n > 1<<31-1 {
+		c.errorf(path, "%d is out of range (%d-2147483647)", n, min)
+		return 0, false
+	}
+	return n, true
 }
@@ -1,14 +1,20 @@
 package zones
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/abh/geodns/v3/targeting"
 	"github.com/abh/geodns/v3/targeting/geoip2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
 )
 
 func loadZones(t *testing.T) *MuxManager {
@@ -106,7 +112,7 @@ func TestRemoveConfig(t *testing.T) {
 		t.Fail()
 	}
 
-	muxm.reload()
+	muxm.reload(context.Background())
 
 	_, err = CopyFile("../dns/test.example.org.json", dir+"/test.example.org.json")
 	if err != nil {
@@ -125,7 +131,7 @@ func TestRemoveConfig(t *testing.T) {
 		t.Fail()
 	}
 
-	muxm.reload()
+	muxm.reload(context.Background())
 	if muxm.zonelist["test.example.org"].Origin != "test.example.org" {
 		t.Errorf("test.example.org has unexpected Origin: '%s'", muxm.zonelist["test.example.org"].Origin)
 	}
@@ -136,7 +142,7 @@ func TestRemoveConfig(t *testing.T) {
 	os.Remove(dir + "/test2.example.org.json")
 	os.Remove(dir + "/invalid.example.org.json")
 
-	muxm.reload()
+	muxm.reload(context.Background())
 
 	if muxm.zonelist["test.example.org"].Origin != "test.example.org" {
 		t.Errorf("test.example.org has unexpected Origin: '%s'", muxm.zonelist["test.example.org"].Origin)
@@ -161,3 +167,71 @@ func CopyFile(src, dst string) (int64, error) {
 	defer df.Close()
 	return io.Copy(df, sf)
 }
+
+func TestRecordTtls(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "ttl.example.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{
+  "ttl": 600,
+  "data": {
+    "": { "ns": [ "ns1.example.net." ], "mx": [ { "mx": "mx.example.net", "preference": 10 } ] },
+    "www": {
+      "ttl": 300,
+      "type_ttl": { "a": 30, "ns": 3600 },
+      "a": [ { "ip": "192.0.2.1", "weight": 10 }, { "ip": "192.0.2.2", "weight": 10, "ttl": 5 } ],
+      "txt": [ "text", { "txt": "more text", "ttl": 60 } ],
+      "ns": [ "ns1.example.net." ]
+    }
+  }
+}`), 0644))
+
+	zone := NewZone("ttl.example")
+	require.NoError(t, zone.ReadZoneFile(fileName))
+
+	ttls := func(label string, qtype uint16) map[string]uint32 {
+		r := map[string]uint32{}
+		for _, record := range zone.Picker(zone.Labels[label], qtype, 10, nil) {
+			r[strings.Join(strings.Fields(record.RR.String())[4:], " ")] = record.RR.Header().TTL
+		}
+		return r
+	}
+
+	// record, then type, label and zone TTLs
+	assert.Equal(t, map[string]uint32{"192.0.2.1": 30, "192.0.2.2": 5}, ttls("www", dns.TypeA))
+	assert.Equal(t, map[string]uint32{`"text"`: 300, `"more text"`: 60}, ttls("www", dns.TypeTXT))
+	assert.Equal(t, map[string]uint32{"ns1.example.net.": 3600}, ttls("www", dns.TypeNS))
+	assert.Equal(t, map[string]uint32{"10 mx.example.net.": 600}, ttls("", dns.TypeMX))
+	assert.Equal(t, map[string]uint32{"ns1.example.net.": 86400}, ttls("", dns.TypeNS))
+}
+
+func TestSOAOptions(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "soa.example.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{
+  "serial": 5,
+  "primary_ns": "ns0.example.net",
+  "soa_ttl": 900,
+  "refresh": 600,
+  "retry": 60,
+  "expire": 86400,
+  "negative_ttl": 30,
+  "data": { "": { "ns": [ "ns1.example.net." ] } }
+}`), 0644))
+
+	zone := NewZone("soa.example")
+	require.NoError(t, zone.ReadZoneFile(fileName))
+
+	soa := zone.SoaRR().(*dns.SOA)
+	assert.Equal(t, "ns0.example.net.", soa.Ns)
+	assert.Equal(t, uint32(900), soa.Hdr.TTL)
+	assert.Equal(t, []uint32{600, 60, 86400, 30}, []uint32{soa.Refresh, soa.Retry, soa.Expire, soa.Minttl})
+	assert.Equal(t, uint32(30), zone.NegativeSoaRR().Header().TTL)
+	assert.Equal(t, uint32(900), zone.SoaRR().Header().TTL)
+
+	// the defaults
+	zone = NewZone("soa.example")
+	require.NoError(t, zone.ReadZoneData(ZoneFile{FileName: "soa.example.json"}, []byte(`{ "data": { "": { "ns": [ "ns1.example.net." ] } } }`)))
+	soa = zone.SoaRR().(*dns.SOA)
+	assert.Equal(t, "ns1.example.net.", soa.Ns)
+	assert.Equal(t, uint32(1200), soa.Hdr.TTL)
+	assert.Equal(t, []uint32{5400, 5400, 1209600, 3600}, []uint32{soa.Refresh, soa.Retry, soa.Expire, soa.Minttl})
+	assert.Equal(t, uint32(1200), zone.NegativeSoaRR().Header().TTL)
+}
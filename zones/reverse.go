@@ -0,0 +1,369 @@
+package zones
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Reverse zones (in-addr.arpa and ip6.arpa) can have PTR records generated
+// from the A and AAAA records in forward zones. The generated records are
+// added to the zone file of the reverse zone for the names it doesn't
+// have; without a zone file the zone has only the generated records, with
+// the NS records from the first forward zone. The reverse zones are
+// generated again when the forward zones change.
+
+// ReversePreference picks the name for the PTR record of an address used
+// by more than one name.
+type ReversePreference string
+
+const (
+	// ReverseFirst uses the name from the first forward zone with the
+	// address, the shortest one if the zone has several.
+	ReverseFirst ReversePreference = "first"
+
+	// ReverseShortest uses the shortest name from any of the zones.
+	ReverseShortest ReversePreference = "shortest"
+
+	// ReverseAll has a PTR record for each of the names.
+	ReverseAll ReversePreference = "all"
+)
+
+// ParseReversePreference checks the name of a preference, the default is
+// "first".
+func ParseReversePreference(s string) (ReversePreference, error) {
+	switch p := ReversePreference(strings.ToLower(s)); p {
+	case "":
+		return ReverseFirst, nil
+	case ReverseFirst, ReverseShortest, ReverseAll:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown reverse zone preference '%s'", s)
+}
+
+// ReverseConfig configures the PTR records generated for a reverse zone.
+type ReverseConfig struct {
+	From   []string // forward zones, in order of preference
+	Prefer ReversePreference
+}
+
+// IsReverseZone checks if name is in in-addr.arpa or ip6.arpa.
+func IsReverseZone(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
+
+// Check returns an error if the configuration for reverse zone name
+// isn't usable.
+func (c ReverseConfig) Check(name string) error {
+	if !IsReverseZone(name) {
+		return fmt.Errorf("'%s' isn't an in-addr.arpa or ip6.arpa zone", name)
+	}
+	if len(c.From) == 0 {
+		return fmt.Errorf("reverse zone '%s' has no forward zones", name)
+	}
+	for _, from := range c.From {
+		if IsReverseZone(from) {
+			return fmt.Errorf("reverse zone '%s': '%s' isn't a forward zone", name, from)
+		}
+	}
+	if _, err := ParseReversePreference(string(c.Prefer)); err != nil {
+		return fmt.Errorf("reverse zone '%s': %s", name, err)
+	}
+	return nil
+}
+
+// reverseSource is the zone file a reverse zone was generated with, and
+// the hash of the zone data with the generated records.
+type reverseSource struct {
+	zf   ZoneFile
+	data []byte
+	hash string
+}
+
+// setupReverses adds the configured reverse zones, before the zones are
+// loaded.
+func (mm *MuxManager) setupReverses(reverses map[string]ReverseConfig) {
+	mm.reverses = map[string]ReverseConfig{}
+	for name, config := range reverses {
+		if err := config.Check(name); err != nil {
+			log.Printf("skipping reverse zone: %s", err)
+			continue
+		}
+		from := make([]string, len(config.From))
+		for i, f := range config.From {
+			from[i] = strings.TrimSuffix(strings.ToLower(f), ".")
+		}
+		config.From = from
+		config.Prefer, _ = ParseReversePreference(string(config.Prefer))
+		mm.reverses[strings.TrimSuffix(strings.ToLower(name), ".")] = config
+	}
+}
+
+// newZone reads the zone from the zone file contents, with the generated
+// PTR records for reverse zones.
+func (mm *MuxManager) newZone(zf ZoneFile, data []byte) (*Zone, error) {
+	zone := NewZone(zf.Name)
+	config, ok := mm.reverses[zf.Name]
+	if !ok {
+		if err := zone.ReadZoneData(zf, data); err != nil {
+			return nil, err
+		}
+		return zone, nil
+	}
+
+	problems := zone.readZoneData(zf, data, func(objmap map[string]interface{}, c *zoneCheck) {
+		mm.addPTRs(zf.Name, config, objmap, c)
+		js, _ := json.Marshal(objmap)
+		zone.reverse = &reverseSource{zf: zf, data: data, hash: sha256Data(js)}
+	})
+	if problems.HasErrors() {
+		return nil, problems
+	}
+	for _, p := range problems {
+		log.Println(p)
+	}
+	return zone, nil
+}
+
+// zonesChanged generates the reverse zones again after the zones in names
+// were added, changed or removed.
+func (mm *MuxManager) zonesChanged(names ...string) {
+	for name, config := range mm.reverses {
+		if slices.Contains(names, name) {
+			// generate the zone without the zone file if it was removed
+			mm.zonesMu.RLock()
+			_, ok := mm.zonelist[name]
+			mm.zonesMu.RUnlock()
+			if ok {
+				continue
+			}
+		} else if !slices.ContainsFunc(names, func(n string) bool { return slices.Contains(config.From, n) }) {
+			continue
+		}
+		mm.generateReverse(name)
+	}
+}
+
+// generateReverse loads the reverse zone again with the PTR records from
+// the current forward zones, from the zone file it's using if it has one.
+func (mm *MuxManager) generateReverse(name string) {
+	mm.reverseMu.Lock()
+	defer mm.reverseMu.Unlock()
+
+	mm.zonesMu.RLock()
+	current := mm.zonelist[name]
+	mm.zonesMu.RUnlock()
+
+	generated := ZoneFile{Name: name, FileName: name + " (generated)"}
+	zf, data := generated, []byte("{}")
+	if current != nil && current.reverse != nil {
+		zf, data = current.reverse.zf, current.reverse.data
+	}
+
+	zone, err := mm.newZone(zf, data)
+	if err != nil {
+		log.Printf("error generating reverse zone '%s': %s", name, err)
+		return
+	}
+	if current != nil && current.reverse != nil && current.reverse.hash == zone.reverse.hash {
+		return
+	}
+	if zf == generated && len(zone.Labels[""].Records[dns.TypeNS]) == 0 {
+		// none of the forward zones are loaded
+		if current != nil {
+			log.Println("Removing zone", name)
+			mm.zonesMu.Lock()
+			delete(mm.zonelist, name)
+			mm.zonesMu.Unlock()
+			mm.reg.Remove(name)
+			current.Close()
+		}
+		return
+	}
+
+	log.Printf("Generating reverse zone '%s'", name)
+	mm.applySerialPolicy(zone, zone.reverse.hash)
+	mm.addHandler(name, zone)
+	if err := mm.serials.Save(); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// addPTRs adds the PTR records generated from the forward zones to the
+// zone data of a reverse zone. PTR records in the zone file are kept, with
+// a warning if they are different from the generated ones.
+func (mm *MuxManager) addPTRs(name string, config ReverseConfig, objmap map[string]interface{}, c *zoneCheck) {
+	mm.zonesMu.RLock()
+	forward := []*Zone{}
+	for _, from := range config.From {
+		if zone := mm.zonelist[from]; zone != nil {
+			forward = append(forward, zone)
+		}
+	}
+	mm.zonesMu.RUnlock()
+
+	zoneData := zoneDataMap(objmap)
+
+	apex, ok := zoneData[""].(map[string]interface{})
+	if !ok && zoneData[""] == nil {
+		apex = map[string]interface{}{}
+	}
+	if apex != nil && apex["ns"] == nil && len(forward) > 0 && forward[0].Labels[""] != nil {
+		ns := []interface{}{}
+		for _, r := range forward[0].Labels[""].Records[dns.TypeNS] {
+			ns = append(ns, r.RR.(*dns.NS).Ns)
+		}
+		if len(ns) > 0 {
+			apex["ns"] = ns
+			zoneData[""] = apex
+		}
+	}
+
+	ptrs := reversePTRs(name, config.Prefer, forward)
+	labels := make([]string, 0, len(ptrs))
+	for label := range ptrs {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+
+	for _, label := range labels {
+		ptr := ptrs[label]
+		labelData, ok := zoneData[label].(map[string]interface{})
+		if !ok {
+			if zoneData[label] != nil {
+				// not valid, it's reported when the zone is read
+				continue
+			}
+			labelData = map[string]interface{}{}
+			zoneData[label] = labelData
+		}
+
+		if current, ok := labelData["ptr"]; ok {
+			if names := ptrNames(current); !slices.Equal(names, ptr.names) {
+				c.warnf(pathKey(pathKey("data", label), "ptr"), "the PTR records for %s are %s, the forward zones have %s",
+					ptr.ip, strings.Join(names, " "), strings.Join(ptr.names, " "))
+			}
+			continue
+		}
+
+		records := make([]interface{}, len(ptr.names))
+		for i, n := range ptr.names {
+			records[i] = []interface{}{n}
+		}
+		labelData["ptr"] = records
+	}
+}
+
+type reversePTR struct {
+	ip    netip.Addr
+	names []string
+}
+
+// reversePTRs returns the PTR records for the reverse zone name from the
+// A and AAAA records in the forward zones, by label.
+func reversePTRs(name string, prefer ReversePreference, forward []*Zone) map[string]*reversePTR {
+	suffix := "." + name + "."
+
+	type candidate struct {
+		name string
+		zone int
+	}
+	candidates := map[string][]candidate{}
+	ptrs := map[string]*reversePTR{}
+
+	for i, zone := range forward {
+		origin := dnsutil.Fqdn(zone.Origin)
+		for k, label := range zone.Labels {
+			// targeted labels are for the name without the target
+			owner, t := labelTarget(k)
+			if zone.viewTargets(t) == nil {
+				owner = k
+			}
+			if strings.Contains(owner, "*") {
+				continue
+			}
+			if len(owner) > 0 {
+				owner = owner + "." + origin
+			} else {
+				owner = origin
+			}
+
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				for _, r := range label.Records[qtype] {
+					var ip netip.Addr
+					switch rr := r.RR.(type) {
+					case *dns.A:
+						ip = rr.Addr
+					case *dns.AAAA:
+						ip = rr.Addr
+					}
+					arpa := dnsutil.ReverseAddr(ip)
+					if !strings.HasSuffix(arpa, suffix) {
+						continue
+					}
+					l := strings.TrimSuffix(arpa, suffix)
+					if !slices.ContainsFunc(candidates[l], func(c candidate) bool { return c.name == owner }) {
+						candidates[l] = append(candidates[l], candidate{owner, i})
+					}
+					ptrs[l] = &reversePTR{ip: ip}
+				}
+			}
+		}
+	}
+
+	shorter := func(a, b candidate) int {
+		return cmp.Or(
+			cmp.Compare(dnsutil.Labels(a.name), dnsutil.Labels(b.name)),
+			cmp.Compare(len(a.name), len(b.name)),
+		)
+	}
+	for l, c := range candidates {
+		slices.SortFunc(c, func(a, b candidate) int {
+			if prefer == ReverseShortest {
+				return cmp.Or(shorter(a, b), cmp.Compare(a.zone, b.zone), strings.Compare(a.name, b.name))
+			}
+			return cmp.Or(cmp.Compare(a.zone, b.zone), shorter(a, b), strings.Compare(a.name, b.name))
+		})
+		if prefer != ReverseAll {
+			c = c[:1]
+		}
+		for _, cand := range c {
+			ptrs[l].names = append(ptrs[l].names, cand.name)
+		}
+		slices.Sort(ptrs[l].names)
+	}
+	return ptrs
+}
+
+// ptrNames returns the names in PTR records from the zone data.
+func ptrNames(v interface{}) []string {
+	records, ok := v.([]interface{})
+	if !ok {
+		records = []interface{}{v}
+	}
+	names := []string{}
+	for _, rec := range records {
+		var s string
+		switch r := rec.(type) {
+		case []interface{}:
+			if len(r) > 0 {
+				s, _ = r[0].(string)
+			}
+		case map[string]interface{}:
+			s, _ = r["ptr"].(string)
+		}
+		if len(s) > 0 {
+			names = append(names, strings.ToLower(dnsutil.Fqdn(s)))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,149 @@
+package zones
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+func TestReverseZones(t *testing.T) {
+	dir := t.TempDir()
+	forward := func(ip string) {
+		data := `{
+  "targeting": "@ continent",
+  "data": {
+    "": { "ns": [ "ns1.example.net", "ns2.example.net" ] },
+    "www": { "a": [ [ "` + ip + `" ] ], "aaaa": [ [ "2001:db8::1" ] ] },
+    "mail": { "a": [ [ "192.0.2.1" ] ] },
+    "www.europe": { "a": [ [ "192.0.2.2" ] ] },
+    "*.wild": { "a": [ [ "192.0.2.3" ] ] },
+    "other": { "a": [ [ "198.51.100.1" ] ] }
+  }
+}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com.json"), []byte(data), 0644))
+	}
+	forward("192.0.2.1")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2.0.192.in-addr.arpa.json"), []byte(`{
+  "serial": 5,
+  "data": {
+    "": { "ns": [ "ns.example.org" ] },
+    "1": { "ptr": [ [ "web.example.com." ] ] },
+    "99": { "ptr": [ [ "manual.example.org." ] ] }
+  }
+}`), 0644))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManagerOptions(reg, MuxOptions{
+		Sources: []ZoneSource{&DirSource{Dir: dir}},
+		Reverses: map[string]ReverseConfig{
+			"2.0.192.in-addr.arpa":      {From: []string{"example.com"}},
+			"8.b.d.0.1.0.0.2.ip6.arpa.": {From: []string{"Example.com."}},
+		},
+	})
+	require.NoError(t, err)
+
+	ptrs := func(zone, label string) []string {
+		t.Helper()
+		z := reg.get(zone)
+		require.NotNil(t, z, zone)
+		names := []string{}
+		if l, ok := z.Labels[label]; ok {
+			for _, r := range l.Records[dns.TypePTR] {
+				names = append(names, r.RR.(*dns.PTR).Ptr)
+			}
+		}
+		return names
+	}
+	serial := func(zone string) uint32 {
+		return reg.get(zone).SoaRR().(*dns.SOA).Serial
+	}
+
+	// the PTR records from the zone file are kept
+	assert.Equal(t, []string{"web.example.com."}, ptrs("2.0.192.in-addr.arpa", "1"))
+	assert.Equal(t, []string{"manual.example.org."}, ptrs("2.0.192.in-addr.arpa", "99"))
+	assert.Equal(t, []string{"www.example.com."}, ptrs("2.0.192.in-addr.arpa", "2"))
+	assert.Empty(t, ptrs("2.0.192.in-addr.arpa", "3"), "wildcards don't have PTR records")
+	assert.Equal(t, "ns.example.org.", reg.get("2.0.192.in-addr.arpa").Labels[""].Records[dns.TypeNS][0].RR.(*dns.NS).Ns)
+
+	// the ip6.arpa zone doesn't have a file
+	ip6 := "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0"
+	assert.Equal(t, []string{"www.example.com."}, ptrs("8.b.d.0.1.0.0.2.ip6.arpa", ip6))
+	assert.Len(t, reg.get("8.b.d.0.1.0.0.2.ip6.arpa").Labels[""].Records[dns.TypeNS], 2)
+
+	// the conflict with the forward zone is reported
+	c := &zoneCheck{}
+	mm.addPTRs("2.0.192.in-addr.arpa", mm.reverses["2.0.192.in-addr.arpa"], map[string]interface{}{
+		"data": map[string]interface{}{"1": map[string]interface{}{"ptr": []interface{}{[]interface{}{"web.example.com"}}}},
+	}, c)
+	if assert.Len(t, c.problems, 1) {
+		assert.Equal(t, SeverityWarning, c.problems[0].Severity)
+		assert.Equal(t, "the PTR records for 192.0.2.1 are web.example.com., the forward zones have www.example.com.", c.problems[0].Message)
+	}
+
+	// changes in the forward zone change the reverse zones, with new serials
+	before := serial("2.0.192.in-addr.arpa")
+	ip6Before := serial("8.b.d.0.1.0.0.2.ip6.arpa")
+	forward("192.0.2.4")
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Equal(t, []string{"www.example.com."}, ptrs("2.0.192.in-addr.arpa", "4"))
+	assert.Greater(t, serial("2.0.192.in-addr.arpa"), before)
+	assert.Equal(t, ip6Before, serial("8.b.d.0.1.0.0.2.ip6.arpa"), "the ip6.arpa zone didn't change")
+
+	// without the zone file the zone has the generated records
+	require.NoError(t, os.Remove(filepath.Join(dir, "2.0.192.in-addr.arpa.json")))
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Equal(t, []string{"mail.example.com."}, ptrs("2.0.192.in-addr.arpa", "1"))
+	assert.Empty(t, ptrs("2.0.192.in-addr.arpa", "99"))
+
+	// and it's removed with the forward zone
+	require.NoError(t, os.Remove(filepath.Join(dir, "example.com.json")))
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Nil(t, reg.get("2.0.192.in-addr.arpa"))
+	assert.Nil(t, reg.get("8.b.d.0.1.0.0.2.ip6.arpa"))
+}
+
+func TestReversePreference(t *testing.T) {
+	zone := func(name string, labels ...string) *Zone {
+		z := NewZone(name)
+		for _, l := range labels {
+			label := z.AddLabel(l)
+			label.Records[dns.TypeA] = []*Record{{RR: &dns.A{A: rdata.A{Addr: netip.MustParseAddr("192.0.2.1")}}}}
+		}
+		return z
+	}
+	forward := []*Zone{
+		zone("example.com", "www.long", "web"),
+		zone("a.example", "x"),
+	}
+
+	tests := []struct {
+		prefer ReversePreference
+		names  []string
+	}{
+		{ReverseFirst, []string{"web.example.com."}},
+		{ReverseShortest, []string{"x.a.example."}},
+		{ReverseAll, []string{"web.example.com.", "www.long.example.com.", "x.a.example."}},
+	}
+	for _, tt := range tests {
+		ptrs := reversePTRs("2.0.192.in-addr.arpa", tt.prefer, forward)
+		if assert.Contains(t, ptrs, "1", tt.prefer) {
+			assert.Equal(t, tt.names, ptrs["1"].names, tt.prefer)
+		}
+	}
+
+	_, err := ParseReversePreference("longest")
+	assert.Error(t, err)
+	assert.Error(t, ReverseConfig{From: []string{"example.com"}}.Check("example.com"))
+	assert.Error(t, ReverseConfig{}.Check("2.0.192.in-addr.arpa"))
+	assert.NoError(t, ReverseConfig{From: []string{"example.com"}}.Check("2.0.192.in-addr.arpa."))
+	assert.True(t, IsReverseZone("8.B.D.0.1.0.0.2.IP6.ARPA."))
+	assert.False(t, IsReverseZone("in-addr.arpa.example.com"))
+}
@@ -0,0 +1,413 @@
+package zones
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Records and labels can have a schedule: a time they become active or
+// inactive, and recurring windows (in cron syntax, in UTC) when they are
+// active or inactive. The schedules are checked every minute, so the
+// changes don't need the zone to be reloaded; inactive records are
+// skipped by the Picker like unhealthy ones.
+
+// scheduleKeys are the schedule options for labels and records.
+var scheduleKeys = []string{"active_from", "active_until", "active_during", "inactive_during"}
+
+// maxWindow is the longest window duration.
+const maxWindow = 31 * 24 * time.Hour
+
+// Schedule is when a record or label is active.
+type Schedule struct {
+	From  time.Time // active from, if set
+	Until time.Time // inactive from, if set
+
+	// if there are active windows the record is only active during them
+	Active   []*Window
+	Inactive []*Window
+
+	inactive atomic.Bool
+}
+
+// Window is a recurring time window, starting at the times matching the
+// cron expression.
+type Window struct {
+	Spec     string
+	Duration time.Duration
+	cron     cronSpec
+}
+
+// IsActive returns true if the record or label is active now, or if it
+// doesn't have a schedule.
+func (s *Schedule) IsActive() bool {
+	return s == nil || !s.inactive.Load()
+}
+
+// activeAt checks the schedule for the time t.
+func (s *Schedule) activeAt(t time.Time) bool {
+	if !s.From.IsZero() && t.Before(s.From) {
+		return false
+	}
+	if !s.Until.IsZero() && !t.Before(s.Until) {
+		return false
+	}
+	if len(s.Active) > 0 {
+		active := false
+		for _, w := range s.Active {
+			if w.activeAt(t) {
+				active = true
+				break
+			}
+		}
+		if !active {
+			return false
+		}
+	}
+	for _, w := range s.Inactive {
+		if w.activeAt(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// activeAt checks if a window started less than the duration before t.
+func (w *Window) activeAt(t time.Time) bool {
+	t = t.UTC().Truncate(time.Minute)
+	start, ok := w.cron.last(t, t.Add(-w.Duration))
+	return ok && t.Sub(start) < w.Duration
+}
+
+// parseWindow parses "minute hour day-of-month month day-of-week
+// duration", for example "0 2 * * sun 2h".
+func parseWindow(s string) (*Window, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid window '%s', expected five cron fields and a duration", s)
+	}
+	d, err := time.ParseDuration(fields[5])
+	if err != nil || d < time.Minute || d > maxWindow {
+		return nil, fmt.Errorf("invalid window duration '%s', it must be from 1m to %s", fields[5], maxWindow)
+	}
+	cron, err := parseCron(fields[:5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid window '%s': %s", s, err)
+	}
+	return &Window{Spec: s, Duration: d, cron: cron}, nil
+}
+
+// cronSpec has a bit for each value matching the cron fields.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+
+	// with both days restricted either of them match, as in cron
+	anyDay bool
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCron(fields []string) (cronSpec, error) {
+	var c cronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return c, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return c, err
+	}
+	// 7 is Sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDay = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField parses a list of values, ranges ("1-5") and steps
+// ("*/15", "0-30/10"); names are for the values from min.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value '%s' in '%s'", s, field)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		r, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step '%s' in '%s'", stepStr, field)
+			}
+		}
+
+		first, last := min, max
+		if r != "*" {
+			lo, hi, isRange := strings.Cut(r, "-")
+			var err error
+			if first, err = value(lo); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = value(hi); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = max
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range '%s' in '%s'", r, field)
+			}
+		}
+		for n := first; n <= last; n += step {
+			bits |= 1 << n
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSpec) matchDay(t time.Time) bool {
+	if c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.anyDay {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// last returns the last minute matching the cron fields at or before t
+// (in UTC, truncated to the minute), if it's after the time after. The
+// days and hours that don't match are skipped as a whole.
+func (c cronSpec) last(t, after time.Time) (time.Time, bool) {
+	for t.After(after) {
+		switch {
+		case !c.matchDay(t):
+			y, m, d := t.Date()
+			t = time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(-time.Minute)
+		default:
+			for m := t.Minute(); m >= 0; m-- {
+				if c.minute&(1<<m) != 0 {
+					t = t.Add(-time.Duration(t.Minute()-m) * time.Minute)
+					return t, t.After(after)
+				}
+			}
+			t = t.Truncate(time.Hour).Add(-time.Minute)
+		}
+	}
+	return time.Time{}, false
+}
+
+// schedule reads the schedule options of a label or record, or returns
+// nil if it doesn't have any.
+func (c *zoneCheck) schedule(path string, m map[string]interface{}) *Schedule {
+	var s *Schedule
+	for _, k := range scheduleKeys {
+		v, ok := m[k]
+		if !ok {
+			continue
+		}
+		if s == nil {
+			s = &Schedule{}
+		}
+		kpath := pathKey(path, k)
+
+		switch k {
+		case "active_from", "active_until":
+			str, ok := c.str(kpath, v)
+			if !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				c.errorf(kpath, "invalid time '%s', expected RFC 3339 like 2006-01-02T15:04:05Z", str)
+				continue
+			}
+			if k == "active_from" {
+				s.From = t
+			} else {
+				s.Until = t
+			}
+
+		case "active_during", "inactive_during":
+			// a window or a list of them
+			specs := map[string]interface{}{}
+			if list, ok := v.([]interface{}); ok {
+				for i, w := range list {
+					specs[pathIndex(kpath, i)] = w
+				}
+			} else {
+				specs[kpath] = v
+			}
+			for wpath, w := range specs {
+				str, ok := c.str(wpath, w)
+				if !ok {
+					continue
+				}
+				window, err := parseWindow(str)
+				if err != nil {
+					c.errorf(wpath, "%s", err)
+					continue
+				}
+				if k == "active_during" {
+					s.Active = append(s.Active, window)
+				} else {
+					s.Inactive = append(s.Inactive, window)
+				}
+			}
+		}
+	}
+	if s != nil && !s.From.IsZero() && !s.Until.IsZero() && !s.Until.After(s.From) {
+		c.warnf(pathKey(path, "active_until"), "active_until is before active_from, it's never active")
+	}
+	return s
+}
+
+// scheduled is a label or record with a schedule in the zone.
+type scheduled struct {
+	name     string // the label, with the record for records
+	schedule *Schedule
+}
+
+// labelName returns the label for the logs, "@" for the zone apex.
+func labelName(label string) string {
+	if len(label) == 0 {
+		return "@"
+	}
+	return label
+}
+
+// addSchedule adds a label or record schedule, with its current state.
+func (z *Zone) addSchedule(name string, s *Schedule) {
+	s.inactive.Store(!s.activeAt(time.Now()))
+	z.schedules = append(z.schedules, scheduled{name, s})
+}
+
+// updateSchedules checks the schedules at t and returns the number of
+// labels and records that became active or inactive.
+func (z *Zone) updateSchedules(t time.Time) int {
+	changes := 0
+	for _, sc := range z.schedules {
+		active := sc.schedule.activeAt(t)
+		if active == sc.schedule.IsActive() {
+			continue
+		}
+		sc.schedule.inactive.Store(!active)
+		changes++
+		state := "inactive"
+		if active {
+			state = "active"
+		}
+		log.Printf("Zone '%s': %s is %s (schedule)", z.Origin, sc.name, state)
+	}
+	return changes
+}
+
+// runSchedules updates the schedules of the zones at the start of every
+// minute.
+func (mm *MuxManager) runSchedules(ctx context.Context) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now = <-timer.C:
+		}
+		mm.updateSchedules(now)
+	}
+}
+
+func (mm *MuxManager) updateSchedules(now time.Time) {
+	mm.zonesMu.RLock()
+	zones := make([]*Zone, 0, len(mm.zonelist))
+	for _, zone := range mm.zonelist {
+		zones = append(zones, zone)
+	}
+	mm.zonesMu.RUnlock()
+
+	for _, zone := range zones {
+		if n := zone.updateSchedules(now); n > 0 {
+			mm.scheduleTransitions.Add(uint64(n))
+		}
+	}
+}
+
+var (
+	scheduleActiveDesc = prometheus.NewDesc(
+		"dns_zone_schedule_active",
+		"1 if the scheduled label or record is active",
+		[]string{"zone", "name"}, nil,
+	)
+	scheduleTransitionsDesc = prometheus.NewDesc(
+		"dns_zone_schedule_transitions_total",
+		"Number of times scheduled labels and records became active or inactive",
+		nil, nil,
+	)
+)
+
+type scheduleCollector struct {
+	mm *MuxManager
+}
+
+// ScheduleMetrics returns a Prometheus collector with the state of the
+// scheduled labels and records.
+func (mm *MuxManager) ScheduleMetrics() prometheus.Collector {
+	return &scheduleCollector{mm: mm}
+}
+
+func (c *scheduleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scheduleActiveDesc
+	ch <- scheduleTransitionsDesc
+}
+
+func (c *scheduleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mm.zonesMu.RLock()
+	defer c.mm.zonesMu.RUnlock()
+
+	for name, zone := range c.mm.zonelist {
+		for _, sc := range zone.schedules {
+			active := 0.0
+			if sc.schedule.IsActive() {
+				active = 1
+			}
+			ch <- prometheus.MustNewConstMetric(scheduleActiveDesc, prometheus.GaugeValue, active, name, sc.name)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(scheduleTransitionsDesc, prometheus.CounterValue,
+		float64(c.mm.scheduleTransitions.Load()))
+}
@@ -0,0 +1,194 @@
+package zones
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return ts
+	}
+
+	tests := []struct {
+		window string
+		time   string
+		active bool
+	}{
+		// 2026-10-18 is a Sunday
+		{"0 2 * * sun 2h", "2026-10-18T02:00:00Z", true},
+		{"0 2 * * sun 2h", "2026-10-18T03:59:59Z", true},
+		{"0 2 * * sun 2h", "2026-10-18T04:00:00Z", false},
+		{"0 2 * * sun 2h", "2026-10-18T01:59:00Z", false},
+		{"0 2 * * 7 2h", "2026-10-18T02:30:00Z", true},
+		{"0 2 * * mon-fri 2h", "2026-10-18T02:30:00Z", false},
+		{"0 2 * * mon-fri 2h", "2026-10-19T02:30:00Z", true},
+		{"0 23 * * sat 2h", "2026-10-18T00:30:00Z", true},
+		{"*/15 * * * * 5m", "2026-10-18T10:35:00Z", false},
+		{"*/15 * * * * 5m", "2026-10-18T10:47:00Z", true},
+		{"0 0 1 jan * 24h", "2026-01-01T12:00:00+02:00", true},
+		// day of month or day of week, like cron
+		{"0 12 1 * fri 1h", "2026-10-01T12:10:00Z", true},
+		{"0 12 1 * fri 1h", "2026-10-02T12:10:00Z", true},
+		{"0 12 1 * fri 1h", "2026-10-03T12:10:00Z", false},
+	}
+	for _, tt := range tests {
+		w, err := parseWindow(tt.window)
+		require.NoError(t, err, tt.window)
+		assert.Equal(t, tt.active, w.activeAt(at(tt.time)), "%s at %s", tt.window, tt.time)
+	}
+
+	// the same as checking each minute in the window
+	for _, spec := range []string{
+		"0 0 1 jan * 744h",
+		"30 12 * * fri 168h",
+		"*/20 3-5 13 * mon 720h",
+		"59 23 29 feb * 744h",
+		"0 2 * * sun 2h",
+	} {
+		w, err := parseWindow(spec)
+		require.NoError(t, err, spec)
+		for ts := at("2025-12-01T00:00:00Z"); ts.Before(at("2026-03-01T00:00:00Z")); ts = ts.Add(11*time.Hour + 17*time.Minute) {
+			active := false
+			for start := ts; ts.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+				c := w.cron
+				if c.minute&(1<<start.Minute()) != 0 && c.hour&(1<<start.Hour()) != 0 && c.matchDay(start) {
+					active = true
+					break
+				}
+			}
+			require.Equal(t, active, w.activeAt(ts), "%s at %s", spec, ts)
+		}
+	}
+	w, err := parseWindow("0 12 1 jan * 744h")
+	require.NoError(t, err)
+	assert.True(t, w.activeAt(at("2026-02-01T11:59:00Z")))
+	assert.False(t, w.activeAt(at("2026-02-01T12:00:00Z")))
+	assert.False(t, w.activeAt(at("2026-01-01T11:59:00Z")))
+
+	for _, s := range []string{
+		"0 2 * * sun",
+		"0 2 * * sun 0s",
+		"0 2 * * sun 32d",
+		"0 24 * * * 1h",
+		"0 2 * * sunday 1h",
+		"5-1 * * * * 1h",
+		"*/0 * * * * 1h",
+	} {
+		_, err := parseWindow(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestScheduledRecords(t *testing.T) {
+	zone := NewZone("example.com")
+	require.NoError(t, zone.ReadZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "targeting": "@ continent",
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": {
+      "a": [
+        [ "192.0.2.1", 10 ],
+        { "ip": "192.0.2.2", "weight": 10, "inactive_during": "0 2 * * sun 2h" },
+        { "ip": "192.0.2.3", "weight": 10, "active_from": "2100-01-01T00:00:00Z" }
+      ]
+    },
+    "www.europe": {
+      "active_during": [ "0 2 * * sun 2h", "0 2 * * wed 2h" ],
+      "a": [ [ "192.0.2.10" ] ]
+    }
+  }
+}`)))
+
+	ips := func() []string {
+		ips := []string{}
+		for _, r := range zone.Picker(zone.Labels["www"], dns.TypeA, 10, nil) {
+			ips = append(ips, r.RR.(*dns.A).Addr.String())
+		}
+		return ips
+	}
+	labelFor := func(target string) string {
+		label := zone.findFirstLabel("www", []string{target, "@"}, []uint16{dns.TypeA})
+		require.NotNil(t, label)
+		return label.Label.Label
+	}
+
+	// 2026-10-18 is a Sunday
+	sunday, err := time.Parse(time.RFC3339, "2026-10-18T02:30:00Z")
+	require.NoError(t, err)
+
+	zone.updateSchedules(sunday.Add(-time.Hour))
+	assert.ElementsMatch(t, []string{"192.0.2.1", "192.0.2.2"}, ips())
+	assert.Equal(t, "www", labelFor("europe"), "the europe label is inactive")
+
+	assert.Equal(t, 2, zone.updateSchedules(sunday))
+	assert.ElementsMatch(t, []string{"192.0.2.1"}, ips())
+	assert.Equal(t, "www.europe", labelFor("europe"))
+	assert.Equal(t, 0, zone.updateSchedules(sunday.Add(time.Minute)))
+
+	assert.Equal(t, 2, zone.updateSchedules(sunday.Add(2*time.Hour)))
+	assert.ElementsMatch(t, []string{"192.0.2.1", "192.0.2.2"}, ips())
+
+	names := []string{}
+	for _, sc := range zone.schedules {
+		names = append(names, sc.name)
+	}
+	assert.ElementsMatch(t, []string{"www.europe", "www A 192.0.2.2", "www A 192.0.2.3"}, names)
+
+	// the zone file is checked
+	problems := NewZone("example.com").readZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": {
+      "active_from": "tomorrow",
+      "a": [ { "ip": "192.0.2.1", "active_during": [ "0 2 * * sun" ] } ]
+    },
+    "mail": {
+      "active_from": "2030-01-01T00:00:00Z",
+      "active_until": "2029-01-01T00:00:00Z",
+      "a": [ [ "192.0.2.2" ] ]
+    }
+  }
+}`), nil)
+	messages := []string{}
+	for _, p := range problems {
+		messages = append(messages, p.Path+": "+p.Message)
+	}
+	assert.Len(t, messages, 3, strings.Join(messages, "\n"))
+}
+
+func TestScheduleMasterFile(t *testing.T) {
+	objmap, _, err := readMasterFile(strings.NewReader(`$ORIGIN example.com.
+$TTL 300
+@    IN NS ns1.example.net.
+$GEO LABEL www active_until=2030-01-01T00:00:00Z
+www  IN A 192.0.2.1
+www  IN A 192.0.2.2 ; geo: inactive_during="0 2 * * sun 2h"
+`), "example.com", "example.com.zone")
+	require.NoError(t, err)
+
+	zone := NewZone("example.com")
+	c := &zoneCheck{}
+	setupZoneData(zoneDataMap(objmap), zone, c)
+	require.Empty(t, c.problems)
+
+	www := zone.Labels["www"]
+	require.NotNil(t, www.Schedule)
+	assert.Equal(t, 2030, www.Schedule.Until.Year())
+	for _, r := range www.Records[dns.TypeA] {
+		if r.RR.(*dns.A).Addr.String() == "192.0.2.2" {
+			require.NotNil(t, r.Schedule)
+			assert.Equal(t, "0 2 * * sun 2h", r.Schedule.Inactive[0].Spec)
+		} else {
+			assert.Nil(t, r.Schedule)
+		}
+	}
+}
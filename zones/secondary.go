@@ -0,0 +1,500 @@
+package zones
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Secondary zones are transferred (AXFR or IXFR) from a primary server
+// and refreshed on the SOA timers or when the primary sends a NOTIFY.
+// An overlay JSON zone file can add options and targeted labels.
+
+// SecondaryConfig configures a zone transferred from primary servers.
+type SecondaryConfig struct {
+	Primaries []netip.AddrPort
+
+	// TSIG key for the transfers and NOTIFY, if KeyName is set
+	KeyName   string
+	Algorithm string
+	Secret    []byte
+
+	Overlay string
+}
+
+const (
+	secondaryTimeout  = 10 * time.Second
+	secondaryMinWait  = 5 * time.Second
+	secondaryRetry    = time.Minute // until we have an SOA record
+	overlayCheckEvery = 2 * time.Second
+)
+
+// dnssecTypes aren't used from the transferred zones; geodns signs the
+// zone itself if it has keys.
+var dnssecTypes = []uint16{
+	dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM,
+	dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeZONEMD,
+}
+
+type secondary struct {
+	name   string
+	config SecondaryConfig
+	mm     *MuxManager
+	notify chan struct{}
+
+	// current version of the zone; rrs doesn't include the SOA record
+	soa     *dns.SOA
+	rrs     []dns.RR
+	expires time.Time
+
+	overlayTime time.Time
+}
+
+// Check checks the configuration for the secondary zone name.
+func (c SecondaryConfig) Check(name string) error {
+	if len(c.Primaries) == 0 {
+		return fmt.Errorf("secondary zone '%s' has no primary servers", name)
+	}
+	return nil
+}
+
+// addSecondary adds a zone to be transferred from the primaries, before
+// the zones from the sources are loaded. The zone is loaded by Run.
+func (mm *MuxManager) addSecondary(name string, config SecondaryConfig) error {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if err := config.Check(name); err != nil {
+		return err
+	}
+	if _, ok := mm.secondaries[name]; ok {
+		return fmt.Errorf("secondary zone '%s' is already configured", name)
+	}
+	mm.secondaries[name] = &secondary{
+		name:   name,
+		config: config,
+		mm:     mm,
+		notify: make(chan struct{}, 1),
+	}
+	return nil
+}
+
+func (s *secondary) run(ctx context.Context) {
+	wait := time.Duration(0)
+	for {
+		timer := time.NewTimer(wait)
+		overlay := time.NewTicker(overlayCheckEvery)
+
+	waiting:
+		for {
+			select {
+			case <-timer.C:
+				break waiting
+			case <-s.notify:
+				log.Printf("[zone %s] NOTIFY received, checking the primary", s.name)
+				break waiting
+			case <-overlay.C:
+				if s.soa != nil && s.overlayChanged() {
+					log.Printf("[zone %s] overlay changed, reloading", s.name)
+					s.load()
+				}
+			case <-ctx.Done():
+				timer.Stop()
+				overlay.Stop()
+				return
+			}
+		}
+		timer.Stop()
+		overlay.Stop()
+
+		wait = s.refresh(ctx)
+	}
+}
+
+// refresh checks the primaries for a new version of the zone, and returns
+// how long to wait before checking again.
+func (s *secondary) refresh(ctx context.Context) time.Duration {
+	var err error
+	for _, primary := range s.config.Primaries {
+		var changed bool
+		changed, err = s.transfer(ctx, primary)
+		if err != nil {
+			log.Printf("[zone %s] refresh from %s failed: %s", s.name, primary, err)
+			continue
+		}
+		if changed {
+			s.load()
+		}
+		s.expires = time.Now().Add(time.Duration(s.soa.Expire) * time.Second)
+		return max(time.Duration(s.soa.Refresh)*time.Second, secondaryMinWait)
+	}
+
+	if s.soa == nil {
+		return secondaryRetry
+	}
+	if time.Now().After(s.expires) {
+		log.Printf("[zone %s] no successful refresh before the SOA expire time, removing the zone", s.name)
+		s.soa, s.rrs = nil, nil
+		s.mm.removeSecondary(s.name)
+		return secondaryRetry
+	}
+	return max(time.Duration(s.soa.Retry)*time.Second, secondaryMinWait)
+}
+
+func (s *secondary) sign(m *dns.Msg) error {
+	if len(s.config.KeyName) == 0 {
+		return nil
+	}
+	m.Pseudo = append(m.Pseudo, dns.NewTSIG(dnsutil.Fqdn(s.config.KeyName), s.config.Algorithm, 0))
+	return dns.TSIGSign(m, dns.HmacTSIG{Secret: s.config.Secret}, &dns.TSIGOption{})
+}
+
+// transfer gets the zone from the primary if it has a newer serial.
+func (s *secondary) transfer(ctx context.Context, primary netip.AddrPort) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, secondaryTimeout)
+	defer cancel()
+
+	origin := dnsutil.Fqdn(s.name)
+
+	m := dns.NewMsg(origin, dns.TypeSOA)
+	m.RecursionDesired = false
+	if err := s.sign(m); err != nil {
+		return false, err
+	}
+	c := dns.NewClient()
+	r, _, err := c.Exchange(ctx, m, "udp", primary.String())
+	if err != nil {
+		return false, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return false, fmt.Errorf("SOA query: %s", dnsutil.RcodeToString(r.Rcode))
+	}
+	var soa *dns.SOA
+	for _, rr := range r.Answer {
+		if rr, ok := rr.(*dns.SOA); ok {
+			soa = rr
+		}
+	}
+	if soa == nil {
+		return false, errors.New("no SOA record in the response")
+	}
+	if s.soa != nil && !SerialNewer(soa.Serial, s.soa.Serial) {
+		return false, nil
+	}
+
+	if s.soa == nil {
+		m = dns.NewMsg(origin, dns.TypeAXFR)
+	} else {
+		m = dns.NewMsg(origin, dns.TypeIXFR)
+		m.Ns = []dns.RR{s.soa}
+	}
+	m.RecursionDesired = false
+	c = dns.NewClient()
+	if len(s.config.KeyName) > 0 {
+		m.Pseudo = []dns.RR{dns.NewTSIG(dnsutil.Fqdn(s.config.KeyName), s.config.Algorithm, 0)}
+		c.Transfer = &dns.Transfer{TSIGSigner: dns.HmacTSIG{Secret: s.config.Secret}}
+	}
+	env, err := c.TransferIn(ctx, m, "tcp", primary.String())
+	if err != nil {
+		return false, err
+	}
+	rrs := []dns.RR{}
+	for e := range env {
+		if e.Error != nil {
+			return false, e.Error
+		}
+		rrs = append(rrs, e.Answer...)
+	}
+
+	var serial uint32
+	if s.soa != nil {
+		serial = s.soa.Serial
+	}
+	newSOA, newRRs, err := applyTransfer(s.rrs, serial, rrs)
+	if err != nil {
+		return false, err
+	}
+	if newSOA == nil {
+		if s.soa == nil {
+			return false, errors.New("no zone in the AXFR response")
+		}
+		// up to date after all
+		return false, nil
+	}
+
+	log.Printf("[zone %s] transferred serial %d from %s (%d records)", s.name, newSOA.Serial, primary, len(newRRs))
+	s.soa, s.rrs = newSOA, newRRs
+	return true, nil
+}
+
+// applyTransfer returns the zone from the AXFR or IXFR response rrs;
+// current are the records we have for serial. The SOA is nil if the
+// response says we are up to date.
+func applyTransfer(current []dns.RR, serial uint32, rrs []dns.RR) (*dns.SOA, []dns.RR, error) {
+	if len(rrs) == 0 {
+		return nil, nil, errors.New("empty transfer")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, nil, errors.New("transfer doesn't start with an SOA record")
+	}
+	if len(rrs) == 1 {
+		if SerialNewer(soa.Serial, serial) {
+			return nil, nil, errors.New("incomplete transfer")
+		}
+		return nil, nil, nil
+	}
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		return nil, nil, errors.New("transfer doesn't end with the SOA record")
+	}
+
+	if old, ok := rrs[1].(*dns.SOA); !ok || old.Serial != serial {
+		// AXFR, or IXFR with the full zone
+		return soa, slices.Clone(rrs[1 : len(rrs)-1]), nil
+	}
+
+	// the IXFR differences: the old SOA and the records deleted, then
+	// the new SOA and the records added, for each version in between
+	key := func(rr dns.RR) string {
+		rr = rr.Clone()
+		rr.Header().TTL = 0
+		return strings.ToLower(rr.String())
+	}
+	deleted := map[string]bool{}
+	added := map[string]dns.RR{}
+	order := []string{}
+
+	i := 1
+	for i < len(rrs)-1 {
+		if _, ok := rrs[i].(*dns.SOA); !ok {
+			return nil, nil, errors.New("invalid IXFR response")
+		}
+		for i++; i < len(rrs)-1; i++ {
+			if _, ok := rrs[i].(*dns.SOA); ok {
+				break
+			}
+			k := key(rrs[i])
+			deleted[k] = true
+			delete(added, k)
+		}
+		if i >= len(rrs)-1 {
+			return nil, nil, errors.New("invalid IXFR response")
+		}
+		for i++; i < len(rrs); i++ {
+			if _, ok := rrs[i].(*dns.SOA); ok {
+				break
+			}
+			k := key(rrs[i])
+			delete(deleted, k)
+			if _, ok := added[k]; !ok {
+				order = append(order, k)
+			}
+			added[k] = rrs[i]
+		}
+	}
+
+	// the added records replace the current ones, for the new TTLs
+	result := []dns.RR{}
+	seen := map[string]bool{}
+	for _, rr := range current {
+		k := key(rr)
+		if _, ok := added[k]; !ok && !deleted[k] && !seen[k] {
+			seen[k] = true
+			result = append(result, rr)
+		}
+	}
+	for _, k := range order {
+		if rr, ok := added[k]; ok && !seen[k] {
+			seen[k] = true
+			result = append(result, rr)
+		}
+	}
+	return soa, result, nil
+}
+
+// SerialNewer checks if serial a is newer than b, with RFC 1982 serial
+// number arithmetic.
+func SerialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+func (s *secondary) overlayChanged() bool {
+	if len(s.config.Overlay) == 0 {
+		return false
+	}
+	fi, err := os.Stat(s.config.Overlay)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(s.overlayTime)
+}
+
+// load sets up the zone from the transferred records and registers it.
+func (s *secondary) load() {
+	if len(s.config.Overlay) > 0 {
+		if fi, err := os.Stat(s.config.Overlay); err == nil {
+			s.overlayTime = fi.ModTime()
+		}
+	}
+
+	zone := NewZone(s.name)
+	rrs := append([]dns.RR{s.soa}, s.rrs...)
+	if err := zone.ReadTransfer(rrs, s.config.Overlay); err != nil {
+		log.Printf("[zone %s] could not load the transferred zone: %s", s.name, err)
+		return
+	}
+	zone.secondary = s
+	s.mm.addHandler(s.name, zone)
+}
+
+// isPrimary checks if ip is one of the primary servers.
+func (s *secondary) isPrimary(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return slices.ContainsFunc(s.config.Primaries, func(p netip.AddrPort) bool {
+		return p.Addr().Unmap() == ip
+	})
+}
+
+// Notify handles a NOTIFY for the zone from remote. It returns the rcode
+// for the response and, if the NOTIFY was signed, the TSIG signer for it.
+func (z *Zone) Notify(req *dns.Msg, remote netip.Addr) (uint16, dns.TSIGSigner) {
+	s := z.secondary
+	if s == nil || !s.isPrimary(remote) {
+		return dns.RcodeRefused, nil
+	}
+
+	var signer dns.TSIGSigner
+	if len(s.config.KeyName) > 0 {
+		var t *dns.TSIG
+		if len(req.Pseudo) > 0 {
+			t, _ = req.Pseudo[len(req.Pseudo)-1].(*dns.TSIG)
+		}
+		if t == nil || !strings.EqualFold(t.Hdr.Name, dnsutil.Fqdn(s.config.KeyName)) {
+			return dns.RcodeNotAuth, nil
+		}
+		signer = dns.HmacTSIG{Secret: s.config.Secret}
+		if err := dns.TSIGVerify(req, signer, &dns.TSIGOption{}); err != nil {
+			log.Printf("[zone %s] NOTIFY from %s: %s", z.Origin, remote, err)
+			return dns.RcodeNotAuth, nil
+		}
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+	return dns.RcodeSuccess, signer
+}
+
+// ReadTransfer sets up the zone from transferred records, starting with
+// the SOA, and the overlay zone file if it's set. Like ReadZoneFile
+// errors are returned as ZoneErrors.
+func (zone *Zone) ReadTransfer(rrs []dns.RR, overlay string) error {
+	problems, err := zone.readTransfer(rrs, overlay)
+	if err != nil {
+		return err
+	}
+	if problems.HasErrors() {
+		return problems
+	}
+	for _, p := range problems {
+		log.Println(p)
+	}
+	zone.setupTargeting()
+	return nil
+}
+
+// readTransfer sets up the zone like ReadTransfer and returns the
+// problems found, the error is only for an overlay that can't be read.
+func (zone *Zone) readTransfer(rrs []dns.RR, overlay string) (ZoneErrors, error) {
+	c := &zoneCheck{file: zone.Origin + " (transfer)"}
+
+	mr := newMasterFileReader(zone.Origin, c.file)
+
+	for _, rr := range rrs {
+		if slices.Contains(dnssecTypes, dns.RRToType(rr)) {
+			continue
+		}
+		if err := mr.addRR(rr, nil); err != nil {
+			c.warnf("", "skipping '%s': %s", rr, err)
+		}
+	}
+	objmap := mr.objmap
+
+	if len(overlay) > 0 {
+		data, err := os.ReadFile(overlay)
+		if err != nil {
+			return nil, err
+		}
+		var overlayMap map[string]interface{}
+		if err := json.Unmarshal(data, &overlayMap); err != nil {
+			return ZoneErrors{{File: overlay, Severity: SeverityError, Message: fmt.Sprintf("error parsing JSON: %s", err)}}, nil
+		}
+		mergeOverlay(objmap, overlayMap)
+
+		// problems are mostly in the overlay
+		c.file = overlay
+		c.positions = jsonPositions(data)
+	}
+
+	zone.setupZone(objmap, c)
+
+	if len(overlay) > 0 {
+		if err := zone.setupDNSSEC(filepath.Dir(overlay)); err != nil {
+			c.errorf("", "loading DNSSEC keys: %s", err)
+		}
+	}
+	return c.problems, nil
+}
+
+// mergeOverlay adds the options and data from the overlay zone to the
+// transferred zone. Record types in the overlay replace the transferred
+// records for the label.
+func mergeOverlay(objmap, overlay map[string]interface{}) {
+	for k, v := range overlay {
+		switch k {
+		case "serial":
+			// the serial is from the primary
+		case "data":
+			data, _ := objmap["data"].(map[string]interface{})
+			overlayData, ok := v.(map[string]interface{})
+			if data == nil || !ok {
+				objmap[k] = v
+				continue
+			}
+			for name, label := range overlayData {
+				current, ok := data[name].(map[string]interface{})
+				overlayLabel, lok := label.(map[string]interface{})
+				if !ok || !lok {
+					data[name] = label
+					continue
+				}
+				for t, records := range overlayLabel {
+					current[t] = records
+				}
+			}
+		default:
+			objmap[k] = v
+		}
+	}
+}
+
+func (mm *MuxManager) removeSecondary(name string) {
+	mm.zonesMu.Lock()
+	zone, ok := mm.zonelist[name]
+	mm.zonesMu.Unlock()
+	if ok {
+		zone.Close()
+		mm.removeHandler(name)
+	}
+}
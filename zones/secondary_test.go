@@ -0,0 +1,299 @@
+package zones
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+func testRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.New(s)
+	require.NoError(t, err)
+	return rr
+}
+
+func testSOA(t *testing.T, serial string) dns.RR {
+	return testRR(t, "example.org. 3600 IN SOA ns1.example.org. hostmaster.example.org. "+serial+" 3600 600 86400 300")
+}
+
+func TestApplyTransfer(t *testing.T) {
+	a1 := testRR(t, "www.example.org. 300 IN A 192.0.2.1")
+	a2 := testRR(t, "www.example.org. 300 IN A 192.0.2.2")
+	a3 := testRR(t, "www.example.org. 300 IN A 192.0.2.3")
+
+	// AXFR
+	soa, rrs, err := applyTransfer(nil, 0, []dns.RR{testSOA(t, "1"), a1, a2, testSOA(t, "1")})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), soa.Serial)
+	assert.Len(t, rrs, 2)
+
+	// up to date
+	soa, _, err = applyTransfer(rrs, 1, []dns.RR{testSOA(t, "1")})
+	require.NoError(t, err)
+	assert.Nil(t, soa)
+
+	// IXFR from 1 to 3, through 2
+	soa, rrs, err = applyTransfer(rrs, 1, []dns.RR{
+		testSOA(t, "3"),
+		testSOA(t, "1"), a1, testSOA(t, "2"), a3,
+		testSOA(t, "2"), testRR(t, "www.example.org. 600 IN A 192.0.2.2"), testSOA(t, "3"),
+		testSOA(t, "3"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), soa.Serial)
+	if assert.Len(t, rrs, 1) {
+		assert.Equal(t, "192.0.2.3", rrs[0].(*dns.A).Addr.String())
+	}
+
+	// a record with a new TTL
+	soa, rrs, err = applyTransfer(rrs, 3, []dns.RR{
+		testSOA(t, "4"),
+		testSOA(t, "3"), a3, testSOA(t, "4"), testRR(t, "www.example.org. 60 IN A 192.0.2.3"),
+		testSOA(t, "4"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), soa.Serial)
+	if assert.Len(t, rrs, 1) {
+		assert.Equal(t, uint32(60), rrs[0].Header().TTL)
+	}
+
+	_, _, err = applyTransfer(rrs, 4, []dns.RR{testSOA(t, "5"), a1})
+	assert.Error(t, err, "no SOA at the end")
+
+	assert.True(t, SerialNewer(2, 1))
+	assert.True(t, SerialNewer(1, 0xffffffff))
+	assert.False(t, SerialNewer(1, 1))
+	assert.False(t, SerialNewer(0xffffffff, 1))
+}
+
+type testReg struct {
+	mu    sync.Mutex
+	zones map[string]*Zone
+}
+
+func (r *testReg) Add(name string, z *Zone) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.zones[name] = z
+}
+
+func (r *testReg) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.zones, name)
+}
+
+func (r *testReg) get(name string) *Zone {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.zones[name]
+}
+
+// testPrimary starts a DNS server with the handler on UDP and TCP.
+func testPrimary(t *testing.T, handler dns.Handler) netip.AddrPort {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	require.NoError(t, err)
+
+	for _, srv := range []*dns.Server{
+		{Listener: l, Net: "tcp", Handler: handler},
+		{PacketConn: pc, Net: "udp", Handler: handler},
+	} {
+		go srv.ListenAndServe()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	}
+	return netip.MustParseAddrPort(l.Addr().String())
+}
+
+func TestSecondary(t *testing.T) {
+	secret := []byte("secondary secret")
+	signer := dns.HmacTSIG{Secret: secret}
+
+	var mu sync.Mutex
+	serial := "1"
+	zone := func() []dns.RR {
+		mu.Lock()
+		defer mu.Unlock()
+		rrs := []dns.RR{
+			testSOA(t, serial),
+			testRR(t, "example.org. 3600 IN NS ns1.example.org."),
+			testRR(t, "ns1.example.org. 3600 IN A 192.0.2.53"),
+			testRR(t, "www.example.org. 300 IN A 192.0.2.1"),
+			testRR(t, "www.example.org. 600 IN AAAA 2001:db8::1"),
+			testRR(t, "example.org. 3600 IN CAA 0 issue \"example.net\""),
+		}
+		if serial == "2" {
+			rrs = append(rrs, testRR(t, "new.example.org. 300 IN A 192.0.2.2"))
+		}
+		return append(rrs, rrs[0])
+	}
+
+	handler := dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		if err := r.Unpack(); err != nil {
+			return
+		}
+		m := new(dns.Msg)
+		dnsutil.SetReply(m, r)
+		if err := dns.TSIGVerify(r, signer, &dns.TSIGOption{}); err != nil {
+			t.Logf("primary: %s", err)
+			m.Rcode = dns.RcodeNotAuth
+			m.WriteTo(w)
+			return
+		}
+
+		switch dns.RRToType(r.Question[0]) {
+		case dns.TypeSOA:
+			m.Answer = zone()[:1]
+			m.WriteTo(w)
+		case dns.TypeAXFR, dns.TypeIXFR:
+			// IXFR is answered with the whole zone
+			env := make(chan *dns.Envelope, 1)
+			env <- &dns.Envelope{Answer: zone()}
+			close(env)
+			w.Hijack()
+			defer w.Close()
+			c := dns.NewClient()
+			c.Transfer = &dns.Transfer{TSIGSigner: signer}
+			if err := c.TransferOut(w, r, env); err != nil {
+				t.Logf("primary transfer: %s", err)
+			}
+		}
+	})
+
+	primary := testPrimary(t, handler)
+
+	dir := t.TempDir()
+	overlay := filepath.Join(dir, "example.org.overlay")
+	require.NoError(t, os.WriteFile(overlay, []byte(`{
+  "serial": 100,
+  "targeting": "country continent @",
+  "data": {
+    "www.europe": { "a": [ [ "192.0.2.100", 10 ] ] },
+    "www": { "a": [ [ "192.0.2.10", 10 ], [ "192.0.2.11", 10 ] ] }
+  }
+}`), 0644))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManagerOptions(reg, MuxOptions{
+		Sources: []ZoneSource{&DirSource{Dir: dir}},
+		Secondaries: map[string]SecondaryConfig{"example.org": {
+			Primaries: []netip.AddrPort{primary},
+			KeyName:   "secondary",
+			Algorithm: dns.HmacSHA256,
+			Secret:    secret,
+			Overlay:   overlay,
+		}},
+	})
+	require.NoError(t, err)
+
+	_, err = NewMuxManagerOptions(reg, MuxOptions{Secondaries: map[string]SecondaryConfig{
+		"example.org":  {Primaries: []netip.AddrPort{primary}},
+		"example.org.": {Primaries: []netip.AddrPort{primary}},
+	}})
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mm.Run(ctx)
+
+	require.Eventually(t, func() bool { return reg.get("example.org") != nil }, 5*time.Second, 10*time.Millisecond)
+	z := reg.get("example.org")
+
+	soa := z.SoaRR().(*dns.SOA)
+	assert.Equal(t, uint32(1), soa.Serial, "serial from the primary")
+	assert.Equal(t, "hostmaster.example.org.", soa.Mbox)
+	assert.NotNil(t, z.Labels["ns1"])
+	assert.Len(t, z.Labels["www"].Records[dns.TypeA], 2, "overlay replaces the A records")
+	assert.Len(t, z.Labels["www"].Records[dns.TypeAAAA], 1)
+	assert.Equal(t, 300, z.Labels["www"].Ttl, "TTL of the first record for the label")
+	assert.Equal(t, uint32(600), z.Labels["www"].Records[dns.TypeAAAA][0].RR.Header().TTL, "records keep their TTL")
+	assert.NotNil(t, z.Labels["www.europe"])
+	assert.Nil(t, z.Labels[""].Records[dns.TypeCAA], "unsupported types are skipped")
+
+	// NOTIFY from the primary
+	mu.Lock()
+	serial = "2"
+	mu.Unlock()
+
+	notify := dns.NewMsg("example.org.", dns.TypeSOA)
+	notify.Opcode = dns.OpcodeNotify
+	notify.Pseudo = []dns.RR{dns.NewTSIG("secondary.", dns.HmacSHA256, 0)}
+	require.NoError(t, dns.TSIGSign(notify, signer, &dns.TSIGOption{}))
+
+	rcode, _ := z.Notify(notify, netip.MustParseAddr("192.0.2.1"))
+	assert.Equal(t, uint16(dns.RcodeRefused), rcode, "NOTIFY from another server")
+
+	unsigned := dns.NewMsg("example.org.", dns.TypeSOA)
+	unsigned.Opcode = dns.OpcodeNotify
+	rcode, _ = z.Notify(unsigned, primary.Addr())
+	assert.Equal(t, uint16(dns.RcodeNotAuth), rcode, "unsigned NOTIFY")
+
+	rcode, s := z.Notify(notify, primary.Addr())
+	assert.Equal(t, uint16(dns.RcodeSuccess), rcode)
+	assert.NotNil(t, s)
+
+	require.Eventually(t, func() bool {
+		return reg.get("example.org").SoaRR().(*dns.SOA).Serial == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	z = reg.get("example.org")
+	assert.NotNil(t, z.Labels["new"])
+	assert.NotNil(t, z.Labels["www.europe"], "overlay is still applied")
+}
+
+func TestSecondaryZoneFile(t *testing.T) {
+	// the primary answers the AXFR with only the SOA record
+	handler := dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		if err := r.Unpack(); err != nil {
+			return
+		}
+		m := new(dns.Msg)
+		dnsutil.SetReply(m, r)
+		switch dns.RRToType(r.Question[0]) {
+		case dns.TypeSOA:
+			m.Answer = []dns.RR{testSOA(t, "0")}
+			m.WriteTo(w)
+		case dns.TypeAXFR:
+			env := make(chan *dns.Envelope, 1)
+			env <- &dns.Envelope{Answer: []dns.RR{testSOA(t, "0")}}
+			close(env)
+			w.Hijack()
+			defer w.Close()
+			if err := dns.NewClient().TransferOut(w, r, env); err != nil {
+				t.Logf("primary transfer: %s", err)
+			}
+		}
+	})
+	primary := testPrimary(t, handler)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.org.json"), []byte(testZoneJSON("192.0.2.1")), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com.json"), []byte(testZoneJSON("192.0.2.2")), 0644))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManagerOptions(reg, MuxOptions{
+		Sources:     []ZoneSource{&DirSource{Dir: dir}},
+		Secondaries: map[string]SecondaryConfig{"example.org": {Primaries: []netip.AddrPort{primary}}},
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, reg.get("example.com"))
+	assert.Nil(t, reg.get("example.org"), "the zone file for the secondary zone isn't used")
+
+	// a transfer without the zone is an error, not an empty zone
+	s := mm.secondaries["example.org"]
+	assert.Equal(t, secondaryRetry, s.refresh(context.Background()))
+	assert.Nil(t, s.soa)
+	assert.Nil(t, reg.get("example.org"))
+}
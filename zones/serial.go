@@ -0,0 +1,175 @@
+package zones
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+// SerialPolicy decides the SOA serial of a zone.
+type SerialPolicy string
+
+const (
+	// SerialFromFile uses the serial from the zone file, or the
+	// modification time of the file if it doesn't have one.
+	SerialFromFile SerialPolicy = "file"
+
+	// SerialUnixTime uses the time the contents changed.
+	SerialUnixTime SerialPolicy = "unixtime"
+
+	// SerialDateCounter uses YYYYMMDDnn, counting up the changes in a day.
+	SerialDateCounter SerialPolicy = "date-counter"
+
+	// SerialContentHash adds one each time the contents change.
+	SerialContentHash SerialPolicy = "content-hash"
+)
+
+// ParseSerialPolicy checks the name of a serial policy.
+func ParseSerialPolicy(s string) (SerialPolicy, error) {
+	switch p := SerialPolicy(strings.ToLower(s)); p {
+	case SerialFromFile, SerialUnixTime, SerialDateCounter, SerialContentHash:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown serial policy '%s'", s)
+}
+
+// SerialState has the last serial and content hash for the zones with a
+// serial policy (other than "file"), so the serials only ever increase.
+// It's saved to a file to keep them across restarts.
+type SerialState struct {
+	path string
+
+	mu    sync.Mutex
+	zones map[string]serialRecord
+	dirty bool
+}
+
+type serialRecord struct {
+	Serial uint32 `json:"serial"`
+	Hash   string `json:"hash"`
+}
+
+// NewSerialState reads the serials from the state file if it exists. With
+// an empty path the serials are only kept in memory.
+func NewSerialState(path string) (*SerialState, error) {
+	s := &SerialState{path: path, zones: map[string]serialRecord{}}
+	if len(path) == 0 {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.zones); err != nil {
+		return nil, fmt.Errorf("reading serial state %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// serial returns the serial for the zone contents with the hash. The
+// serial the zone would have without a policy is used for zones not in
+// the state yet (or if it's newer).
+func (s *SerialState) serial(name string, policy SerialPolicy, hash string, current uint32, now time.Time) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.zones[name]
+	if ok && last.Hash == hash {
+		return last.Serial
+	}
+
+	var serial uint32
+	switch policy {
+	case SerialUnixTime:
+		serial = uint32(now.Unix())
+	case SerialDateCounter:
+		now = now.UTC()
+		serial = uint32(now.Year()*1000000 + int(now.Month())*10000 + now.Day()*100)
+	case SerialContentHash:
+		serial = last.Serial
+	}
+
+	if !ok && !SerialNewer(serial, current) {
+		serial = current
+	}
+	if ok && !SerialNewer(serial, last.Serial) {
+		serial = last.Serial + 1
+	}
+
+	s.zones[name] = serialRecord{Serial: serial, Hash: hash}
+	s.dirty = true
+	return serial
+}
+
+// Save writes the state file if the serials changed.
+func (s *SerialState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty || len(s.path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.zones, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("saving serial state: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving serial state: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving serial state: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("saving serial state: %s", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// setSerial changes the serial of a zone that hasn't been used yet.
+func (zone *Zone) setSerial(serial uint32) {
+	zone.Options.Serial = int(serial)
+	if soa, ok := zone.SoaRR().(*dns.SOA); ok {
+		soa.Serial = serial
+	}
+}
+
+// applySerialPolicy sets the serial of a new version of the zone, if it
+// has a serial policy. The serials of reverse zones with generated
+// records change with the records, as with the content-hash policy, if
+// they don't have another one.
+func (mm *MuxManager) applySerialPolicy(zone *Zone, hash string) {
+	policy := zone.Options.SerialPolicy
+	if len(policy) == 0 {
+		policy = mm.serialPolicy
+	}
+	if zone.reverse != nil {
+		hash = zone.reverse.hash
+		if len(policy) == 0 || policy == SerialFromFile {
+			policy = SerialContentHash
+		}
+	}
+	if len(policy) == 0 || policy == SerialFromFile {
+		return
+	}
+	serial := mm.serials.serial(zone.Origin, policy, hash, uint32(zone.Options.Serial), time.Now())
+	zone.setSerial(serial)
+}
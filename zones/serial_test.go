@@ -0,0 +1,90 @@
+package zones
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestSerialPolicies(t *testing.T) {
+	day := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewSerialState("")
+	require.NoError(t, err)
+
+	// the serial the zone had without the policy is the starting point
+	assert.Equal(t, uint32(1000), s.serial("hash.example", SerialContentHash, "a", 1000, day))
+	assert.Equal(t, uint32(1000), s.serial("hash.example", SerialContentHash, "a", 5, day), "unchanged")
+	assert.Equal(t, uint32(1001), s.serial("hash.example", SerialContentHash, "b", 5, day))
+	assert.Equal(t, uint32(1002), s.serial("hash.example", SerialContentHash, "a", 5, day), "old contents get a new serial")
+
+	assert.Equal(t, uint32(2026101700), s.serial("date.example", SerialDateCounter, "a", 1, day))
+	assert.Equal(t, uint32(2026101701), s.serial("date.example", SerialDateCounter, "b", 1, day))
+	assert.Equal(t, uint32(2026101800), s.serial("date.example", SerialDateCounter, "c", 1, day.Add(24*time.Hour)))
+	assert.Equal(t, uint32(2026101801), s.serial("date.example", SerialDateCounter, "d", 1, day), "clock going back")
+	assert.Equal(t, uint32(2026101705), s.serial("date2.example", SerialDateCounter, "a", 2026101705, day), "newer serial in the file")
+
+	unix := uint32(day.Unix())
+	assert.Equal(t, unix, s.serial("unix.example", SerialUnixTime, "a", 1, day))
+	assert.Equal(t, unix, s.serial("unix.example", SerialUnixTime, "a", 1, day.Add(time.Hour)), "unchanged")
+	assert.Equal(t, unix+1, s.serial("unix.example", SerialUnixTime, "b", 1, day), "same second")
+
+	_, err = ParseSerialPolicy("Content-Hash")
+	assert.NoError(t, err)
+	_, err = ParseSerialPolicy("mtime")
+	assert.Error(t, err)
+}
+
+func TestSerialState(t *testing.T) {
+	dir := t.TempDir()
+	zoneDir := filepath.Join(dir, "zones")
+	require.NoError(t, os.Mkdir(zoneDir, 0755))
+	statePath := filepath.Join(dir, "serials.json")
+	fileName := filepath.Join(zoneDir, "example.com.json")
+
+	write := func(ip string, mtime time.Time) {
+		data := `{ "serial_policy": "content-hash", "data": { "": { "ns": [ "ns1.example.net" ] }, "www": { "a": [ [ "` + ip + `" ] ] } } }`
+		require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(fileName, mtime, mtime))
+	}
+	load := func() (*MuxManager, *testReg) {
+		serials, err := NewSerialState(statePath)
+		require.NoError(t, err)
+		reg := &testReg{zones: map[string]*Zone{}}
+		mm, err := NewMuxManagerOptions(reg, MuxOptions{
+			Sources: []ZoneSource{&DirSource{Dir: zoneDir}},
+			Serials: serials,
+		})
+		require.NoError(t, err)
+		return mm, reg
+	}
+	serial := func(reg *testReg) uint32 {
+		return reg.get("example.com").SoaRR().(*dns.SOA).Serial
+	}
+
+	mtime := time.Unix(1700000000, 0)
+	write("192.0.2.1", mtime)
+	mm, reg := load()
+	assert.Equal(t, uint32(1700000000), serial(reg))
+	assert.FileExists(t, statePath)
+
+	// restored from an older backup
+	write("192.0.2.2", mtime.Add(-time.Hour))
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Equal(t, uint32(1700000001), serial(reg))
+
+	// restarted with the same contents, and then with new contents
+	_, reg = load()
+	assert.Equal(t, uint32(1700000001), serial(reg))
+
+	write("192.0.2.3", mtime.Add(-2*time.Hour))
+	_, reg = load()
+	assert.Equal(t, uint32(1700000002), serial(reg))
+}
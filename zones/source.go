@@ -0,0 +1,317 @@
+package zones
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abh/geodns/v3/dirwatch"
+)
+
+// ZoneSource provides the zone files for the MuxManager.
+type ZoneSource interface {
+	// Zones lists the zone files available from the source.
+	Zones(ctx context.Context) ([]ZoneFile, error)
+
+	// ReadZone returns the contents of a zone file from the last list.
+	ReadZone(ctx context.Context, zf ZoneFile) ([]byte, error)
+
+	String() string
+}
+
+// ZoneWatcher is implemented by sources that can tell when their zones
+// might have changed; they are otherwise only checked every few minutes.
+type ZoneWatcher interface {
+	// Watch calls changed when the zones should be reloaded, until the
+	// context is done.
+	Watch(ctx context.Context, changed func())
+}
+
+// AtomicSource is implemented by sources where the zones are a set that's
+// only used if all of them can be loaded, and then applied all at once.
+type AtomicSource interface {
+	AtomicReload() bool
+}
+
+func isAtomic(source ZoneSource) bool {
+	a, ok := source.(AtomicSource)
+	return ok && a.AtomicReload()
+}
+
+// ZoneFile is a zone in a ZoneSource. The zone is read again when the
+// Version changes (and the contents are different).
+type ZoneFile struct {
+	Name     string
+	FileName string // the extension tells the format, .json or .zone
+	Version  string
+	ModTime  time.Time // used as the default serial
+
+	// KeyDir is the directory with the DNSSEC keys for the zone, if any
+	KeyDir string
+}
+
+// zoneFileName returns the zone name for a zone file, or false if it
+// isn't a zone file.
+func zoneFileName(fileName string) (string, bool) {
+	base := path.Base(fileName)
+	if !isZoneFile(base) || strings.HasPrefix(base, ".") {
+		return "", false
+	}
+	return base[0:strings.LastIndex(base, ".")], true
+}
+
+// DirSource reads the zone files in a directory.
+type DirSource struct {
+	Dir string
+
+	// Atomic applies the zones in the directory together. Dir can be a
+	// symlink to a generation directory which is switched to a new one
+	// for each update.
+	Atomic bool
+}
+
+func (s *DirSource) AtomicReload() bool {
+	return s.Atomic
+}
+
+func (s *DirSource) Zones(ctx context.Context) ([]ZoneFile, error) {
+	path := s.Dir
+	if s.Atomic {
+		// read all the files from the same generation
+		var err error
+		path, err = filepath.EvalSymlinks(s.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("could not read '%s': %s", s.Dir, err)
+		}
+	}
+
+	dir, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read '%s': %s", s.Dir, err)
+	}
+
+	files := []ZoneFile{}
+	for _, file := range dir {
+		name, ok := zoneFileName(file.Name())
+		if !ok || file.IsDir() {
+			continue
+		}
+		fileInfo, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirZoneFile(name, path, fileInfo))
+	}
+	return files, nil
+}
+
+func dirZoneFile(name, dir string, fileInfo fs.FileInfo) ZoneFile {
+	return ZoneFile{
+		Name:     name,
+		FileName: filepath.Join(dir, fileInfo.Name()),
+		Version:  fmt.Sprintf("%s %d %s", fileInfo.ModTime(), fileInfo.Size(), fileID(fileInfo)),
+		ModTime:  fileInfo.ModTime(),
+		KeyDir:   dir,
+	}
+}
+
+func (s *DirSource) ReadZone(ctx context.Context, zf ZoneFile) ([]byte, error) {
+	return os.ReadFile(zf.FileName)
+}
+
+// Watch uses filesystem events for the zone files in the directory.
+func (s *DirSource) Watch(ctx context.Context, changed func()) {
+	if s.Atomic {
+		// a new generation replaces the symlink in the parent directory
+		dir := filepath.Clean(s.Dir)
+		parent := &dirwatch.Dir{
+			Path:  filepath.Dir(dir),
+			Match: func(name string) bool { return name == filepath.Base(dir) },
+		}
+		go parent.Run(ctx, changed)
+	}
+	d := &dirwatch.Dir{
+		Path: s.Dir,
+		Match: func(name string) bool {
+			_, ok := zoneFileName(name)
+			return ok
+		},
+	}
+	d.Run(ctx, changed)
+}
+
+func (s *DirSource) String() string {
+	return s.Dir
+}
+
+// HTTPSource polls a URL for a tar archive (optionally gzip compressed)
+// with the zone files. The archive is only downloaded again if the server
+// says it changed (with the ETag or Last-Modified headers).
+type HTTPSource struct {
+	URL      string
+	Interval time.Duration // how often to poll, default 30 seconds
+	Client   *http.Client  // default with a one minute timeout
+	Atomic   bool          // apply the zones in an archive together
+
+	mu           sync.Mutex
+	checked      time.Time
+	etag         string
+	lastModified string
+	files        []ZoneFile
+	data         map[string][]byte
+}
+
+func (s *HTTPSource) AtomicReload() bool {
+	return s.Atomic
+}
+
+func (s *HTTPSource) String() string {
+	return s.URL
+}
+
+func (s *HTTPSource) interval() time.Duration {
+	if s.Interval == 0 {
+		return 30 * time.Second
+	}
+	return s.Interval
+}
+
+// Watch asks for a reload every Interval.
+func (s *HTTPSource) Watch(ctx context.Context, changed func()) {
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *HTTPSource) Zones(ctx context.Context) ([]ZoneFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data != nil && time.Since(s.checked) < s.interval() {
+		return s.files, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.etag) > 0 {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if len(s.lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		s.checked = time.Now()
+		return s.files, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("%s: %s", s.URL, resp.Status)
+	}
+
+	files, data, err := readZoneArchive(resp.Body, s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.URL, err)
+	}
+	// each download is a new version
+	version := resp.Header.Get("ETag") + " " + time.Now().String()
+	for i := range files {
+		files[i].Version = version
+	}
+
+	s.files, s.data = files, data
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.checked = time.Now()
+	return s.files, nil
+}
+
+func (s *HTTPSource) ReadZone(ctx context.Context, zf ZoneFile) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[zf.FileName]
+	if !ok {
+		return nil, fmt.Errorf("%s isn't in %s", zf.FileName, s.URL)
+	}
+	return data, nil
+}
+
+// readZoneArchive reads the zone files from a tar or tar.gz archive,
+// the directories in it are ignored.
+func readZoneArchive(r io.Reader, source string) ([]ZoneFile, map[string][]byte, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	files := []ZoneFile{}
+	data := map[string][]byte{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, ok := zoneFileName(hdr.Name)
+		if !ok {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileName := source + "#" + path.Base(hdr.Name)
+		files = append(files, ZoneFile{
+			Name:     name,
+			FileName: fileName,
+			ModTime:  hdr.ModTime,
+		})
+		data[fileName] = b
+	}
+	return files, data, nil
+}
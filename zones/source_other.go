@@ -0,0 +1,9 @@
+//go:build !unix
+
+package zones
+
+import "io/fs"
+
+func fileID(fileInfo fs.FileInfo) string {
+	return ""
+}
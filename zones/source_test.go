@@ -0,0 +1,275 @@
+package zones
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func testZoneJSON(ip string) string {
+	return `{ "data": { "": { "ns": [ "ns1.example.net" ] }, "www": { "a": [ [ "` + ip + `" ] ] } } }`
+}
+
+func testZoneArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "zones/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, data := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "zones/" + name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(1700000000, 0),
+		}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestHTTPSource(t *testing.T) {
+	var mu sync.Mutex
+	version := 1
+	failing := false
+	notModified := 0
+	files := map[string]string{
+		"example.com.json": testZoneJSON("192.0.2.1"),
+		"example.net.json": testZoneJSON("192.0.2.2"),
+		"README":           "not a zone",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		etag := fmt.Sprintf(`"v%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(testZoneArchive(t, files))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com.json"), []byte(testZoneJSON("192.0.2.100")), 0644))
+
+	src := &HTTPSource{URL: ts.URL, Interval: time.Nanosecond}
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManagerSources(reg, &DirSource{Dir: dir}, src)
+	require.NoError(t, err)
+
+	www := func(zone string) string {
+		z := reg.get(zone)
+		if z == nil {
+			return ""
+		}
+		return z.Labels["www"].Records[dns.TypeA][0].RR.String()
+	}
+
+	assert.Contains(t, www("example.com"), "192.0.2.100", "the first source wins")
+	assert.Contains(t, www("example.net"), "192.0.2.2")
+
+	ctx := context.Background()
+
+	// unchanged, the server says 304
+	require.NoError(t, mm.reload(ctx))
+	assert.Equal(t, 1, notModified)
+	assert.Contains(t, www("example.net"), "192.0.2.2")
+
+	// the zones are kept if the source fails
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	assert.Error(t, mm.reload(ctx))
+	assert.NotNil(t, reg.get("example.net"))
+
+	// a new archive with the same modification times
+	mu.Lock()
+	failing = false
+	version++
+	files["example.net.json"] = testZoneJSON("192.0.2.3")
+	files["example.org.json"] = testZoneJSON("192.0.2.4")
+	mu.Unlock()
+	require.NoError(t, mm.reload(ctx))
+	assert.Contains(t, www("example.net"), "192.0.2.3")
+	assert.Contains(t, www("example.org"), "192.0.2.4")
+
+	// removed from the archive
+	mu.Lock()
+	version++
+	delete(files, "example.net.json")
+	mu.Unlock()
+	require.NoError(t, mm.reload(ctx))
+	assert.Nil(t, reg.get("example.net"))
+	assert.NotNil(t, reg.get("example.org"))
+	assert.NotNil(t, reg.get("example.com"))
+}
+
+func TestDirSourceWatch(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+
+	// replaced atomically, with the same modification time
+	write := func(name, data string) {
+		tmp := filepath.Join(dir, "."+name+".tmp")
+		require.NoError(t, os.WriteFile(tmp, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(tmp, mtime, mtime))
+		require.NoError(t, os.Rename(tmp, filepath.Join(dir, name)))
+	}
+	write("example.com.json", testZoneJSON("192.0.2.1"))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	source := &watchedSource{DirSource: &DirSource{Dir: dir}, watching: make(chan struct{})}
+	mm, err := NewMuxManagerSources(reg, source)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mm.Run(ctx)
+
+	select {
+	case <-source.watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the directory isn't being watched")
+	}
+
+	www := func(zone string) string {
+		z := reg.get(zone)
+		if z == nil {
+			return ""
+		}
+		return z.Labels["www"].Records[dns.TypeA][0].RR.String()
+	}
+
+	// the same size too
+	write("example.com.json", testZoneJSON("192.0.2.2"))
+	require.Eventually(t, func() bool {
+		return strings.HasSuffix(www("example.com"), "192.0.2.2")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	write("example.net.json", testZoneJSON("192.0.2.3"))
+	require.Eventually(t, func() bool { return reg.get("example.net") != nil }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "example.net.json")))
+	require.Eventually(t, func() bool { return reg.get("example.net") == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+// watchedSource closes watching on the first call from the watcher.
+type watchedSource struct {
+	*DirSource
+	watching chan struct{}
+}
+
+func (s *watchedSource) Watch(ctx context.Context, changed func()) {
+	var once sync.Once
+	s.DirSource.Watch(ctx, func() {
+		once.Do(func() { close(s.watching) })
+		changed()
+	})
+}
+
+type testBatchReg struct {
+	testReg
+	updates [][]ZoneChange
+}
+
+func (r *testBatchReg) Update(changes []ZoneChange) {
+	r.mu.Lock()
+	r.updates = append(r.updates, changes)
+	r.mu.Unlock()
+	for _, c := range changes {
+		if c.Zone == nil {
+			r.Remove(c.Name)
+		} else {
+			r.Add(c.Name, c.Zone)
+		}
+	}
+}
+
+func TestAtomicReload(t *testing.T) {
+	base := t.TempDir()
+	current := filepath.Join(base, "current")
+
+	generation := func(name string, files map[string]string) {
+		dir := filepath.Join(base, name)
+		require.NoError(t, os.Mkdir(dir, 0755))
+		for fileName, data := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte(data), 0644))
+		}
+		tmp := filepath.Join(base, ".current.tmp")
+		require.NoError(t, os.Symlink(name, tmp))
+		require.NoError(t, os.Rename(tmp, current))
+	}
+
+	generation("g1", map[string]string{
+		"example.com.json": testZoneJSON("192.0.2.1"),
+		"example.net.json": testZoneJSON("192.0.2.2"),
+	})
+
+	reg := &testBatchReg{testReg: testReg{zones: map[string]*Zone{}}}
+	mm, err := NewMuxManagerSources(reg, &DirSource{Dir: current, Atomic: true})
+	require.NoError(t, err)
+	require.Len(t, reg.updates, 1)
+	assert.Len(t, reg.updates[0], 2)
+	assert.NotNil(t, reg.get("example.net"))
+
+	www := func(zone string) string {
+		z := reg.get(zone)
+		if z == nil {
+			return ""
+		}
+		return z.Labels["www"].Records[dns.TypeA][0].RR.String()
+	}
+
+	// one broken zone, nothing is changed
+	generation("g2", map[string]string{
+		"example.com.json": testZoneJSON("192.0.2.10"),
+		"example.org.json": `{ "data": { "www": { "a": [ "broken" ] } }`,
+	})
+	assert.Error(t, mm.reload(context.Background()))
+	assert.Len(t, reg.updates, 1)
+	assert.Contains(t, www("example.com"), "192.0.2.1")
+	assert.NotNil(t, reg.get("example.net"))
+	assert.Nil(t, reg.get("example.org"))
+
+	// all the changes together
+	generation("g3", map[string]string{
+		"example.com.json": testZoneJSON("192.0.2.10"),
+		"example.org.json": testZoneJSON("192.0.2.3"),
+	})
+	require.NoError(t, mm.reload(context.Background()))
+	require.Len(t, reg.updates, 2)
+	assert.Len(t, reg.updates[1], 3)
+	assert.Contains(t, www("example.com"), "192.0.2.10")
+	assert.Contains(t, www("example.org"), "192.0.2.3")
+	assert.Nil(t, reg.get("example.net"))
+
+	// unchanged
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Len(t, reg.updates, 2)
+}
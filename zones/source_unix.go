@@ -0,0 +1,18 @@
+//go:build unix
+
+package zones
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileID returns the inode of the file, so a file renamed into place is
+// a new version even with the same modification time and size.
+func fileID(fileInfo fs.FileInfo) string {
+	if st, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return strconv.FormatUint(uint64(st.Ino), 10)
+	}
+	return ""
+}
@@ -0,0 +1,415 @@
+package zones
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Dynamic updates (RFC 2136) change the records of zones read from JSON
+// files in a zone directory. The changed zone is written back to the
+// file, so the changes are kept when the zone is reloaded.
+//
+// Added records get the TTL from the update if it's different from the
+// default TTL for the label, and no weight. Adding a record that's already
+// in the zone only changes its TTL, the weights are set in the zone file.
+
+// updateTypes are the record types that can be updated, with their key
+// in the zone data.
+var updateTypes = map[uint16]string{
+	dns.TypeA:     "a",
+	dns.TypeAAAA:  "aaaa",
+	dns.TypeCNAME: "cname",
+	dns.TypeMX:    "mx",
+	dns.TypeNS:    "ns",
+	dns.TypeTXT:   "txt",
+	dns.TypeSPF:   "spf",
+	dns.TypeSRV:   "srv",
+	dns.TypePTR:   "ptr",
+}
+
+// Update checks the prerequisites and applies the updates from an UPDATE
+// message to the zone. It returns the rcode for the response.
+func (z *Zone) Update(prereqs, updates []dns.RR) uint16 {
+	if z.updateZone == nil {
+		return dns.RcodeRefused
+	}
+	return z.updateZone(z.Origin, prereqs, updates)
+}
+
+type updateError struct {
+	rcode uint16
+	msg   string
+}
+
+func (e *updateError) Error() string {
+	return e.msg
+}
+
+func updateErrorf(rcode uint16, format string, args ...interface{}) error {
+	return &updateError{rcode: rcode, msg: fmt.Sprintf(format, args...)}
+}
+
+func (mm *MuxManager) updateZone(name string, prereqs, updates []dns.RR) uint16 {
+	err := mm.update(name, prereqs, updates)
+	if err == nil {
+		return dns.RcodeSuccess
+	}
+	log.Printf("[zone %s] update failed: %s", name, err)
+	var uerr *updateError
+	var zerr ZoneErrors
+	switch {
+	case errors.As(err, &uerr):
+		return uerr.rcode
+	case errors.As(err, &zerr), errors.Is(err, ErrNotFound), errors.Is(err, ErrNotEditable):
+		return dns.RcodeRefused
+	}
+	return dns.RcodeServerFailure
+}
+
+func (mm *MuxManager) update(name string, prereqs, updates []dns.RR) error {
+	_, err := mm.editZone(name, "", func(zone *Zone, objmap map[string]interface{}) error {
+		if err := zone.checkPrereqs(prereqs); err != nil {
+			return err
+		}
+		zoneData, ok := objmap["data"].(map[string]interface{})
+		if !ok {
+			zoneData = map[string]interface{}{}
+			objmap["data"] = zoneData
+		}
+		origin := dnsutil.Fqdn(name)
+		for _, rr := range updates {
+			if err := applyUpdate(zone, zoneData, origin, rr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// updateLabel returns the label for a name in the update, checking it's
+// in the zone.
+func updateLabel(origin string, rr dns.RR) (string, error) {
+	name := strings.ToLower(rr.Header().Name)
+	if !dnsutil.IsBelow(origin, name) {
+		return "", updateErrorf(dns.RcodeNotZone, "'%s' isn't in the zone", rr.Header().Name)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, origin), "."), nil
+}
+
+// rdataString is the record data of rr, for comparing records.
+func rdataString(rr dns.RR) string {
+	rr = rr.Clone()
+	h := rr.Header()
+	h.Name = strings.ToLower(h.Name)
+	h.TTL = 0
+	h.Class = dns.ClassINET
+	return rr.String()
+}
+
+func (zone *Zone) rrset(label string, rtype uint16) Records {
+	if l, ok := zone.Labels[label]; ok {
+		return l.Records[rtype]
+	}
+	return nil
+}
+
+func (zone *Zone) nameInUse(label string) bool {
+	l, ok := zone.Labels[label]
+	if !ok {
+		return false
+	}
+	for _, records := range l.Records {
+		if len(records) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrereqs checks the prerequisites of an update (RFC 2136 section
+// 3.2) against the zone being served.
+func (zone *Zone) checkPrereqs(prereqs []dns.RR) error {
+	origin := dnsutil.Fqdn(zone.Origin)
+
+	type rrset struct {
+		label string
+		rtype uint16
+	}
+	want := map[rrset][]string{}
+
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.TTL != 0 {
+			return updateErrorf(dns.RcodeFormatError, "prerequisite with a TTL")
+		}
+		label, err := updateLabel(origin, rr)
+		if err != nil {
+			return err
+		}
+		rtype := dns.RRToType(rr)
+
+		switch h.Class {
+		case dns.ClassANY:
+			if rtype == dns.TypeANY {
+				if !zone.nameInUse(label) {
+					return updateErrorf(dns.RcodeNameError, "'%s' doesn't exist", h.Name)
+				}
+			} else if len(zone.rrset(label, rtype)) == 0 {
+				return updateErrorf(dns.RcodeNXRrset, "no %s records for '%s'", dnsutil.TypeToString(rtype), h.Name)
+			}
+		case dns.ClassNONE:
+			if rtype == dns.TypeANY {
+				if zone.nameInUse(label) {
+					return updateErrorf(dns.RcodeYXDomain, "'%s' exists", h.Name)
+				}
+			} else if len(zone.rrset(label, rtype)) > 0 {
+				return updateErrorf(dns.RcodeYXRrset, "%s records for '%s' exist", dnsutil.TypeToString(rtype), h.Name)
+			}
+		case dns.ClassINET:
+			k := rrset{label, rtype}
+			want[k] = append(want[k], rdataString(rr))
+		default:
+			return updateErrorf(dns.RcodeFormatError, "prerequisite with class %d", h.Class)
+		}
+	}
+
+	for k, rdata := range want {
+		var have []string
+		for _, r := range zone.rrset(k.label, k.rtype) {
+			have = append(have, rdataString(r.RR))
+		}
+		sort.Strings(rdata)
+		sort.Strings(have)
+		if !slices.Equal(slices.Compact(rdata), slices.Compact(have)) {
+			return updateErrorf(dns.RcodeNXRrset, "%s records for '%s' don't match", dnsutil.TypeToString(k.rtype), k.label)
+		}
+	}
+	return nil
+}
+
+// applyUpdate changes the zone data for one record in the update section
+// (RFC 2136 section 3.4.2).
+func applyUpdate(zone *Zone, zoneData map[string]interface{}, origin string, rr dns.RR) error {
+	h := rr.Header()
+	label, err := updateLabel(origin, rr)
+	if err != nil {
+		return err
+	}
+	rtype := dns.RRToType(rr)
+	if rtype == dns.TypeSOA {
+		// the serial is updated with the zone
+		return nil
+	}
+	if h.Class != dns.ClassINET && h.TTL != 0 {
+		return updateErrorf(dns.RcodeFormatError, "delete with a TTL")
+	}
+
+	labelData, _ := zoneData[label].(map[string]interface{})
+
+	switch h.Class {
+	case dns.ClassINET:
+		if _, ok := updateTypes[rtype]; !ok {
+			return updateErrorf(dns.RcodeRefused, "can't add %s records", dnsutil.TypeToString(rtype))
+		}
+		// the TTL is only kept if it isn't the default for the label
+		var ttl uint32
+		if h.TTL != zone.defaultTTL(label, rtype) {
+			ttl = h.TTL
+		}
+		record := map[string]interface{}{}
+		if ttl > 0 {
+			record["ttl"] = float64(ttl)
+		}
+		key, value, err := recordData(rr, record)
+		if err != nil {
+			return updateErrorf(dns.RcodeRefused, "%s", err)
+		}
+		if labelData == nil {
+			labelData = map[string]interface{}{}
+			zoneData[label] = labelData
+		}
+		records := recordList(labelData[key])
+		i := slices.IndexFunc(records, func(rec interface{}) bool {
+			return sameRecord(origin, label, key, rec, rr)
+		})
+		if i < 0 {
+			labelData[key] = append(records, value)
+			break
+		}
+		if rec, ok := recordWithTTL(origin, label, key, records[i], rr, ttl); ok {
+			records[i] = rec
+			labelData[key] = records
+		}
+
+	case dns.ClassANY:
+		if labelData == nil {
+			return nil
+		}
+		for t, key := range updateTypes {
+			if rtype != dns.TypeANY && rtype != t {
+				continue
+			}
+			if t == dns.TypeNS && len(label) == 0 {
+				// the NS records at the apex can't be deleted as a set
+				continue
+			}
+			delete(labelData, key)
+		}
+		if rtype == dns.TypeANY {
+			delete(labelData, "alias")
+		}
+
+	case dns.ClassNONE:
+		key, ok := updateTypes[rtype]
+		if !ok || labelData == nil {
+			return nil
+		}
+		records := recordList(labelData[key])
+		n := len(records)
+		records = slices.DeleteFunc(records, func(rec interface{}) bool {
+			return sameRecord(origin, label, key, rec, rr)
+		})
+		switch {
+		case len(records) == n:
+			return nil
+		case len(records) > 0:
+			labelData[key] = records
+		case rtype == dns.TypeNS && len(label) == 0:
+			// keep the last NS record at the apex
+			return nil
+		default:
+			delete(labelData, key)
+		}
+
+	default:
+		return updateErrorf(dns.RcodeFormatError, "update with class %d", h.Class)
+	}
+
+	if labelData != nil && len(label) > 0 && !hasRecords(labelData) {
+		delete(zoneData, label)
+	}
+	return nil
+}
+
+// defaultTTL is the TTL records of the type get in the label when they
+// don't have their own, as when the zone is read.
+func (zone *Zone) defaultTTL(label string, rtype uint16) uint32 {
+	ttl := uint32(86400)
+	if rtype != dns.TypeNS {
+		ttl = uint32(zone.Options.Ttl)
+	}
+	if l, ok := zone.Labels[label]; ok {
+		if l.Ttl > 0 {
+			ttl = uint32(l.Ttl)
+		}
+		if n := l.TypeTtl[rtype]; n > 0 {
+			ttl = uint32(n)
+		}
+	}
+	return ttl
+}
+
+// recordWithTTL returns the record from the zone data with its own TTL
+// set to ttl (or removed if it's 0), keeping the weight and the other
+// options. It returns false if the TTL doesn't change. NS records don't
+// have their own TTL.
+func recordWithTTL(origin, label, key string, rec interface{}, rr dns.RR, ttl uint32) (interface{}, bool) {
+	rtype := dns.RRToType(rr)
+	if rtype == dns.TypeNS {
+		return rec, false
+	}
+	r := jsonRecord(origin, label, key, rec, rtype)
+	if r == nil {
+		return rec, false
+	}
+
+	recmap, isMap := rec.(map[string]interface{})
+	var current uint32
+	if _, ok := recmap["ttl"]; ok {
+		current = r.RR.Header().TTL
+	}
+	if current == ttl {
+		return rec, false
+	}
+
+	record := map[string]interface{}{}
+	if isMap {
+		for k, v := range recmap {
+			record[k] = v
+		}
+	} else {
+		if _, _, err := recordData(rr, record); err != nil {
+			return rec, false
+		}
+		if r.Weight > 0 {
+			record["weight"] = float64(r.Weight)
+		}
+	}
+	if ttl > 0 {
+		record["ttl"] = float64(ttl)
+	} else {
+		delete(record, "ttl")
+	}
+	return record, true
+}
+
+func hasRecords(labelData map[string]interface{}) bool {
+	for k := range labelData {
+		if _, ok := zoneRecordTypes[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recordList returns the records for a type in the label data as a
+// list, the CNAME and NS records can also be a string or an object.
+func recordList(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v
+	case string:
+		return []interface{}{v}
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		records := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			records = append(records, name)
+		}
+		return records
+	}
+	return nil
+}
+
+// jsonRecord reads a record from the zone data, it returns nil if it
+// isn't valid.
+func jsonRecord(origin, label, key string, rec interface{}, rtype uint16) *Record {
+	zone := NewZone(strings.TrimSuffix(origin, "."))
+	data := map[string]interface{}{
+		label: map[string]interface{}{key: []interface{}{rec}},
+	}
+	setupZoneData(data, zone, &zoneCheck{})
+	if records := zone.rrset(label, rtype); len(records) == 1 {
+		return records[0]
+	}
+	return nil
+}
+
+// sameRecord checks if the record from the zone data has the same data
+// as rr.
+func sameRecord(origin, label, key string, rec interface{}, rr dns.RR) bool {
+	r := jsonRecord(origin, label, key, rec, dns.RRToType(rr))
+	return r != nil && rdataString(r.RR) == rdataString(rr)
+}
@@ -0,0 +1,139 @@
+package zones
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "example.com.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{
+  "serial": 10,
+  "targeting": "@ continent",
+  "data": {
+    "": { "ns": { "ns1.example.net.": null, "ns2.example.net.": null } },
+    "www": { "a": [ [ "192.0.2.1", 10 ] ] },
+    "www.europe": { "a": [ [ "192.0.2.2", 10 ], [ "192.0.2.3", 5 ] ], "ttl": 60 },
+    "api": { "a": [ [ "192.0.2.20" ], [ "192.0.2.21" ] ] }
+  }
+}`), 0644))
+
+	reg := &testReg{zones: map[string]*Zone{}}
+	mm, err := NewMuxManager(dir, reg)
+	require.NoError(t, err)
+
+	rr := func(s string) dns.RR {
+		rr, err := dns.New(s)
+		require.NoError(t, err)
+		return rr
+	}
+	deleteRR := func(s string) dns.RR {
+		rr := rr(s)
+		rr.Header().Class = dns.ClassNONE
+		rr.Header().TTL = 0
+		return rr
+	}
+	records := func(label string) map[string]int {
+		r := map[string]int{}
+		if l, ok := reg.get("example.com").Labels[label]; ok {
+			for _, rec := range l.Records[dns.TypeA] {
+				r[rec.RR.(*dns.A).Addr.String()] = rec.Weight
+			}
+		}
+		return r
+	}
+	serial := func() uint32 {
+		return reg.get("example.com").SoaRR().(*dns.SOA).Serial
+	}
+	update := func(prereqs, updates []dns.RR) uint16 {
+		return reg.get("example.com").Update(prereqs, updates)
+	}
+
+	ttls := func(label string) map[string]uint32 {
+		r := map[string]uint32{}
+		for _, rec := range reg.get("example.com").Labels[label].Records[dns.TypeA] {
+			r[rec.RR.(*dns.A).Addr.String()] = rec.RR.Header().TTL
+		}
+		return r
+	}
+
+	// records added by nsupdate have the TTL from the update and don't
+	// change the weights
+	assert.Equal(t, uint16(dns.RcodeSuccess), update(nil, []dns.RR{
+		rr("api.example.com. 300 IN A 192.0.2.22"),
+		rr("api.example.com. 120 IN A 192.0.2.23"),
+	}))
+	assert.Equal(t, map[string]int{"192.0.2.20": 1, "192.0.2.21": 1, "192.0.2.22": 1, "192.0.2.23": 1}, records("api"))
+	assert.Equal(t, map[string]uint32{"192.0.2.20": 120, "192.0.2.21": 120, "192.0.2.22": 300, "192.0.2.23": 120}, ttls("api"))
+	assert.Equal(t, uint32(11), serial())
+
+	// adding a record that's there changes its TTL and keeps its weight
+	assert.Equal(t, uint16(dns.RcodeSuccess), update(nil, []dns.RR{
+		rr("www.europe.example.com. 20 IN A 192.0.2.4"),
+		rr("www.europe.example.com. 30 IN A 192.0.2.3"),
+		rr("www.europe.example.com. 60 IN A 192.0.2.2"),
+	}))
+	assert.Equal(t, map[string]int{"192.0.2.2": 10, "192.0.2.3": 5, "192.0.2.4": 0}, records("www.europe"))
+	assert.Equal(t, map[string]uint32{"192.0.2.2": 60, "192.0.2.3": 30, "192.0.2.4": 20}, ttls("www.europe"))
+	assert.Equal(t, uint32(12), serial())
+
+	// nothing changes when the records are there with the same TTL
+	assert.Equal(t, uint16(dns.RcodeSuccess), update(nil, []dns.RR{
+		rr("www.europe.example.com. 30 IN A 192.0.2.3"),
+		rr("api.example.com. 120 IN A 192.0.2.20"),
+	}))
+	assert.Equal(t, uint32(12), serial())
+
+	// the prerequisites are checked before anything changes
+	assert.Equal(t, uint16(dns.RcodeNXRrset), update(
+		[]dns.RR{&dns.AAAA{Hdr: dns.Header{Name: "www.example.com.", Class: dns.ClassANY}}},
+		[]dns.RR{rr("www.example.com. 1 IN A 192.0.2.9")},
+	))
+	assert.Equal(t, uint16(dns.RcodeYXDomain), update(
+		[]dns.RR{&dns.ANY{Hdr: dns.Header{Name: "www.example.com.", Class: dns.ClassNONE}}}, nil,
+	))
+	assert.Equal(t, uint16(dns.RcodeSuccess), update(
+		[]dns.RR{rr("www.example.com. 0 IN A 192.0.2.1")}, nil,
+	))
+	assert.Equal(t, uint16(dns.RcodeNotZone), update(nil, []dns.RR{rr("www.example.org. 1 IN A 192.0.2.9")}))
+	assert.Equal(t, uint32(12), serial())
+
+	// delete a record, and a name with all its records
+	assert.Equal(t, uint16(dns.RcodeSuccess), update(nil, []dns.RR{
+		deleteRR("www.europe.example.com. 0 IN A 192.0.2.2"),
+		&dns.ANY{Hdr: dns.Header{Name: "www.example.com.", Class: dns.ClassANY}},
+	}))
+	assert.Equal(t, map[string]int{"192.0.2.3": 5, "192.0.2.4": 0}, records("www.europe"))
+	assert.Empty(t, records("www"))
+
+	// the changes are in the zone file, and kept when it's reloaded
+	var objmap map[string]interface{}
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &objmap))
+	assert.Equal(t, float64(13), objmap["serial"])
+	assert.NotContains(t, objmap["data"], "www")
+
+	require.NoError(t, mm.reload(context.Background()))
+	assert.Equal(t, map[string]int{"192.0.2.3": 5, "192.0.2.4": 0}, records("www.europe"))
+	assert.Equal(t, map[string]uint32{"192.0.2.3": 30, "192.0.2.4": 20}, ttls("www.europe"))
+
+	reg2 := &testReg{zones: map[string]*Zone{}}
+	_, err = NewMuxManager(dir, reg2)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(13), reg2.get("example.com").SoaRR().(*dns.SOA).Serial)
+
+	assert.Equal(t, uint16(dns.RcodeRefused), update(nil, []dns.RR{rr("example.com. 1 IN HINFO cpu os")}))
+
+	require.NoError(t, mm.Pin("example.com", ""))
+	assert.Equal(t, uint16(dns.RcodeRefused), update(nil, []dns.RR{rr("www.example.com. 1 IN A 192.0.2.9")}))
+}
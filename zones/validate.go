@@ -0,0 +1,425 @@
+package zones
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	dns "codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// Severity tells if a zone problem stops the zone from being loaded.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// ZoneProblem is an issue found reading a zone file. Path is the location
+// in the zone data, for example data.www.a[1]; Line and Column are 0 if
+// the position isn't known.
+type ZoneProblem struct {
+	File     string
+	Path     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func (p *ZoneProblem) String() string {
+	var b strings.Builder
+	if len(p.File) > 0 {
+		b.WriteString(p.File)
+		if p.Line > 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+			if p.Column > 0 {
+				fmt.Fprintf(&b, ":%d", p.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Severity.String())
+	b.WriteString(": ")
+	if len(p.Path) > 0 {
+		b.WriteString(p.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ZoneErrors is the list of problems found in a zone. It's returned as
+// the error from ReadZoneFile if any of the problems are errors.
+type ZoneErrors []*ZoneProblem
+
+func (e ZoneErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, p := range e {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors checks if any of the problems are errors (rather than warnings).
+func (e ZoneErrors) HasErrors() bool {
+	for _, p := range e {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of problems with the severity.
+func (e ZoneErrors) Count(severity Severity) int {
+	n := 0
+	for _, p := range e {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+type position struct {
+	line, column int
+}
+
+// zoneCheck collects the problems found while reading a zone.
+type zoneCheck struct {
+	file      string
+	positions map[string]position
+	problems  ZoneErrors
+}
+
+func (c *zoneCheck) add(severity Severity, path, format string, args ...interface{}) {
+	p := &ZoneProblem{
+		File:     c.file,
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if pos, ok := c.position(path); ok {
+		p.Line, p.Column = pos.line, pos.column
+	}
+	c.problems = append(c.problems, p)
+}
+
+func (c *zoneCheck) errorf(path, format string, args ...interface{}) {
+	c.add(SeverityError, path, format, args...)
+}
+
+func (c *zoneCheck) warnf(path, format string, args ...interface{}) {
+	c.add(SeverityWarning, path, format, args...)
+}
+
+// position finds the position of path, or the closest parent of it.
+func (c *zoneCheck) position(path string) (position, bool) {
+	for len(path) > 0 {
+		if pos, ok := c.positions[path]; ok {
+			return pos, true
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return position{}, false
+}
+
+func (c *zoneCheck) str(path string, v interface{}) (string, bool) {
+	s, ok := v.(string)
+	if !ok {
+		c.errorf(path, "expected a string, got %s", jsonType(v))
+	}
+	return s, ok
+}
+
+func (c *zoneCheck) number(path string, v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			return n, true
+		}
+		c.errorf(path, "'%s' is not a number", v)
+	default:
+		c.errorf(path, "expected a number, got %s", jsonType(v))
+	}
+	return 0, false
+}
+
+func (c *zoneCheck) boolean(path string, v interface{}) (bool, bool) {
+	b, ok := v.(bool)
+	if !ok {
+		c.errorf(path, "expected true or false, got %s", jsonType(v))
+	}
+	return b, ok
+}
+
+func (c *zoneCheck) object(path string, v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		c.errorf(path, "expected an object, got %s", jsonType(v))
+	}
+	return m, ok
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// pathKey returns the path for key in the object at path.
+func pathKey(path, key string) string {
+	ident := len(key) > 0
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			ident = false
+			break
+		}
+	}
+	switch {
+	case !ident:
+		return path + "[" + strconv.Quote(key) + "]"
+	case len(path) == 0:
+		return key
+	default:
+		return path + "." + key
+	}
+}
+
+// pathIndex returns the path for element i in the list at path.
+func pathIndex(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// jsonPositions maps the paths in the JSON document to their line and
+// column. For object members the position is that of the key.
+func jsonPositions(data []byte) map[string]position {
+	offsets := map[string]int{}
+
+	// skip to the start of the next token
+	next := func(offset int) int {
+		for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+			offset++
+		}
+		return offset
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		if _, ok := offsets[path]; !ok {
+			offsets[path] = next(int(dec.InputOffset()))
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				offset := next(int(dec.InputOffset()))
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				p := pathKey(path, key.(string))
+				offsets[p] = offset
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(pathIndex(path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	// syntax errors are reported by the real decoder
+	_ = walk("")
+
+	sorted := make([]int, 0, len(offsets))
+	for _, offset := range offsets {
+		sorted = append(sorted, offset)
+	}
+	sort.Ints(sorted)
+
+	lines := map[int]position{}
+	line, column, i := 1, 1, 0
+	for offset, c := range data {
+		for i < len(sorted) && sorted[i] == offset {
+			lines[offset] = position{line, column}
+			i++
+		}
+		if c == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	positions := make(map[string]position, len(offsets))
+	for path, offset := range offsets {
+		if pos, ok := lines[offset]; ok {
+			positions[path] = pos
+		}
+	}
+	return positions
+}
+
+// offsetPosition returns the line and column of the byte offset.
+func offsetPosition(data []byte, offset int64) position {
+	pos := position{1, 1}
+	for _, c := range data[:max(0, min(int(offset), len(data)))] {
+		if c == '\n' {
+			pos.line++
+			pos.column = 1
+		} else {
+			pos.column++
+		}
+	}
+	return pos
+}
+
+// CheckZones reads the zones from the sources in the options like the
+// server does, with the secondary zone overlays and the generated PTR
+// records for reverse zones, and returns the problems found in them,
+// including warnings that don't stop the zones from being loaded.
+func CheckZones(options MuxOptions) (ZoneErrors, error) {
+	ctx := context.Background()
+	mm := &MuxManager{zonelist: make(ZoneList)}
+	mm.setupReverses(options.Reverses)
+
+	problems := ZoneErrors{}
+	for _, name := range slices.Sorted(maps.Keys(options.Secondaries)) {
+		config := options.Secondaries[name]
+		if err := config.Check(name); err != nil {
+			return nil, err
+		}
+		if len(config.Overlay) == 0 {
+			continue
+		}
+		// the records from the primary aren't known here, only an NS
+		// record so the zone isn't empty
+		ns := &dns.NS{
+			Hdr: dns.Header{Name: dnsutil.Fqdn(name), Class: dns.ClassINET, TTL: 86400},
+			NS:  rdata.NS{Ns: "primary.invalid."},
+		}
+		zp, err := NewZone(name).readTransfer([]dns.RR{ns}, config.Overlay)
+		if err != nil {
+			return nil, fmt.Errorf("secondary zone '%s': %s", name, err)
+		}
+		problems = append(problems, zp...)
+	}
+
+	type zoneData struct {
+		zf   ZoneFile
+		data []byte
+	}
+	seen := map[string]bool{}
+	reverses := map[string]zoneData{}
+	for _, source := range options.Sources {
+		files, err := source.Zones(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range files {
+			skip := func(format string) {
+				problems = append(problems, &ZoneProblem{
+					File:     zf.FileName,
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf(format, zf.Name),
+				})
+			}
+			if seen[zf.Name] {
+				skip("skipped, zone '%s' is already read from another file")
+				continue
+			}
+			seen[zf.Name] = true
+			if _, ok := options.Secondaries[zf.Name]; ok {
+				skip("skipped, zone '%s' is a secondary zone")
+				continue
+			}
+
+			data, err := source.ReadZone(ctx, zf)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := mm.reverses[zf.Name]; ok {
+				// read after the forward zones
+				reverses[zf.Name] = zoneData{zf, data}
+				continue
+			}
+			zone := NewZone(zf.Name)
+			zp := zone.readZoneData(zf, data, nil)
+			if !zp.HasErrors() {
+				mm.zonelist[zf.Name] = zone
+			}
+			problems = append(problems, zp...)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(mm.reverses)) {
+		config := mm.reverses[name]
+		if !slices.ContainsFunc(config.From, func(from string) bool { return mm.zonelist[from] != nil }) {
+			problems = append(problems, &ZoneProblem{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("reverse zone '%s': none of the forward zones are loaded", name),
+			})
+		}
+		r, ok := reverses[name]
+		if !ok {
+			continue
+		}
+		zone := NewZone(name)
+		problems = append(problems, zone.readZoneData(r.zf, r.data, func(objmap map[string]interface{}, c *zoneCheck) {
+			mm.addPTRs(name, config, objmap, c)
+		})...)
+	}
+	return problems, nil
+}
@@ -0,0 +1,202 @@
+package zones
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneValidation(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "bad.example.json")
+
+	data := `{
+  "serial": "abc",
+  "bogus": 1,
+  "also_notify": [ "192.0.2.53", "ns.example.net" ],
+  "serial_policy": "mtime",
+  "refresh": 0,
+  "negative_ttl": -1,
+  "primary_ns": "",
+  "data": {
+    "": { "ns": [ "ns1.example.net." ] },
+    "www": {
+      "a": [ [ "192.0.2.1" ], [ "192.0.2.300" ], [ "192.0.2.2", true ] ],
+      "aaaa": [ { "aaaa": "192.0.2.1" } ]
+    },
+    "three.two.one": {
+      "mx": [ { "preference": 70000, "mx": "mx" }, { "preference": 10 } ],
+      "txt": [ "" ],
+      "frob": 1
+    },
+    "svc": { "srv": [ "target" ] },
+    "lbl": "x",
+    "ttls": {
+      "txt": [ { "txt": "x", "ttl": 0 } ],
+      "type_ttl": {
+        "a": "x",
+        "hinfo": 60
+      }
+    }
+  }
+}`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+
+	zone := NewZone("bad.example")
+	err := zone.ReadZoneFile(fileName)
+	require.Error(t, err)
+
+	problems, ok := err.(ZoneErrors)
+	require.True(t, ok, "error is ZoneErrors")
+	assert.True(t, problems.HasErrors())
+
+	type expected struct {
+		path     string
+		line     int
+		severity Severity
+	}
+	want := []expected{
+		{"serial", 2, SeverityError},
+		{"bogus", 3, SeverityWarning},
+		{"also_notify[1]", 4, SeverityError},
+		{"serial_policy", 5, SeverityError},
+		{"refresh", 6, SeverityError},
+		{"negative_ttl", 7, SeverityError},
+		{"primary_ns", 8, SeverityError},
+		{"data.www.a[1]", 12, SeverityError},
+		{"data.www.a[2][1]", 12, SeverityError},
+		{"data.www.aaaa[0]", 13, SeverityError},
+		{`data["three.two.one"].mx[0].preference`, 16, SeverityError},
+		{`data["three.two.one"].mx[1]`, 16, SeverityError},
+		{`data["three.two.one"].txt[0]`, 17, SeverityWarning},
+		{`data["three.two.one"].frob`, 18, SeverityWarning},
+		{"data.svc.srv[0]", 20, SeverityError},
+		{"data.lbl", 21, SeverityError},
+		{"data.ttls.txt[0].ttl", 23, SeverityError},
+		{"data.ttls.type_ttl.a", 25, SeverityError},
+		{"data.ttls.type_ttl.hinfo", 26, SeverityError},
+	}
+
+	for _, w := range want {
+		var found *ZoneProblem
+		for _, p := range problems {
+			if p.Path == w.path {
+				found = p
+				break
+			}
+		}
+		if !assert.NotNil(t, found, "problem for %s", w.path) {
+			continue
+		}
+		assert.Equal(t, w.line, found.Line, w.path)
+		assert.Equal(t, w.severity, found.Severity, w.path)
+		assert.Equal(t, fileName, found.File)
+	}
+	assert.Len(t, problems, len(want))
+	t.Log(problems.Error())
+
+	assert.Equal(t, "192.0.2.53:53", zone.Options.AlsoNotify[0].String())
+
+	// the valid records are still read
+	assert.Len(t, zone.Labels["www"].Records[1], 1) // A
+}
+
+func TestZoneValidationWarnings(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "warn.example.json")
+	data := `{"unknown": true, "data": {"www": {"a": [["192.0.2.1"]]}}}`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+
+	zone := NewZone("warn.example")
+	require.NoError(t, zone.ReadZoneFile(fileName), "warnings don't fail the zone")
+
+	problems, err := CheckZones(MuxOptions{Sources: []ZoneSource{&DirSource{Dir: dir}}})
+	require.NoError(t, err)
+	assert.False(t, problems.HasErrors())
+	assert.Equal(t, 2, problems.Count(SeverityWarning))
+}
+
+func TestCheckZonesOptions(t *testing.T) {
+	dir, other, overlays := t.TempDir(), t.TempDir(), t.TempDir()
+	write := func(dir, name, data string) string {
+		fileName := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+		return fileName
+	}
+	write(dir, "fwd.example.json", `{"data": {"": {"ns": ["ns1.example.net."]}, "www": {"a": [["192.0.2.1"]]}}}`)
+	write(dir, "sec.example.json", `{"data": {"www": {"a": [["192.0.2.300"]]}}}`)
+	skipped := write(other, "fwd.example.json", `{"data": {"www": {"a": [["192.0.2.300"]]}}}`)
+	reverse := write(other, "2.0.192.in-addr.arpa.json", `{"data": {"9": {"ptr": [ "" ]}}}`)
+	overlay := write(overlays, "sec.example.json", `{"data": {"geo": {"a": [["192.0.2.300"]]}}}`)
+
+	problems, err := CheckZones(MuxOptions{
+		Sources: []ZoneSource{&DirSource{Dir: dir}, &DirSource{Dir: other}},
+		Reverses: map[string]ReverseConfig{
+			"2.0.192.in-addr.arpa":   {From: []string{"fwd.example"}},
+			"113.0.203.in-addr.arpa": {From: []string{"missing.example"}},
+		},
+		Secondaries: map[string]SecondaryConfig{
+			"sec.example": {Primaries: []netip.AddrPort{netip.MustParseAddrPort("192.0.2.53:53")}, Overlay: overlay},
+		},
+	})
+	require.NoError(t, err)
+	files := map[string]Severity{}
+	for _, p := range problems {
+		files[p.File] = max(files[p.File], p.Severity)
+	}
+	assert.Equal(t, map[string]Severity{
+		overlay:                                SeverityError,
+		filepath.Join(dir, "sec.example.json"): SeverityWarning,
+		skipped:                                SeverityWarning,
+		reverse:                                SeverityError,
+		"":                                     SeverityWarning,
+	}, files)
+	for _, p := range problems {
+		if p.File == "" {
+			assert.Contains(t, p.Message, "113.0.203.in-addr.arpa")
+		}
+	}
+
+	_, err = CheckZones(MuxOptions{
+		Secondaries: map[string]SecondaryConfig{"bad.example": {}},
+	})
+	assert.Error(t, err, "secondary zone without primaries")
+}
+
+func TestZoneValidationSyntax(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "syntax.example.json")
+	require.NoError(t, os.WriteFile(fileName, []byte("{\n  \"data\": {\n    \"www\": nul\n}"), 0644))
+
+	zone := NewZone("syntax.example")
+	err := zone.ReadZoneFile(fileName)
+	require.Error(t, err)
+	problems := err.(ZoneErrors)
+	require.Len(t, problems, 1)
+	assert.Equal(t, 3, problems[0].Line)
+	assert.Contains(t, problems[0].String(), "syntax.example.json:3:")
+}
+
+func TestMasterFileValidation(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "mf.example.zone")
+	data := `$TTL 300
+@    IN NS ns1.example.net.
+www  IN A 192.0.2.1 ; geo: weight=x
+www  IN A 192.0.2.2
+bad  IN HINFO "a" "b"
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+
+	zone := NewZone("mf.example")
+	err := zone.ReadZoneFile(fileName)
+	require.Error(t, err)
+	problems := err.(ZoneErrors)
+	require.Len(t, problems, 2, "all errors are reported")
+	assert.Equal(t, 3, problems[0].Line)
+	assert.Equal(t, 5, problems[1].Line)
+}
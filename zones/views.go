@@ -0,0 +1,179 @@
+package zones
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/abh/geodns/v3/targeting"
+
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// SourceView is a view selected by the source address of the query
+// instead of the geo targeting, for example to give internal resolvers
+// the private addresses. Labels for the view are named like targeted
+// labels ("www.internal") and are used before the other targets.
+type SourceView struct {
+	Name     string
+	Networks []netip.Prefix
+
+	// ECS matches the EDNS client subnet address, if the query has one,
+	// instead of the address the query came from. Any client can set the
+	// option, so without Resolvers any client can pick the view.
+	ECS bool
+
+	// Resolvers has the networks the EDNS client subnet is used from
+	// with ECS; if it's empty the option is used from all queries
+	Resolvers []netip.Prefix
+}
+
+// useECS checks if the EDNS client subnet is used for the view in
+// queries from realIP.
+func (view *SourceView) useECS(realIP netip.Addr) bool {
+	if !view.ECS {
+		return false
+	}
+	if len(view.Resolvers) == 0 {
+		return true
+	}
+	realIP = realIP.Unmap()
+	return slices.ContainsFunc(view.Resolvers, func(n netip.Prefix) bool { return n.Contains(realIP) })
+}
+
+// MatchView returns the source view for a query from realIP, with ecs
+// the address in the EDNS client subnet option (if any). The most
+// specific network wins. If the view matched the ECS address the prefix
+// length of the network is returned too, for the ECS scope.
+func (z *Zone) MatchView(realIP, ecs netip.Addr) (string, int) {
+	var name string
+	bits, ecsBits := -1, 0
+	for _, view := range z.Options.Views {
+		ip, isECS := realIP, false
+		if ecs.IsValid() && view.useECS(realIP) {
+			ip, isECS = ecs, true
+		}
+		ip = ip.Unmap()
+		for _, n := range view.Networks {
+			if n.Bits() > bits && n.Contains(ip) {
+				name, bits = view.Name, n.Bits()
+				ecsBits = 0
+				if isECS {
+					ecsBits = n.Bits()
+				}
+			}
+		}
+	}
+	return name, ecsBits
+}
+
+// sourceView returns the source view named name, or nil.
+func (z *Zone) sourceView(name string) *SourceView {
+	for i := range z.Options.Views {
+		if z.Options.Views[i].Name == name {
+			return &z.Options.Views[i]
+		}
+	}
+	return nil
+}
+
+// allTargets has all the targeting options, to check names for views
+// aren't target names with any of them.
+var allTargets = &Zone{Options: ZoneOptions{Targeting: ^targeting.TargetOptions(0)}}
+
+// setupViews reads the "views" zone option: the networks for each view,
+// as a list or in an object with the "ecs" and "resolvers" options.
+func (zone *Zone) setupViews(path string, v interface{}, c *zoneCheck) {
+	views, ok := c.object(path, v)
+	if !ok {
+		return
+	}
+
+	names := make([]string, 0, len(views))
+	for name := range views {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		vpath := pathKey(path, name)
+		view := SourceView{Name: strings.ToLower(name)}
+		switch {
+		case len(view.Name) == 0 || strings.Contains(view.Name, ".") || !dnsutil.IsName(view.Name+"."):
+			c.errorf(vpath, "invalid view name '%s'", name)
+			continue
+		case allTargets.viewTargets(view.Name) != nil:
+			c.errorf(vpath, "view name '%s' is a targeting name", name)
+			continue
+		}
+
+		networks := views[name]
+		if m, ok := views[name].(map[string]interface{}); ok {
+			networks = m["networks"]
+			for k, v := range m {
+				switch k {
+				case "networks":
+				case "ecs":
+					view.ECS, _ = c.boolean(pathKey(vpath, k), v)
+				case "resolvers":
+					view.Resolvers = c.networks(pathKey(vpath, k), v)
+				default:
+					c.warnf(pathKey(vpath, k), "unknown view option '%s'", k)
+				}
+			}
+			vpath = pathKey(vpath, "networks")
+		}
+
+		if _, ok := networks.([]interface{}); !ok {
+			c.errorf(vpath, "expected a list of networks, got %s", jsonType(networks))
+			continue
+		}
+		view.Networks = c.networks(vpath, networks)
+		if len(view.Networks) == 0 {
+			c.warnf(vpath, "view '%s' has no networks", name)
+		}
+		zone.Options.Views = append(zone.Options.Views, view)
+	}
+}
+
+// networks reads a list of networks, skipping the invalid ones.
+func (c *zoneCheck) networks(path string, v interface{}) []netip.Prefix {
+	list, ok := v.([]interface{})
+	if !ok {
+		c.errorf(path, "expected a list of networks, got %s", jsonType(v))
+		return nil
+	}
+	var networks []netip.Prefix
+	for i, n := range list {
+		s, ok := c.str(pathIndex(path, i), n)
+		if !ok {
+			continue
+		}
+		prefix, err := parseNetwork(s)
+		if err != nil {
+			c.errorf(pathIndex(path, i), "%s", err)
+			continue
+		}
+		networks = append(networks, prefix)
+	}
+	return networks
+}
+
+// parseNetwork parses a network in CIDR notation, or an address for
+// just that address.
+func parseNetwork(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid network '%s'", s)
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network '%s'", s)
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,108 @@
+package zones
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dns "codeberg.org/miekg/dns"
+)
+
+func TestSourceViews(t *testing.T) {
+	zone := NewZone("example.com")
+	require.NoError(t, zone.ReadZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "views": {
+    "internal": [ "10.0.0.0/8", "fd00::/8" ],
+    "lab": { "networks": [ "10.1.0.0/16", "192.0.2.53" ], "ecs": true },
+    "office": { "networks": [ "203.0.113.0/24" ], "ecs": true, "resolvers": [ "192.0.2.10", "::ffff:192.0.2.11" ] }
+  },
+  "data": {
+    "": { "ns": [ "ns1.example.net" ] },
+    "www": { "a": [ [ "192.0.2.1" ] ] },
+    "www.internal": { "a": [ [ "10.0.0.1" ] ] }
+  }
+}`)))
+
+	ip := netip.MustParseAddr
+	tests := []struct {
+		realIP, ecs string
+		view        string
+		bits        int
+	}{
+		{"10.2.3.4", "", "internal", 0},
+		{"::ffff:10.2.3.4", "", "internal", 0},
+		{"fd12::1", "", "internal", 0},
+		{"10.1.2.3", "", "lab", 0},
+		{"10.2.3.4", "10.1.2.3", "lab", 16},
+		{"192.0.2.53", "198.51.100.1", "", 0},
+		{"192.0.2.53", "", "lab", 0},
+		{"10.1.2.3", "198.51.100.1", "internal", 0},
+		{"198.51.100.1", "10.2.3.4", "", 0},
+		{"192.0.2.10", "203.0.113.1", "office", 24},
+		{"::ffff:192.0.2.11", "203.0.113.1", "office", 24},
+		{"198.51.100.1", "203.0.113.1", "", 0},
+		{"203.0.113.1", "198.51.100.1", "office", 0},
+	}
+	for _, tt := range tests {
+		var ecs netip.Addr
+		if len(tt.ecs) > 0 {
+			ecs = ip(tt.ecs)
+		}
+		view, bits := zone.MatchView(ip(tt.realIP), ecs)
+		assert.Equal(t, tt.view, view, "%s/%s", tt.realIP, tt.ecs)
+		assert.Equal(t, tt.bits, bits, "%s/%s", tt.realIP, tt.ecs)
+	}
+
+	label := zone.findFirstLabel("www", []string{"internal", "@"}, []uint16{dns.TypeA})
+	require.NotNil(t, label)
+	assert.Equal(t, "www.internal", label.Label.Label)
+
+	view, ok := zone.View("internal")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"internal", "@"}, view.Targets)
+	assert.Equal(t, []string{"", "www"}, zone.viewNames())
+
+	// the same in a master file
+	mzone := NewZone("example.com")
+	require.NoError(t, mzone.ReadZoneData(ZoneFile{Name: "example.com", FileName: "example.com.zone"}, []byte(`
+$TTL 600
+$GEO NETWORKS internal 10.0.0.0/8 fd00::/8
+$GEO NETWORKS lab 10.1.0.0/16 192.0.2.53 ecs=true
+$GEO NETWORKS office 203.0.113.0/24 ecs=true resolvers=192.0.2.10,::ffff:192.0.2.11
+@    IN NS ns1.example.net.
+www  IN A  192.0.2.1
+$GEO VIEW internal
+www  IN A  10.0.0.1
+`)))
+	assert.Equal(t, zone.Options.Views, mzone.Options.Views)
+	assert.Contains(t, mzone.Labels, "www.internal")
+}
+
+func TestSourceViewErrors(t *testing.T) {
+	problems := NewZone("example.com").readZoneData(ZoneFile{Name: "example.com", FileName: "example.com.json"}, []byte(`{
+  "views": {
+    "europe": [ "10.0.0.0/8" ],
+    "a.b": [ "10.0.0.0/8" ],
+    "bad": [ "10.0.0.0/33", "host" ],
+    "opts": { "networks": "10.0.0.0/8", "color": "blue" },
+    "ecs": { "networks": [ "10.0.0.0/8" ], "ecs": true, "resolvers": [ "resolver" ] }
+  },
+  "data": { "": { "ns": [ "ns1.example.net" ] } }
+}`), nil)
+	messages := []string{}
+	for _, p := range problems {
+		messages = append(messages, p.Path+": "+p.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		`views["a.b"]: invalid view name 'a.b'`,
+		`views.bad[0]: invalid network '10.0.0.0/33'`,
+		`views.bad[1]: invalid network 'host'`,
+		`views.bad: view 'bad' has no networks`,
+		`views.europe: view name 'europe' is a targeting name`,
+		`views.ecs.resolvers[0]: invalid network 'resolver'`,
+		`views.opts.color: unknown view option 'color'`,
+		`views.opts.networks: expected a list of networks, got a string`,
+	}, messages)
+}
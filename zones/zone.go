@@ -2,11 +2,13 @@ package zones
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/netip"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/abh/geodns/v3/applog"
 	"github.com/abh/geodns/v3/health"
@@ -19,12 +21,28 @@ import (
 )
 
 type ZoneOptions struct {
-	Serial    int
-	Ttl       int
-	MaxHosts  int
-	Contact   string
-	Targeting targeting.TargetOptions
-	Closest   bool
+	Serial       int
+	SerialPolicy SerialPolicy // the MuxManager default if it's not set
+	Ttl          int
+	MaxHosts     int
+	Contact      string
+	Targeting    targeting.TargetOptions
+	Closest      bool
+
+	// SOA record fields; the primary NS is the first NS record if it's
+	// not set, and the SOA TTL is based on the zone TTL
+	PrimaryNs   string
+	SoaTtl      int
+	Refresh     int
+	Retry       int
+	Expire      int
+	NegativeTtl int // the SOA minimum field
+
+	// secondaries to NOTIFY when the zone changes
+	AlsoNotify []netip.AddrPort
+
+	// views selected by the source address, sorted by name
+	Views []SourceView
 
 	// temporary, using this to keep the healthtest code
 	// compiling and vaguely included
@@ -37,10 +55,12 @@ type ZoneLogging struct {
 }
 
 type Record struct {
-	RR     dns.RR
-	Weight int
-	Loc    *geo.Location
-	Test   string
+	RR       dns.RR
+	Weight   int
+	Loc      *geo.Location
+	Test     string
+	Schedule *Schedule
+	Ramp     *Ramp // the weight changes over time, if set
 }
 
 type Records []*Record
@@ -56,10 +76,18 @@ type Label struct {
 	Label    string
 	MaxHosts int
 	Ttl      int
+	TypeTtl  map[uint16]int // TTLs for the record types
 	Records  map[uint16]Records
 	Weight   map[uint16]int
 	Closest  bool
 	Test     health.HealthTester
+	Schedule *Schedule // when the label is active, if it has a schedule
+
+	// scheduled is set if any of the records have a schedule
+	scheduled bool
+
+	// ramped is set if any of the records have a weight ramp
+	ramped bool
 }
 
 type LabelMatch struct {
@@ -85,6 +113,25 @@ type Zone struct {
 	HealthStatus health.Status
 	healthExport bool
 
+	// Signer is set if the zone has DNSSEC keys
+	Signer *Signer
+
+	// lookupZone finds other zones for aliases pointing outside the zone
+	lookupZone func(name string) *Zone
+
+	// updateZone applies dynamic updates, for zones from files that can
+	// be changed
+	updateZone func(name string, prereqs, updates []dns.RR) uint16
+
+	// secondary is set for zones transferred from a primary
+	secondary *secondary
+
+	// reverse is set for reverse zones with generated PTR records
+	reverse *reverseSource
+
+	// labels and records with a schedule
+	schedules []scheduled
+
 	sync.RWMutex
 }
 
@@ -98,6 +145,10 @@ func NewZone(name string) *Zone {
 	zone.Options.Ttl = 120
 	zone.Options.MaxHosts = 2
 	zone.Options.Contact = "hostmaster." + name
+	zone.Options.Refresh = 5400
+	zone.Options.Retry = 5400
+	zone.Options.Expire = 1209600
+	zone.Options.NegativeTtl = 3600
 	zone.Options.Targeting = targeting.TargetGlobal + targeting.TargetCountry + targeting.TargetContinent
 
 	return zone
@@ -144,6 +195,7 @@ func (z *Zone) AddLabel(k string) *Label {
 
 	label.Records = make(map[uint16]Records)
 	label.Weight = make(map[uint16]int)
+	label.TypeTtl = make(map[uint16]int)
 
 	return label
 }
@@ -152,10 +204,32 @@ func (z *Zone) SoaRR() dns.RR {
 	return z.Labels[""].FirstRR(dns.TypeSOA)
 }
 
+// NegativeSoaRR returns the SOA record for negative answers, with the
+// negative caching TTL (RFC 2308).
+func (z *Zone) NegativeSoaRR() dns.RR {
+	rr := z.SoaRR().Clone()
+	rr.Header().TTL = z.NegativeTTL()
+	return rr
+}
+
 func (zone *Zone) AddSOA() {
 	zone.addSOA()
 }
 
+// ServerAddress parses the address of another DNS server (an also-notify
+// target or a primary); an IP address with an optional port, for example
+// "192.0.2.1" or "[2001:db8::1]:5353".
+func ServerAddress(s string) (netip.AddrPort, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap, nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid server address '%s'", s)
+	}
+	return netip.AddrPortFrom(ip, 53), nil
+}
+
 func (zone *Zone) addSOA() {
 	label := zone.Labels[""]
 
@@ -164,21 +238,25 @@ func (zone *Zone) addSOA() {
 	// log.Println("LABEL", label)
 
 	if label == nil {
-		log.Println(zone.Origin, "doesn't have any 'root' records,",
-			"you should probably add some NS records")
 		label = zone.AddLabel("")
 	}
 
 	if record, ok := label.Records[dns.TypeNS]; ok {
 		primaryNs = record[0].RR.(*dns.NS).Ns
 	}
-
-	ttl := zone.Options.Ttl * 10
-	if ttl > 3600 {
-		ttl = 3600
+	if len(zone.Options.PrimaryNs) > 0 {
+		primaryNs = zone.Options.PrimaryNs
 	}
+
+	ttl := zone.Options.SoaTtl
 	if ttl == 0 {
-		ttl = 600
+		ttl = zone.Options.Ttl * 10
+		if ttl > 3600 {
+			ttl = 3600
+		}
+		if ttl == 0 {
+			ttl = 600
+		}
 	}
 
 	rr := &dns.SOA{
@@ -191,10 +269,10 @@ func (zone *Zone) addSOA() {
 			Ns:      dnsutil.Fqdn(primaryNs),
 			Mbox:    dnsutil.Fqdn(zone.Options.Contact),
 			Serial:  uint32(zone.Options.Serial),
-			Refresh: 5400,
-			Retry:   5400,
-			Expire:  1209600,
-			Minttl:  3600,
+			Refresh: uint32(zone.Options.Refresh),
+			Retry:   uint32(zone.Options.Retry),
+			Expire:  uint32(zone.Options.Expire),
+			Minttl:  uint32(zone.Options.NegativeTtl),
 		},
 	}
 
@@ -220,23 +298,35 @@ func (z *Zone) findFirstLabel(s string, targets []string, qts []uint16) *LabelMa
 // matches the targeting will allow so health check filtering won't
 // filter out the "best" results leaving no others.
 func (z *Zone) FindLabels(s string, targets []string, qts []uint16) []LabelMatch {
+	return z.findLabels(s, targets, qts, 0)
+}
+
+func (z *Zone) findLabels(s string, targets []string, qts []uint16, depth int) []LabelMatch {
 	matches := make([]LabelMatch, 0)
 
+	// wildcards only apply to names that aren't in the zone
+	exact := slices.ContainsFunc(targets, func(target string) bool {
+		_, ok := z.Labels[targetName(s, target)]
+		return ok
+	})
+
+	var wildcard *Label
+
 	for _, target := range targets {
-		var name string
-
-		switch target {
-		case "@":
-			name = s
-		default:
-			if len(s) > 0 {
-				name = s + "." + target
-			} else {
-				name = target
+		label, ok := z.Labels[targetName(s, target)]
+		// labels outside their schedule are skipped, for the next target
+		ok = ok && label.Schedule.IsActive()
+		isWildcard := false
+		if !ok && !exact {
+			if label = z.findWildcard(s, target); label != nil {
+				ok, isWildcard = true, true
+				if wildcard == nil {
+					wildcard = label
+				}
 			}
 		}
 
-		if label, ok := z.Labels[name]; ok {
+		if ok {
 			var name string
 			for _, qtype := range qts {
 				switch qtype {
@@ -244,13 +334,22 @@ func (z *Zone) FindLabels(s string, targets []string, qts []uint16) []LabelMatch
 					// short-circuit mostly to avoid subtle bugs later
 					// to be correct we should run through all the selectors and
 					// pick types not already picked
-					matches = append(matches, LabelMatch{z.Labels[s], qtype})
+					if isWildcard {
+						matches = append(matches, LabelMatch{label, qtype})
+					} else {
+						matches = append(matches, LabelMatch{z.Labels[s], qtype})
+					}
 					continue
 				case dns.TypeMF:
 					if label.Records[dns.TypeMF] != nil {
 
+						if depth >= maxAliasDepth {
+							applog.Printf("[zone %s] too many aliases following '%s'", z.Origin, s)
+							continue
+						}
+
 						// don't follow NS and SOA records for aliases
-						aliasQts := slices.DeleteFunc(qts, func(q uint16) bool {
+						aliasQts := slices.DeleteFunc(slices.Clone(qts), func(q uint16) bool {
 							if slices.Contains(
 								[]uint16{dns.TypeNS, dns.TypeSOA},
 								q) {
@@ -260,8 +359,7 @@ func (z *Zone) FindLabels(s string, targets []string, qts []uint16) []LabelMatch
 						})
 
 						name = label.FirstRR(dns.TypeMF).(*dns.MF).Mf
-						// TODO: need to avoid loops here somehow
-						aliases := z.FindLabels(name, targets, aliasQts)
+						aliases := z.findAlias(name, targets, aliasQts, depth+1)
 						matches = append(matches, aliases...)
 						continue
 					}
@@ -281,12 +379,74 @@ func (z *Zone) FindLabels(s string, targets []string, qts []uint16) []LabelMatch
 		// appropriate.
 		if label, ok := z.Labels[s]; ok {
 			matches = append(matches, LabelMatch{label, 0})
+		} else if wildcard != nil {
+			matches = append(matches, LabelMatch{wildcard, 0})
 		}
 	}
 
 	return matches
 }
 
+// FindDelegation returns the zone cut at or above the label s, that is
+// the topmost label below the apex with NS records, using the targeting
+// like FindLabels. The name returned is the label without the
+// targeting suffix.
+func (z *Zone) FindDelegation(s string, targets []string) (string, *Label) {
+	if len(s) == 0 {
+		return "", nil
+	}
+
+	parts := strings.Split(s, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		name := strings.Join(parts[i:], ".")
+		for _, target := range targets {
+			if label, ok := z.Labels[targetName(name, target)]; ok && len(label.Records[dns.TypeNS]) > 0 {
+				return name, label
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// targetName returns the label name for s in the targeting group.
+func targetName(s, target string) string {
+	switch target {
+	case "@":
+		return s
+	default:
+		if len(s) > 0 {
+			return s + "." + target
+		}
+		return target
+	}
+}
+
+// findWildcard returns the wildcard label (for example "*" or "*.europe")
+// that synthesizes records for s, following the closest encloser rules
+// in RFC 4592: only a wildcard directly below the closest existing
+// ancestor of s applies. The zone reader adds labels for the empty
+// non-terminals, so a plain lookup tells if a name exists.
+func (z *Zone) findWildcard(s, target string) *Label {
+	for name := s; len(name) > 0; {
+		parent := ""
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			parent = name[i+1:]
+		}
+
+		if len(parent) == 0 {
+			return z.Labels[targetName("*", target)]
+		}
+		if _, ok := z.Labels[targetName(parent, target)]; ok {
+			return z.Labels[targetName("*."+parent, target)]
+		}
+
+		name = parent
+	}
+
+	return nil
+}
+
 // Find the locations of all the A and AAAA records within a zone. If we were
 // being really clever here we could use LOC records too. But for the time
 // being we'll just use GeoIP.
@@ -324,7 +484,7 @@ func (z *Zone) SetLocations() {
 	}
 }
 
-func (z *Zone) addHealthReference(l *Label, data interface{}) {
+func (z *Zone) addHealthReference(l *Label, data interface{}) error {
 	// First safely get rid of any old test. As label tests
 	// should never run this should never be executed
 	// if l.Test != nil {
@@ -333,17 +493,17 @@ func (z *Zone) addHealthReference(l *Label, data interface{}) {
 	// }
 
 	if data == nil {
-		return
+		return nil
 	}
 
 	if i, ok := data.(map[string]interface{}); ok {
 		tester, err := health.NewReferenceFromMap(i)
 		if err != nil {
-			applog.Printf("Could not setup reference to health check: %s", err)
-			return
+			return fmt.Errorf("could not setup reference to health check: %s", err)
 		}
 		l.Test = tester
 	}
+	return nil
 }
 
 func (z *Zone) setupHealthTests() {
@@ -477,3 +637,34 @@ func (z *Zone) HealthRR(label string, baseLabel string) []dns.RR {
 
 	return []dns.RR{&dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: []string{string(js)}}}}
 }
+
+// WeightsRR returns a TXT record with the current weight of each weighted
+// record of baseLabel, for the _weights debug query.
+func (z *Zone) WeightsRR(label string, baseLabel string) []dns.RR {
+	l, ok := z.Labels[baseLabel]
+	if !ok {
+		return nil
+	}
+
+	qtypes := make([]uint16, 0, len(l.Records))
+	for qtype := range l.Records {
+		if l.Weight[qtype] > 0 || l.ramped {
+			qtypes = append(qtypes, qtype)
+		}
+	}
+	slices.Sort(qtypes)
+
+	now := time.Now()
+	rrs := []dns.RR{}
+	for _, qtype := range qtypes {
+		for _, r := range l.Records[qtype] {
+			txt := []string{strings.Join(strings.Fields(r.RR.String())[3:], " "), fmt.Sprintf("weight=%d", r.WeightAt(now))}
+			if r.Ramp != nil {
+				txt = append(txt, "ramp="+r.Ramp.String())
+			}
+			h := dns.Header{Name: label, TTL: 1, Class: dns.ClassINET}
+			rrs = append(rrs, &dns.TXT{Hdr: h, TXT: rdata.TXT{Txt: txt}})
+		}
+	}
+	return rrs
+}
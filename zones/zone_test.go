@@ -167,4 +167,75 @@ func TestExampleOrgZone(t *testing.T) {
 	if l := len(Ns); l != 2 {
 		t.Fatalf("Expected 2 NS records, got '%d'", l)
 	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		cut     string
+		label   string
+	}{
+		{"sub", []string{"@"}, "sub", "sub"},
+		{"a.b.sub", []string{"@"}, "sub", "sub"},
+		{"ns1.glue", []string{"@"}, "glue", "glue"},
+		{"www.glue", []string{"dk", "europe", "@"}, "glue", "glue.europe"},
+		{"bar", []string{"@"}, "", ""},
+		{"", []string{"@"}, "", ""},
+	}
+	for _, tc := range tests {
+		cut, label := ex.FindDelegation(tc.name, tc.targets)
+		if cut != tc.cut {
+			t.Errorf("%s: got cut '%s', expected '%s'", tc.name, cut, tc.cut)
+		}
+		if label == nil {
+			if tc.label != "" {
+				t.Errorf("%s: expected delegation label '%s'", tc.name, tc.label)
+			}
+			continue
+		}
+		if label.Label != tc.label {
+			t.Errorf("%s: got label '%s', expected '%s'", tc.name, label.Label, tc.label)
+		}
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	mm, err := NewMuxManager("../dns", &NilReg{})
+	if err != nil {
+		t.Fatalf("Loading test zones: %s", err)
+	}
+	ex := mm.zonelist["test.example.com"]
+
+	tests := []struct {
+		name    string
+		targets []string
+		label   string
+	}{
+		{"foo.wild", []string{"@"}, "*.wild"},
+		{"foo.wild", []string{"dk", "europe", "@"}, "*.wild.europe"},
+		{"a.b.wild", []string{"us", "north-america", "@"}, "*.wild"},
+		{"exact.wild", []string{"dk", "europe", "@"}, "exact.wild"},
+	}
+
+	for _, tc := range tests {
+		m := ex.findFirstLabel(tc.name, tc.targets, []uint16{dns.TypeA})
+		if m == nil {
+			t.Errorf("%s: no match, expected '%s'", tc.name, tc.label)
+			continue
+		}
+		if m.Label.Label != tc.label || m.Type != dns.TypeA {
+			t.Errorf("%s: got label '%s' (type %d), expected '%s'", tc.name, m.Label.Label, m.Type, tc.label)
+		}
+	}
+
+	// "ent.wild" exists (it has a sub-label) so it's the closest
+	// encloser and "*.wild" doesn't apply below it
+	if m := ex.findFirstLabel("foo.ent.wild", []string{"@"}, []uint16{dns.TypeA}); m != nil {
+		t.Errorf("foo.ent.wild: expected no match, got '%s'", m.Label.Label)
+	}
+
+	// no A records on the wildcard, but the name exists
+	matches := ex.FindLabels("foo.wild", []string{"@"}, []uint16{dns.TypeTXT})
+	if len(matches) != 1 || matches[0].Type != 0 || matches[0].Label.Label != "*.wild" {
+		t.Errorf("foo.wild TXT: expected NODATA match on '*.wild', got %+v", matches)
+	}
 }